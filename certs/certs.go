@@ -0,0 +1,15 @@
+// Package certs holds what camctl and camera-signd share about TLS
+// certificates, so the two always agree.
+package certs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Fingerprint returns the hex-encoded SHA-256 hash of a DER-encoded
+// certificate, which camera-signd logs when it starts and camctl pins.
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
@@ -2,149 +2,369 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"path/filepath"
-	"runtime"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"carvers.dev/camera-sign/device"
 	"github.com/mitchellh/cli"
+	"yall.in"
 )
 
-func checkCommandFactory(ctx context.Context, ui cli.Ui) func() (cli.Command, error) {
+func checkCommandFactory(ctx context.Context, ui *formatUi, config clientConfig) func() (cli.Command, error) {
 	return func() (cli.Command, error) {
 		return checkCommand{
-			ui:  ui,
-			ctx: ctx,
+			ui:     ui,
+			ctx:    ctx,
+			config: config,
 		}, nil
 	}
 }
 
 type checkCommand struct {
-	ui  cli.Ui
-	ctx context.Context
+	ui     *formatUi
+	ctx    context.Context
+	config clientConfig
 }
 
 func (c checkCommand) Help() string {
-	helpText := `Usage: camctl check [opts]`
-	if runtime.GOOS == "windows" {
-		helpText += ` [devices]`
-	}
-	helpText += `
+	return `Usage: camctl check [opts] [devices]
 
-Checks whether a camera is in use according to the options`
-	if runtime.GOOS == "windows" {
-		helpText += ` and devices`
-	}
-	helpText += `
+Checks whether a camera is in use according to the options and devices
 specified, and reports the results to the server.
 
 When -dry-run is specified, the result will only be printed to the terminal,
-it will not be reported to the server.`
-	if runtime.GOOS == "windows" {
-		helpText += `
+it will not be reported to the server.
+
+Cameras are checked by detectors, which are set in the config file's
+detection section, along with whether a camera is on when any detector says
+so, or only when all of them do. By default, the first available detector of
+this platform's defaults is used. This platform's detectors are ` + strings.Join(device.Detectors(), ", ") + `.
+
+Detectors that check microphones, like consentstore-mic, are combined
+separately from the ones that check cameras, and report whether the
+microphone is in use, so the sign can show a call without video.
+
+Every detector runs in parallel. -timeout sets how long to wait for each
+detector; it defaults to 10s. When -all is specified, the result is a full
+report of each device's name, path, whether it's in use, which processes are
+using it, and which detector said so.
+
+Which cameras are checked, and which processes count as using them, can be
+limited with the devices and processes include and exclude rules in the
+config file. Rules are patterns, where * matches anything. Device rules match
+a camera's name, USB ID, or driver, and process rules match a process's
+command name, executable, or cgroup. Prefix a rule with name:, usb:,
+driver:, comm:, exe:, or cgroup: to only match that.
 
 When -processes is specified, it accepts a comma-separated list of process
-substrings to search for. Only those processes usage of the webcam will be
-reported. This speeds up the command considerably.
+substrings to search for, which are added to the processes include rules.
+Only those processes usage of the webcam will be reported. Each substring
+matches any process whose command name or executable contains it, so
+-processes chrome matches chrome and chrome-bin. On windows, this speeds up
+the command considerably.
 
-devices should be a comma-separated list of Physical Device Object names,
-retrieved from Device Manager.`
-	}
-	return helpText
+devices is a comma-separated list of device paths to check instead of every
+camera that's listed, like /dev/video0 on linux, or Physical Device Object
+names, as shown in Device Manager, on windows.`
 }
 
 func (c checkCommand) Synopsis() string {
 	return "Check whether a camera is in use"
 }
 
-func (c checkCommand) Run(args []string) int {
-	// Windows can't list devices, so it can't check them all
-	// so we need to tell it which specific devices to check.
-	// It also has to check the handles for every single process
-	// because it has no mapping of processes by handler. This
-	// takes many seconds, and that's silly. We can speed it up
-	// by giving it a list of process substrings to check against.
-	var processes, devicePaths []string
-	var dryRun bool
-	var processesString string
-	var err error
+// checkResult is the result of checking this machine's cameras.
+type checkResult struct {
+	CameraOn bool `json:"cameraOn"`
+	MicOn    bool `json:"micOn"`
+	// Mode is how the detectors' results were combined.
+	Mode string `json:"mode"`
+	// Devices are the devices each detector checked.
+	Devices []deviceResult `json:"devices"`
+	// Reported is whether the result was sent to the server. watch
+	// sends results in the background, so for watch, it's whether the
+	// result was queued to be sent.
+	Reported bool `json:"reported"`
 
-	f := flag.NewFlagSet("check", flag.ContinueOnError)
-	f.SetOutput(ioutil.Discard)
-	// Set the default Usage to empty
-	f.Usage = func() {}
+	// all is whether to print a full report.
+	all bool
+}
 
-	f.BoolVar(&dryRun, "dry-run", false, "should the result not be reported to the server")
-	if runtime.GOOS == "windows" {
-		f.StringVar(&processesString, "processes", "", "a comma-separated list of process substrings to limit your search to.")
+type deviceResult struct {
+	// Detector is the detector that checked the device.
+	Detector string `json:"detector"`
+	// Device is empty for detectors that check every camera at once, or
+	// if the detector couldn't check any.
+	Device string `json:"device,omitempty"`
+	// Name is set on platforms that can list cameras.
+	Name string `json:"name,omitempty"`
+	// Microphone is set if the device is a microphone, rather than a
+	// camera.
+	Microphone bool `json:"microphone,omitempty"`
+	InUse      bool `json:"inUse"`
+	// Holders is set on platforms that can tell which processes are
+	// using a device.
+	Holders []holderResult `json:"holders,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+type holderResult struct {
+	PID     int    `json:"pid"`
+	Command string `json:"command,omitempty"`
+	User    string `json:"user,omitempty"`
+	Exe     string `json:"exe,omitempty"`
+	Cgroup  string `json:"cgroup,omitempty"`
+}
+
+func (h holderResult) String() string {
+	s := fmt.Sprintf("%s (%d)", h.Command, h.PID)
+	if h.User != "" {
+		s += " as " + h.User
 	}
+	return s
+}
 
-	f.Parse(args)
+// label is how the device is described in text output.
+func (d deviceResult) label() string {
+	if d.Microphone {
+		return "microphone"
+	}
+	if d.Device != "" {
+		return "device " + d.Device
+	}
+	if d.Name != "" {
+		return "camera " + d.Name
+	}
+	return "camera"
+}
 
-	var numArgs int
-	if runtime.GOOS == "windows" {
-		numArgs = 1
+func (r checkResult) String() string {
+	if r.all {
+		return r.table()
 	}
-	if len(f.Args()) != numArgs {
-		c.ui.Error(fmt.Sprintf("Incorrect number of arguments. check command expects %d args, got %d.", numArgs, len(f.Args())))
-		return 1
+	var lines []string
+	for _, dev := range r.Devices {
+		switch {
+		case dev.Error != "" && dev.Device == "" && dev.Name == "":
+			lines = append(lines, fmt.Sprintf("Error running detector %s: %s", dev.Detector, dev.Error))
+		case dev.Error != "":
+			lines = append(lines, fmt.Sprintf("Error checking if %s is in use: %s", dev.label(), dev.Error))
+		default:
+			label := dev.label()
+			lines = append(lines, fmt.Sprintf("%s%s is%s in use", strings.ToUpper(label[:1]), label[1:], notStr(dev.InUse)))
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "No cameras found")
 	}
+	return strings.Join(lines, "\n")
+}
 
-	processes = strings.Split(processesString, ",")
-	for pos, p := range processes {
-		processes[pos] = strings.TrimSpace(p)
+// table is the full report of every device.
+func (r checkResult) table() string {
+	if len(r.Devices) == 0 {
+		return "No cameras found"
 	}
-	if runtime.GOOS == "windows" {
-		if len(f.Args()) > 0 {
-			devicePaths = strings.Split(f.Args()[0], ",")
-			for pos, p := range devicePaths {
-				devicePaths[pos] = strings.TrimSpace(p)
+	var buf strings.Builder
+	table := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "DETECTOR\tDEVICE\tNAME\tIN USE\tHOLDERS")
+	for _, dev := range r.Devices {
+		path, name, inUse, holders := dev.Device, dev.Name, yesNo(dev.InUse), "-"
+		if path == "" {
+			path = "-"
+		}
+		if name == "" {
+			name = "-"
+		}
+		if dev.Error != "" {
+			inUse = "error: " + dev.Error
+		}
+		if len(dev.Holders) > 0 {
+			var hs []string
+			for _, h := range dev.Holders {
+				hs = append(hs, h.String())
 			}
+			holders = strings.Join(hs, ", ")
 		}
-	} else if runtime.GOOS == "linux" {
-		devicePaths, err = filepath.Glob("/dev/video*")
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", dev.Detector, path, name, inUse, holders)
+	}
+	table.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// state is what's reported to the server.
+func (r checkResult) state() sensorState {
+	return sensorState{CameraOn: r.CameraOn, MicOn: r.MicOn}
+}
+
+func notStr(b bool) string {
+	if b {
+		return ""
+	}
+	return " not"
+}
+
+// processFilter returns the configured process filter, with the
+// comma-separated process substrings of the -processes flag added to its
+// Include rules. Rules match whole names, so each substring becomes a rule
+// matching anything containing it.
+func processFilter(config clientConfig, processesString string) device.ProcessFilter {
+	filter := config.Processes
+	filter.Include = append([]string{}, filter.Include...)
+	for _, p := range strings.Split(processesString, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			filter.Include = append(filter.Include, "*"+p+"*")
+		}
+	}
+	return filter
+}
+
+// newDetectors builds the detectors in config, or the default detector if
+// none are configured, to check devices, the comma-separated device paths
+// in args, or every camera the config's device filter matches if there are
+// no args. Configured detectors that aren't available on this machine are
+// skipped. If watch is true, the detectors check repeatedly until ctx is
+// done.
+func newDetectors(ctx context.Context, watch bool, config clientConfig, args []string, processes device.ProcessFilter) ([]device.Detector, error) {
+	detectorConfig := device.DetectorConfig{
+		DeviceFilter: config.Devices,
+		Processes:    processes,
+	}
+	if watch {
+		detectorConfig.Watch = ctx
+	}
+	if len(args) > 0 {
+		for _, p := range strings.Split(args[0], ",") {
+			detectorConfig.Devices = append(detectorConfig.Devices, strings.TrimSpace(p))
+		}
+	}
+	names := config.Detection.Detectors
+	if len(names) == 0 {
+		name, err := device.DefaultDetector(ctx)
 		if err != nil {
-			c.ui.Error("Error listing devices: " + err.Error())
-			return 1
+			return nil, err
 		}
-	} else if runtime.GOOS == "darwin" {
-		// darwin doesn't use files or handlers to check
-		// we just call out to a binary that checks all our
-		// devices for us. So we just set a single empty
-		// path, because it's not going to be used anyways,
-		// and setting only one makes sure we call the code
-		// just the once.
-		devicePaths = append(devicePaths, "")
-	}
-
-	var webcamOn bool
-	for _, dev := range devicePaths {
-		inUse, err := device.InUse(c.ctx, dev, processes...)
+		names = []string{name}
+	}
+	var detectors []device.Detector
+	for _, name := range names {
+		detector, err := device.NewDetector(name, detectorConfig)
 		if err != nil {
-			c.ui.Error("Error checking if device in use: " + err.Error())
-			return 1
+			return nil, err
 		}
-		inUseStr := ""
-		if !inUse {
-			inUseStr = " not"
+		err = detector.Available(ctx)
+		if err != nil {
+			yall.FromContext(ctx).WithField("detector", name).WithError(err).Warn("detector isn't available, skipping it")
+			continue
 		}
-		if dev != "" {
-			c.ui.Info(fmt.Sprintf("Device %s is%s in use", dev, inUseStr))
-		} else {
-			c.ui.Info(fmt.Sprintf("Camera is%s in use", inUseStr))
+		detectors = append(detectors, detector)
+	}
+	if len(detectors) == 0 {
+		return nil, errors.New("None of the configured detectors are available.")
+	}
+	return detectors, nil
+}
+
+// checkDevices runs detectors, and combines their results using mode.
+// Devices that can't be checked don't stop the others from being checked;
+// the first error is returned along with the results.
+func checkDevices(ctx context.Context, detectors []device.Detector, mode device.Mode, timeout time.Duration) (checkResult, error) {
+	reports := device.Detect(ctx, detectors, timeout)
+	result := checkResult{
+		CameraOn: mode.CameraOn(reports),
+		MicOn:    mode.MicOn(reports),
+		Mode:     string(mode),
+		Devices:  []deviceResult{},
+	}
+	var firstErr error
+	for _, report := range reports {
+		if report.Err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("Error running detector %s: %w", report.Detector, report.Err)
+			}
+			result.Devices = append(result.Devices, deviceResult{Detector: report.Detector, Error: report.Err.Error()})
+			continue
 		}
-		if inUse {
-			webcamOn = true
-			break
+		for _, usage := range report.Usages {
+			res := deviceResult{
+				Detector:   report.Detector,
+				Device:     usage.Device.Path,
+				Name:       usage.Device.Name,
+				Microphone: usage.Microphone,
+				InUse:      usage.InUse,
+			}
+			if usage.Err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("Error checking if device %s in use: %w", usage.Device.Path, usage.Err)
+				}
+				res.Error = usage.Err.Error()
+			}
+			for _, h := range usage.Holders {
+				res.Holders = append(res.Holders, holderResult{PID: h.PID, Command: h.Command, User: h.User, Exe: h.Exe, Cgroup: h.Cgroup})
+			}
+			result.Devices = append(result.Devices, res)
 		}
 	}
-	err = update(c.ctx, webcamOn)
+	return result, firstErr
+}
+
+func (c checkCommand) Run(args []string) int {
+	var dryRun, all bool
+	var processesString string
+	var timeout time.Duration
+
+	f := flag.NewFlagSet("check", flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+	// Set the default Usage to empty
+	f.Usage = func() {}
+
+	f.BoolVar(&dryRun, "dry-run", false, "should the result not be reported to the server")
+	f.BoolVar(&all, "all", false, "print a full report of every device, and which processes are using them")
+	f.DurationVar(&timeout, "timeout", 10*time.Second, "how long to wait for each detector")
+	f.StringVar(&processesString, "processes", "", "a comma-separated list of process substrings to limit your search to.")
+
+	f.Parse(args)
+
+	if len(f.Args()) > 1 {
+		return c.ui.Fail(exitUsage, fmt.Errorf("Incorrect number of arguments. check command expects at most 1 arg, got %d.", len(f.Args())))
+	}
+
+	mode, err := device.ParseMode(c.config.Detection.Mode)
+	if err != nil {
+		return c.ui.Fail(exitConfig, err)
+	}
+	detectors, err := newDetectors(c.ctx, false, c.config, f.Args(), processFilter(c.config, processesString))
+	if errors.Is(err, device.ErrUnknownDetector) {
+		return c.ui.Fail(exitConfig, err)
+	}
+	if err != nil {
+		return c.ui.Fail(exitDevice, err)
+	}
+
+	result, err := checkDevices(c.ctx, detectors, mode, timeout)
+	result.all = all
+	if err != nil {
+		c.ui.Result(result, result.String())
+		return c.ui.Fail(exitDevice, err)
+	}
+	if dryRun {
+		c.ui.Result(result, result.String())
+		return exitOK
+	}
+	client, err := newAPIClient(c.config)
+	if err != nil {
+		return c.ui.Fail(exitConfig, err)
+	}
+	err = client.update(c.ctx, result.state())
 	if err != nil {
-		c.ui.Error(err.Error())
-		return 1
+		c.ui.Result(result, result.String())
+		return c.ui.Fail(exitServer, err)
 	}
-	return 0
+	result.Reported = true
+	c.ui.Result(result, result.String())
+	return exitOK
 }
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"carvers.dev/camera-sign/device"
+)
+
+func TestProcessFilter(t *testing.T) {
+	config := clientConfig{}
+	config.Processes = device.ProcessFilter{
+		Include: []string{"comm:zoom"},
+		Exclude: []string{"cgroup:*/pipewire.service"},
+	}
+	filter := processFilter(config, " chrome, ,firefox")
+	expected := device.ProcessFilter{
+		Include: []string{"comm:zoom", "*chrome*", "*firefox*"},
+		Exclude: []string{"cgroup:*/pipewire.service"},
+	}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("expected %+v, got %+v", expected, filter)
+	}
+	if !filter.Match(device.Holder{Command: "chrome-bin"}) {
+		t.Error("expected -processes chrome to match chrome-bin")
+	}
+	// the config's rules aren't changed
+	if len(config.Processes.Include) != 1 {
+		t.Errorf("expected the config's include rules to be left alone, got %+v", config.Processes.Include)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"carvers.dev/camera-sign/certs"
+	"yall.in"
+)
+
+// apiClient talks to camera-signd.
+type apiClient struct {
+	server string
+	http   *http.Client
+	tls    *tls.Config
+
+	// requireTLS is true when the config says how to trust the server,
+	// so only https servers are used.
+	requireTLS bool
+
+	// discovered is true when the server was found over mDNS instead of
+	// being configured, so it can be found again if it moves.
+	discovered bool
+}
+
+// newAPIClient builds a client for the configured server. If no server is
+// configured, it's discovered over mDNS when the first request is made.
+func newAPIClient(config clientConfig) (*apiClient, error) {
+	tlsConfig, err := config.TLS.build()
+	if err != nil {
+		return nil, err
+	}
+	c := &apiClient{
+		tls:        tlsConfig,
+		requireTLS: config.TLS.required(),
+		http: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}
+	if config.Server != "" {
+		err = c.checkServer(config.Server)
+		if err != nil {
+			return nil, err
+		}
+		c.server = strings.TrimSuffix(config.Server, "/")
+	}
+	return c, nil
+}
+
+// checkServer makes sure server can be trusted the way the config says:
+// when it pins the server's certificate or CA, or has a client
+// certificate to present, only https will do. Otherwise a plain http
+// server, configured by mistake, or advertised by anyone on the network,
+// would be sent statuses that the pinning was meant to protect.
+func (c *apiClient) checkServer(server string) error {
+	if !c.requireTLS {
+		return nil
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return fmt.Errorf("invalid server URL %q: %w", server, err)
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("refusing to use %s: TLS is configured, so the server has to use https", server)
+	}
+	return nil
+}
+
+// do sends a request to path on the server. If the server was discovered
+// and can't be reached, it's discovered again, in case it's moved, and the
+// request is retried.
+func (c *apiClient) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	if c.server == "" {
+		err := c.discover(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.send(ctx, method, path, body)
+	if err == nil || !c.discovered || ctx.Err() != nil {
+		return resp, err
+	}
+	previous := c.server
+	if discoverErr := c.discover(ctx, true); discoverErr != nil || c.server == previous {
+		return nil, err
+	}
+	yall.FromContext(ctx).WithField("from", previous).WithField("to", c.server).Info("server moved")
+	return c.send(ctx, method, path, body)
+}
+
+func (c *apiClient) send(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequest(method, c.server+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("Error building request: %w", err)
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	return c.http.Do(request.WithContext(ctx))
+}
+
+func (c *apiClient) discover(ctx context.Context, fresh bool) error {
+	server, err := discoverServer(ctx, fresh, c.requireTLS)
+	if err != nil {
+		return err
+	}
+	return c.use(server)
+}
+
+// use makes server, found over mDNS or cached from when it was, the server
+// requests are sent to.
+func (c *apiClient) use(server discoveredServer) error {
+	err := c.checkServer(server.URL)
+	if err != nil {
+		return err
+	}
+	c.server = strings.TrimSuffix(server.URL, "/")
+	c.discovered = true
+	// the URL usually has an IP address in it, so verify certificates
+	// against the advertised hostname instead
+	c.tls.ServerName = server.Host
+	return nil
+}
+
+func (c clientTLSConfig) build() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.Fingerprint != "" {
+		pin := normalizeFingerprint(c.Fingerprint)
+		// we check the pinned certificate ourselves, instead of
+		// verifying the chain, so self-signed certificates work
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("server presented no certificate")
+			}
+			got := certs.Fingerprint(rawCerts[0])
+			if got != pin {
+				return fmt.Errorf("server certificate fingerprint %s doesn't match pinned fingerprint %s", got, pin)
+			}
+			return nil
+		}
+	} else if c.CAFile != "" {
+		caPEM, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// required reports whether the config says how to trust the server, in
+// which case it has to be reached over https.
+func (c clientTLSConfig) required() bool {
+	return c.Fingerprint != "" || c.CAFile != "" || c.CertFile != ""
+}
+
+// normalizeFingerprint lets fingerprints be written in upper case or with
+// colons between bytes, like most tools print them.
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.Replace(fingerprint, ":", "", -1))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"carvers.dev/camera-sign/certs"
+	"carvers.dev/camera-sign/discovery"
+)
+
+func TestNewAPIClientRequiresHTTPS(t *testing.T) {
+	pinned := clientTLSConfig{Fingerprint: "ab:cd"}
+	cases := map[string]struct {
+		config clientConfig
+		err    bool
+	}{
+		"pinned-https": {
+			config: clientConfig{Server: "https://sign.example:9988", TLS: pinned},
+		},
+		"pinned-http": {
+			config: clientConfig{Server: "http://sign.example:9988", TLS: pinned},
+			err:    true,
+		},
+		"plain-http": {
+			config: clientConfig{Server: "http://sign.example:9988"},
+		},
+		"discovered-later": {
+			config: clientConfig{TLS: pinned},
+		},
+	}
+	for name, c := range cases {
+		_, err := newAPIClient(c.config)
+		if c.err && err == nil {
+			t.Errorf("%s: expected an error, got nil", name)
+		}
+		if !c.err && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		}
+	}
+}
+
+func TestPinnedClientRejectsPlainHTTPAdvertisement(t *testing.T) {
+	advertisement := func(tls string) discoveredServer {
+		return serviceServer(discovery.Service{
+			Instance: "camera-signd on sign",
+			Host:     "sign.local.",
+			Port:     9988,
+			Text:     []string{"path=/v1", "tls=" + tls},
+			IPs:      []net.IP{net.ParseIP("192.168.1.20")},
+		})
+	}
+
+	client, err := newAPIClient(clientConfig{TLS: clientTLSConfig{Fingerprint: "ab:cd"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	err = client.use(advertisement("0"))
+	if err == nil {
+		t.Error("expected a plain http server to be refused, got nil")
+	}
+	if client.server != "" {
+		t.Errorf("expected no server to be used, got %q", client.server)
+	}
+
+	err = client.use(advertisement("1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if client.server != "https://192.168.1.20:9988" {
+		t.Errorf("expected %q, got %q", "https://192.168.1.20:9988", client.server)
+	}
+	if client.tls.ServerName != "sign.local" {
+		t.Errorf("expected certificates to be verified for %q, got %q", "sign.local", client.tls.ServerName)
+	}
+
+	// without TLS configured, plain http is fine
+	client, err = newAPIClient(clientConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	err = client.use(advertisement("0"))
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestTLSServices(t *testing.T) {
+	service := func(instance string, text ...string) discovery.Service {
+		return discovery.Service{Instance: instance, Port: 9988, Text: text}
+	}
+	cases := map[string]struct {
+		services []discovery.Service
+		expected []string
+	}{
+		"spoofed-first": {
+			services: []discovery.Service{service("spoofed", "tls=0"), service("real", "tls=1")},
+			expected: []string{"real"},
+		},
+		"missing-key": {
+			services: []discovery.Service{service("old", "path=/v1"), service("real", "path=/v1", "tls=true")},
+			expected: []string{"real"},
+		},
+		"none": {
+			services: []discovery.Service{service("plain", "tls=0")},
+		},
+	}
+	for name, c := range cases {
+		var got []string
+		for _, svc := range tlsServices(context.Background(), c.services) {
+			got = append(got, svc.Instance)
+		}
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: expected %+v, got %+v", name, c.expected, got)
+		}
+	}
+}
+
+func TestPinnedFingerprint(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	// refused handshakes are expected
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+	pin := certs.Fingerprint(srv.Certificate().Raw)
+
+	var colons []string
+	for i := 0; i < len(pin); i += 2 {
+		colons = append(colons, strings.ToUpper(pin[i:i+2]))
+	}
+	cases := map[string]struct {
+		fingerprint string
+		ok          bool
+	}{
+		"match":        {fingerprint: pin, ok: true},
+		"colons-upper": {fingerprint: strings.Join(colons, ":"), ok: true},
+		"mismatch":     {fingerprint: certs.Fingerprint([]byte("another certificate"))},
+	}
+	for name, c := range cases {
+		config, err := clientTLSConfig{Fingerprint: c.fingerprint}.build()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		resp, err := client.Get(srv.URL)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%s: expected the server to be refused, got %s", name, resp.Status)
+		}
+		if !c.ok && err != nil && !strings.Contains(err.Error(), "doesn't match pinned fingerprint") {
+			t.Errorf("%s: expected a fingerprint mismatch, got %s", name, err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"carvers.dev/camera-sign/device"
+)
+
+// clientConfig is camctl's config file.
+type clientConfig struct {
+	// Server is the base URL of camera-signd, like
+	// https://sign.example:9988. If it's not set, camctl looks for
+	// camera-signd on the local network using mDNS.
+	Server string `json:"server,omitempty"`
+
+	TLS clientTLSConfig `json:"tls,omitempty"`
+
+	// Devices picks which cameras check and watch look at, like
+	// {"exclude": ["driver:v4l2*loopback"]} to skip virtual cameras.
+	Devices device.DeviceFilter `json:"devices,omitempty"`
+
+	// Processes picks which processes count as using a camera, like
+	// {"exclude": ["comm:pipewire", "comm:wireplumber"]} to ignore
+	// processes that keep cameras open all the time.
+	Processes device.ProcessFilter `json:"processes,omitempty"`
+
+	// Detection picks how cameras are checked.
+	Detection detectionConfig `json:"detection,omitempty"`
+}
+
+type detectionConfig struct {
+	// Detectors are the names of the detectors to run, like
+	// ["procfs", "pipewire"]. If it's empty, the first available of
+	// this platform's defaults is used.
+	Detectors []string `json:"detectors,omitempty"`
+
+	// Mode is how the detectors' results are combined: "any", the
+	// default, says a camera is on if any detector says so, and "all"
+	// only if every detector does.
+	Mode string `json:"mode,omitempty"`
+}
+
+// clientTLSConfig says how to trust camera-signd, and how to prove who we
+// are to it. When any of it is set, the server has to use https, whether
+// it's configured or found on the network.
+type clientTLSConfig struct {
+	// CAFile is a PEM-encoded CA to trust when verifying the server's
+	// certificate, instead of the system roots.
+	CAFile string `json:"caFile,omitempty"`
+
+	// Fingerprint pins the server's certificate, by the hex-encoded
+	// SHA-256 hash of its DER encoding. When set, it's the only
+	// certificate accepted, and CAFile is ignored. camctl enroll sets
+	// it.
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// CertFile and KeyFile are the client certificate to present, for
+	// servers that require one.
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+}
+
+// clientConfigPath returns where camctl's config file lives: the
+// CAMCTL_CONFIG environment variable, if set, or camera-sign/camctl.json
+// in the user's config directory.
+func clientConfigPath() (string, error) {
+	if path := os.Getenv("CAMCTL_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("can't find config directory: %w", err)
+	}
+	return filepath.Join(dir, "camera-sign", "camctl.json"), nil
+}
+
+// loadClientConfig reads the config file at path. A missing file is
+// treated as an empty config. The CAMCTL_SERVER environment variable
+// overrides the configured server.
+func loadClientConfig(path string) (clientConfig, error) {
+	var config clientConfig
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return config, fmt.Errorf("error reading config file: %w", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(b, &config)
+		if err != nil {
+			return config, fmt.Errorf("error parsing config file %s: %w", path, err)
+		}
+	}
+	if server := os.Getenv("CAMCTL_SERVER"); server != "" {
+		config.Server = server
+	}
+	return config, nil
+}
+
+func saveClientConfig(path string, config clientConfig) error {
+	b, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding config: %w", err)
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return fmt.Errorf("error creating config directory: %w", err)
+	}
+	err = ioutil.WriteFile(path, append(b, '\n'), 0600)
+	if err != nil {
+		return fmt.Errorf("error writing config file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"carvers.dev/camera-sign/discovery"
+	"yall.in"
+)
+
+// discoveredServer is a server found over mDNS, cached so camctl doesn't
+// have to browse for it every time it runs.
+type discoveredServer struct {
+	URL      string `json:"url"`
+	Host     string `json:"host"`
+	Instance string `json:"instance"`
+}
+
+// discoveryCachePath returns where the discovered server is cached:
+// camera-sign/server.json in the user's cache directory.
+func discoveryCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("can't find cache directory: %w", err)
+	}
+	return filepath.Join(dir, "camera-sign", "server.json"), nil
+}
+
+// discoverServer finds camera-signd on the local network. Unless fresh is
+// true, it returns the cached server if there is one. When requireTLS is
+// true, only servers that advertise TLS are considered, so a plain http
+// advertisement, which anyone on the network can send, can't stand in for
+// a server whose certificate is pinned.
+func discoverServer(ctx context.Context, fresh, requireTLS bool) (discoveredServer, error) {
+	log := yall.FromContext(ctx)
+	path, err := discoveryCachePath()
+	if err != nil {
+		log.WithError(err).Warn("not caching discovered server")
+	}
+	if !fresh && path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err == nil {
+			var cached discoveredServer
+			err = json.Unmarshal(b, &cached)
+			if err == nil && cached.URL != "" && (!requireTLS || cached.https()) {
+				return cached, nil
+			}
+		}
+	}
+
+	log.WithField("service", discovery.ServiceType).Debug("browsing for server")
+	browseCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	services, err := discovery.Browse(browseCtx, discovery.Group)
+	if err != nil {
+		return discoveredServer{}, fmt.Errorf("error browsing for server: %w", err)
+	}
+	if requireTLS {
+		services = tlsServices(ctx, services)
+	}
+	if len(services) < 1 {
+		if requireTLS {
+			return discoveredServer{}, errors.New("no server configured, and none using TLS found on the network")
+		}
+		return discoveredServer{}, errors.New("no server configured, and none found on the network")
+	}
+	if len(services) > 1 {
+		log.WithField("count", len(services)).WithField("using", services[0].Instance).Warn("found more than one server; set one in the config file to choose")
+	}
+	server := serviceServer(services[0])
+	log.WithField("instance", server.Instance).WithField("url", server.URL).Info("discovered server")
+
+	if path != "" {
+		b, err := json.MarshalIndent(server, "", "  ")
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(path), 0700)
+		}
+		if err == nil {
+			err = ioutil.WriteFile(path, append(b, '\n'), 0600)
+		}
+		if err != nil {
+			log.WithError(err).Warn("error caching discovered server")
+		}
+	}
+	return server, nil
+}
+
+// tlsServices returns the services that advertise TLS.
+func tlsServices(ctx context.Context, services []discovery.Service) []discovery.Service {
+	var kept []discovery.Service
+	for _, svc := range services {
+		if tls, _ := strconv.ParseBool(svc.TextValue("tls")); !tls {
+			yall.FromContext(ctx).WithField("instance", svc.Instance).Warn("ignoring server that doesn't advertise TLS, because TLS is configured")
+			continue
+		}
+		kept = append(kept, svc)
+	}
+	return kept
+}
+
+func (s discoveredServer) https() bool {
+	u, err := url.Parse(s.URL)
+	return err == nil && u.Scheme == "https"
+}
+
+// serviceServer builds the URL to reach svc at. It prefers an IPv4
+// address, because hostnames in .local often can't be resolved without
+// an mDNS-aware resolver, and link-local IPv6 addresses need a zone.
+func serviceServer(svc discovery.Service) discoveredServer {
+	host := strings.TrimSuffix(svc.Host, ".")
+	addr := host
+	for _, ip := range svc.IPs {
+		if ip.To4() != nil {
+			addr = ip.String()
+			break
+		}
+		if !ip.IsLinkLocalUnicast() && addr == host {
+			addr = ip.String()
+		}
+	}
+	scheme := "http"
+	if tls, _ := strconv.ParseBool(svc.TextValue("tls")); tls {
+		scheme = "https"
+	}
+	u := url.URL{Scheme: scheme, Host: net.JoinHostPort(addr, strconv.Itoa(svc.Port))}
+	return discoveredServer{URL: u.String(), Host: host, Instance: svc.Instance}
+}
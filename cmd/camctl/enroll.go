@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"carvers.dev/camera-sign/certs"
+	"github.com/mitchellh/cli"
+)
+
+func enrollCommandFactory(ctx context.Context, ui *formatUi, config clientConfig) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return enrollCommand{
+			ui:     ui,
+			ctx:    ctx,
+			config: config,
+		}, nil
+	}
+}
+
+type enrollCommand struct {
+	ui     *formatUi
+	ctx    context.Context
+	config clientConfig
+}
+
+func (e enrollCommand) Help() string {
+	return `Usage: camctl enroll [opts] [server]
+
+Connects to the server, shows the fingerprint of its TLS certificate, and
+once you confirm it matches the fingerprint camera-signd logged on startup,
+saves the server and pins its certificate in camctl's config file.
+
+server should be an https:// URL. If it's not specified, the configured
+server is used, or if none is configured, one is found on the local
+network.
+
+When -yes is specified, the certificate is trusted without asking.`
+}
+
+func (e enrollCommand) Synopsis() string {
+	return "Pin the server's TLS certificate"
+}
+
+func (e enrollCommand) Run(args []string) int {
+	var yes bool
+
+	f := flag.NewFlagSet("enroll", flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+	// Set the default Usage to empty
+	f.Usage = func() {}
+
+	f.BoolVar(&yes, "yes", false, "trust the server's certificate without asking")
+
+	f.Parse(args)
+
+	if len(f.Args()) > 1 {
+		return e.ui.Fail(exitUsage, fmt.Errorf("Incorrect number of arguments. enroll command expects at most 1 arg, got %d.", len(f.Args())))
+	}
+	server := e.config.Server
+	discovered := false
+	if len(f.Args()) > 0 {
+		server = f.Args()[0]
+	}
+	if server == "" {
+		// only https servers can be enrolled
+		found, err := discoverServer(e.ctx, true, true)
+		if err != nil {
+			return e.ui.Fail(exitServer, fmt.Errorf("No server specified, and none is configured: %w", err))
+		}
+		server = found.URL
+		discovered = true
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return e.ui.Fail(exitUsage, fmt.Errorf("Error parsing server URL: %w", err))
+	}
+	if u.Scheme != "https" {
+		return e.ui.Fail(exitUsage, errors.New("Only https:// servers can be enrolled."))
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	// we're fetching the certificate to decide whether to trust it, so
+	// there's nothing to verify it against yet
+	conn, err := tls.DialWithDialer(dialer, "tcp", host, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return e.ui.Fail(exitServer, fmt.Errorf("Error connecting to server: %w", err))
+	}
+	peerCerts := conn.ConnectionState().PeerCertificates
+	conn.Close()
+	if len(peerCerts) == 0 {
+		return e.ui.Fail(exitServer, errors.New("Server presented no certificate."))
+	}
+	cert := peerCerts[0]
+	fingerprint := certs.Fingerprint(cert.Raw)
+	e.ui.Info(fmt.Sprintf("Subject:     %s", cert.Subject))
+	e.ui.Info(fmt.Sprintf("Valid until: %s", cert.NotAfter.Format("2006-01-02 15:04:05")))
+	e.ui.Info(fmt.Sprintf("Fingerprint: %s", fingerprint))
+
+	if !yes {
+		answer, err := e.ui.Ask("Trust this certificate? [y/N]")
+		if err != nil {
+			return e.ui.Fail(exitError, fmt.Errorf("Error reading answer: %w", err))
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+		default:
+			return e.ui.Fail(exitError, errors.New("Not enrolling."))
+		}
+	}
+
+	path, err := clientConfigPath()
+	if err != nil {
+		return e.ui.Fail(exitConfig, err)
+	}
+	config := e.config
+	// a discovered server isn't saved, so it can still be found if it
+	// moves; only its certificate is pinned
+	if !discovered {
+		config.Server = strings.TrimSuffix(u.String(), "/")
+	}
+	config.TLS.Fingerprint = fingerprint
+	err = saveClientConfig(path, config)
+	if err != nil {
+		return e.ui.Fail(exitConfig, err)
+	}
+	e.ui.Result(map[string]interface{}{
+		"server":      server,
+		"subject":     cert.Subject.String(),
+		"notAfter":    cert.NotAfter,
+		"fingerprint": fingerprint,
+		"configPath":  path,
+	}, fmt.Sprintf("Enrolled with %s; saved to %s.", server, path))
+	return exitOK
+}
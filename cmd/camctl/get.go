@@ -2,23 +2,26 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/mitchellh/cli"
 )
 
-func getCommandFactory(ctx context.Context, ui cli.Ui) func() (cli.Command, error) {
+func getCommandFactory(ctx context.Context, ui *formatUi, config clientConfig) func() (cli.Command, error) {
 	return func() (cli.Command, error) {
 		return getCommand{
-			ui:  ui,
-			ctx: ctx,
+			ui:     ui,
+			ctx:    ctx,
+			config: config,
 		}, nil
 	}
 }
 
 type getCommand struct {
-	ui  cli.Ui
-	ctx context.Context
+	ui     *formatUi
+	ctx    context.Context
+	config clientConfig
 }
 
 func (g getCommand) Help() string {
@@ -33,19 +36,20 @@ func (g getCommand) Synopsis() string {
 }
 
 func (g getCommand) Run(args []string) int {
-	status, err := getStatus(g.ctx)
+	if len(args) != 0 {
+		return g.ui.Fail(exitUsage, fmt.Errorf("Incorrect number of arguments. get command expects 0 args, got %d.", len(args)))
+	}
+	client, err := newAPIClient(g.config)
 	if err != nil {
-		g.ui.Error(err.Error())
-		return 1
+		return g.ui.Fail(exitConfig, err)
 	}
-	if status == nil {
-		g.ui.Output("This device hasn't checked in with the server yet.")
-		return 0
+	status, err := client.getStatus(g.ctx)
+	if err != nil {
+		return g.ui.Fail(exitServer, err)
 	}
-	statStr := "off"
-	if status.CameraOn {
-		statStr = "on"
+	if status == nil {
+		return g.ui.Fail(exitNotFound, errors.New("This device hasn't checked in with the server yet."))
 	}
-	g.ui.Output(fmt.Sprintf("As of %s, the server thinks this device is %s.", status.LastSync.Format("2006-01-02 15:04:05"), statStr))
-	return 0
+	g.ui.Result(status, fmt.Sprintf("As of %s, the server thinks this device's camera is %s.", status.LastSync.Local().Format("2006-01-02 15:04:05"), onOff(status.CameraOn)))
+	return exitOK
 }
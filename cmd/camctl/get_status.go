@@ -6,17 +6,32 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"time"
-
-	"yall.in"
 )
 
 type Status struct {
+	Name     string    `json:"name,omitempty"`
 	CameraOn bool      `json:"cameraOn"`
+	MicOn    bool      `json:"micOn"`
 	LastSync time.Time `json:"lastSync"`
 }
 
-func getStatus(ctx context.Context) (*Status, error) {
+// signStatus is what the server says the sign is showing, and why.
+type signStatus struct {
+	State    string   `json:"state"`
+	Reason   string   `json:"reason"`
+	Devices  []string `json:"devices,omitempty"`
+	Override *struct {
+		State string    `json:"state"`
+		Until time.Time `json:"until,omitempty"`
+	} `json:"override,omitempty"`
+	StaleAfter string `json:"staleAfter"`
+}
+
+// getStatus returns the server's status for this device, or nil if it
+// hasn't reported one.
+func (c *apiClient) getStatus(ctx context.Context) (*Status, error) {
 	// the server keeps track of our state by our mac address
 	// meaning we need to know our mac address
 	macs, err := getMacAddr()
@@ -26,31 +41,54 @@ func getStatus(ctx context.Context) (*Status, error) {
 	if len(macs) < 1 {
 		return nil, fmt.Errorf("can't find network interface with mac address")
 	}
-	request, err := http.NewRequest(http.MethodGet, "http://peter.local.carvers.house:9988/status/", nil)
+	var status Status
+	found, err := c.getJSON(ctx, "/v1/status/"+url.PathEscape(macs[0]), &status)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// listStatuses returns every device's status, keyed by device ID.
+func (c *apiClient) listStatuses(ctx context.Context) (map[string]Status, error) {
+	statuses := map[string]Status{}
+	_, err := c.getJSON(ctx, "/v1/status", &statuses)
+	if err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+func (c *apiClient) getSign(ctx context.Context) (*signStatus, error) {
+	var sign signStatus
+	_, err := c.getJSON(ctx, "/v1/sign", &sign)
 	if err != nil {
-		return nil, fmt.Errorf("Error building request: %w", err)
+		return nil, err
 	}
-	resp, err := http.DefaultClient.Do(request)
+	return &sign, nil
+}
+
+// getJSON fetches path and parses the response into v. It returns false,
+// and no error, if the server responds with 404.
+func (c *apiClient) getJSON(ctx context.Context, path string, v interface{}) (bool, error) {
+	resp, err := c.do(ctx, http.MethodGet, path, nil)
 	if err != nil {
-		return nil, fmt.Errorf("Error retrieving statuses from server: %w", err)
+		return false, fmt.Errorf("Error retrieving %s from server: %w", path, err)
 	}
 	defer resp.Body.Close()
 	response, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("Error reading response: %w", err)
+		return false, fmt.Errorf("Error reading response: %w", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
 	}
 	if resp.StatusCode != http.StatusOK {
-		yall.FromContext(ctx).WithField("status", resp.Status).WithField("response", string(response)).Warn("unexpected response status")
-		return nil, fmt.Errorf("Unexpected response: %s", resp.Status)
+		return false, responseError(resp, response)
 	}
-	statuses := map[string]Status{}
-	err = json.Unmarshal(response, &statuses)
+	err = json.Unmarshal(response, v)
 	if err != nil {
-		return nil, fmt.Errorf("Error parsing response: %w", err)
-	}
-	v, ok := statuses[macs[0]]
-	if !ok {
-		return nil, fmt.Errorf("Device hasn't reported status to the server.")
+		return false, fmt.Errorf("Error parsing response: %w", err)
 	}
-	return &v, nil
+	return true, nil
 }
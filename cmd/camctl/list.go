@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mitchellh/cli"
+)
+
+func listCommandFactory(ctx context.Context, ui *formatUi, config clientConfig) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return listCommand{
+			ui:     ui,
+			ctx:    ctx,
+			config: config,
+		}, nil
+	}
+}
+
+type listCommand struct {
+	ui     *formatUi
+	ctx    context.Context
+	config clientConfig
+}
+
+// deviceListing is a device's status, as listed by camctl list.
+type deviceListing struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name,omitempty"`
+	CameraOn bool      `json:"cameraOn"`
+	MicOn    bool      `json:"micOn"`
+	LastSync time.Time `json:"lastSync"`
+	// Stale devices have gone long enough without reporting that the
+	// server ignores them.
+	Stale bool `json:"stale"`
+}
+
+type listOutput struct {
+	Sign    *signStatus     `json:"sign"`
+	Devices []deviceListing `json:"devices"`
+}
+
+func (l listCommand) Help() string {
+	return `Usage: camctl list [opts]
+
+Lists every device the server knows about, with its name, whether its camera
+and microphone are in use, when it last reported, and whether it's been long
+enough since then that the server ignores it. Also shows what the sign is
+showing, and why.
+`
+}
+
+func (l listCommand) Synopsis() string {
+	return "List every device the server knows about"
+}
+
+func (l listCommand) Run(args []string) int {
+	f := flag.NewFlagSet("list", flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+	// Set the default Usage to empty
+	f.Usage = func() {}
+
+	f.Parse(args)
+
+	if len(f.Args()) != 0 {
+		return l.ui.Fail(exitUsage, fmt.Errorf("Incorrect number of arguments. list command expects 0 args, got %d.", len(f.Args())))
+	}
+
+	client, err := newAPIClient(l.config)
+	if err != nil {
+		return l.ui.Fail(exitConfig, err)
+	}
+	sign, err := client.getSign(l.ctx)
+	if err != nil {
+		return l.ui.Fail(exitServer, err)
+	}
+	statuses, err := client.listStatuses(l.ctx)
+	if err != nil {
+		return l.ui.Fail(exitServer, err)
+	}
+	staleAfter, err := time.ParseDuration(sign.StaleAfter)
+	if err != nil {
+		return l.ui.Fail(exitServer, fmt.Errorf("Server returned an invalid staleAfter: %w", err))
+	}
+
+	now := time.Now()
+	out := listOutput{Sign: sign, Devices: []deviceListing{}}
+	for id, status := range statuses {
+		out.Devices = append(out.Devices, deviceListing{
+			ID:       id,
+			Name:     status.Name,
+			CameraOn: status.CameraOn,
+			MicOn:    status.MicOn,
+			LastSync: status.LastSync,
+			Stale:    now.Sub(status.LastSync) > staleAfter,
+		})
+	}
+	sort.Slice(out.Devices, func(i, j int) bool {
+		return out.Devices[i].ID < out.Devices[j].ID
+	})
+
+	l.ui.Result(out, formatList(now, out))
+	return exitOK
+}
+
+func formatList(now time.Time, list listOutput) string {
+	text := describeSign(list.Sign)
+	if len(list.Devices) == 0 {
+		return text + "\nNo devices have reported their status."
+	}
+	var buf strings.Builder
+	table := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "DEVICE\tNAME\tCAMERA\tMIC\tLAST SYNC\tSTALE")
+	for _, dev := range list.Devices {
+		name := dev.Name
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", dev.ID, name, onOff(dev.CameraOn), onOff(dev.MicOn), formatAge(now, dev.LastSync), yesNo(dev.Stale))
+	}
+	table.Flush()
+	return text + "\n" + strings.TrimSuffix(buf.String(), "\n")
+}
+
+func describeSign(sign *signStatus) string {
+	desc := "Sign is " + sign.State
+	switch sign.Reason {
+	case "override":
+		desc += ", overridden"
+		if sign.Override != nil && !sign.Override.Until.IsZero() {
+			desc += " until " + sign.Override.Until.Local().Format("2006-01-02 15:04:05")
+		}
+	case "device":
+		desc += ", because of " + strings.Join(sign.Devices, ", ")
+	case "schedule":
+		desc += ", because of a schedule"
+	}
+	return desc + "."
+}
+
+// formatAge describes how long ago t was, to the second.
+func formatAge(now, t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return now.Sub(t).Truncate(time.Second).String() + " ago"
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
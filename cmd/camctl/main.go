@@ -2,36 +2,70 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 
+	"carvers.dev/camera-sign/logging"
 	"github.com/mitchellh/cli"
 	"yall.in"
-	"yall.in/colour"
 )
 
 func main() {
 	// TODO: cancel context on interrupt
 	ctx := context.Background()
-	severity := os.Getenv("LOG_LEVEL")
-	if severity == "" {
-		severity = string(yall.Info)
+
+	// global options come before the command
+	var format, tmpl, configFile string
+	f := flag.NewFlagSet("camctl", flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+	// Set the default Usage to empty
+	f.Usage = func() {}
+	f.StringVar(&format, "format", "", "how to print results: text, json, or template")
+	f.StringVar(&tmpl, "template", "", "a text/template to print results with")
+	f.StringVar(&configFile, "config", "", "the config file to use")
+	err := f.Parse(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(exitUsage)
 	}
-	switch yall.Severity(severity) {
-	case yall.Default, yall.Debug, yall.Info, yall.Warning,
-		yall.Error:
-	default:
-		fmt.Println("Unknown severity level %q; must be %q, %q, %q, or %q.",
-			yall.Debug, yall.Info, yall.Warning, yall.Error)
+
+	// logs would get in the way of machine-readable output
+	logOutput := os.Stdout
+	if format != "" && format != formatText || tmpl != "" {
+		logOutput = os.Stderr
+	}
+	logger, err := logging.New(logOutput, os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(exitConfig)
 	}
-	logger := yall.New(colour.New(os.Stdout, yall.Severity(severity)))
 	ctx = yall.InContext(ctx, logger)
 
+	if configFile != "" {
+		// so commands that save the config save it here too
+		os.Setenv("CAMCTL_CONFIG", configFile)
+	}
+	configPath, err := clientConfigPath()
+	if err != nil {
+		logger.WithError(err).Error("error finding config file")
+		os.Exit(exitConfig)
+	}
+	config, err := loadClientConfig(configPath)
+	if err != nil {
+		logger.WithError(err).Error("error loading config file")
+		os.Exit(exitConfig)
+	}
+
 	c := cli.NewCLI("cameractl", "0.1.0")
-	c.Args = os.Args[1:]
+	c.Args = f.Args()
+	c.HelpFunc = func(commands map[string]cli.CommandFactory) string {
+		return cli.BasicHelpFunc("camctl")(commands) + globalOptionsHelp
+	}
 
-	ui := &cli.ColoredUi{
+	baseUi := &cli.ColoredUi{
 		InfoColor:  cli.UiColorCyan,
 		ErrorColor: cli.UiColorRed,
 		WarnColor:  cli.UiColorYellow,
@@ -41,12 +75,23 @@ func main() {
 			ErrorWriter: os.Stderr,
 		},
 	}
+	ui, err := newFormatUi(baseUi, format, tmpl)
+	if err != nil {
+		baseUi.Error(err.Error())
+		os.Exit(exitUsage)
+	}
 
 	c.Commands = map[string]cli.CommandFactory{
-		"check": checkCommandFactory(ctx, ui),
-		"watch": watchCommandFactory(ctx, ui),
-		"set":   setCommandFactory(ctx, ui),
-		"get":   getCommandFactory(ctx, ui),
+		"check":   checkCommandFactory(ctx, ui, config),
+		"watch":   watchCommandFactory(ctx, ui, config),
+		"set":     setCommandFactory(ctx, ui, config),
+		"get":     getCommandFactory(ctx, ui, config),
+		"list":    listCommandFactory(ctx, ui, config),
+		"enroll":  enrollCommandFactory(ctx, ui, config),
+		"service": serviceCommandFactory(ctx, ui, config),
+	}
+	for action := range serviceActions {
+		c.Commands["service "+action] = serviceActionCommandFactory(ctx, ui, config, action)
 	}
 
 	exitStatus, err := c.Run()
@@ -57,6 +102,30 @@ func main() {
 	os.Exit(exitStatus)
 }
 
+const globalOptionsHelp = `
+Global options, which go before the command:
+
+    -config     The config file to use. Defaults to the CAMCTL_CONFIG
+                environment variable, or camera-sign/camctl.json in your
+                config directory.
+    -format     How to print results: text, json, or template. Defaults to
+                text. In the json and template formats, logs are written
+                to stderr, so only the result is written to stdout.
+    -template   A text/template to print results with. It's executed with
+                the result as it's encoded in JSON, so it uses the same
+                field names, like {{.cameraOn}}. Implies -format template.
+
+Exit codes:
+
+    0  success
+    1  an error without a more specific code
+    2  invalid arguments
+    3  camctl's config couldn't be used
+    4  the server couldn't be reached, or returned an error
+    5  a device couldn't be checked
+    6  the server has no status for this device
+`
+
 func getMacAddr() ([]string, error) {
 	ifas, err := net.Interfaces()
 	if err != nil {
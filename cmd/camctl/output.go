@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	"github.com/mitchellh/cli"
+)
+
+// Exit codes, so scripts can tell why a command failed.
+const (
+	exitOK = 0
+	// exitError is for failures that don't have a more specific code.
+	exitError = 1
+	// exitUsage means the command was run with the wrong arguments.
+	exitUsage = 2
+	// exitConfig means camctl's config couldn't be used.
+	exitConfig = 3
+	// exitServer means the server couldn't be reached, or returned an
+	// error.
+	exitServer = 4
+	// exitDevice means a device couldn't be checked.
+	exitDevice = 5
+	// exitNotFound means the server has no status for this device.
+	exitNotFound = 6
+)
+
+// Output formats, set with the global -format option.
+const (
+	formatText     = "text"
+	formatJSON     = "json"
+	formatTemplate = "template"
+)
+
+// formatUi prints commands' results in the format chosen with -format.
+// Text results are sentences for people; JSON and template results are
+// for scripts, like status bar segments.
+//
+// In JSON and template formats, informational messages aren't printed, so
+// a command's output is only its result.
+type formatUi struct {
+	cli.Ui
+	format   string
+	template *template.Template
+}
+
+func newFormatUi(ui cli.Ui, format, tmpl string) (*formatUi, error) {
+	if format == "" {
+		format = formatText
+		if tmpl != "" {
+			format = formatTemplate
+		}
+	}
+	f := &formatUi{Ui: ui, format: format}
+	switch format {
+	case formatText, formatJSON:
+		if tmpl != "" {
+			return nil, fmt.Errorf("-template can only be used with -format %s", formatTemplate)
+		}
+	case formatTemplate:
+		if tmpl == "" {
+			return nil, fmt.Errorf("-format %s needs a -template", formatTemplate)
+		}
+		parsed, err := template.New("output").Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing template: %w", err)
+		}
+		f.template = parsed
+	default:
+		return nil, fmt.Errorf("unknown format %q; must be %s, %s, or %s", format, formatText, formatJSON, formatTemplate)
+	}
+	return f, nil
+}
+
+// Info prints informational messages in the text format only.
+func (f *formatUi) Info(message string) {
+	if f.format == formatText {
+		f.Ui.Info(message)
+	}
+}
+
+// Result prints a command's result: text in the text format, or v
+// otherwise. Templates are executed with v as it's encoded to JSON, so
+// they use the same field names.
+func (f *formatUi) Result(v interface{}, text string) {
+	if f.format == formatText {
+		f.Ui.Output(text)
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		f.Ui.Error("Error encoding result: " + err.Error())
+		return
+	}
+	if f.format == formatJSON {
+		f.Ui.Output(string(b))
+		return
+	}
+	var data interface{}
+	err = json.Unmarshal(b, &data)
+	if err != nil {
+		f.Ui.Error("Error decoding result: " + err.Error())
+		return
+	}
+	var buf bytes.Buffer
+	err = f.template.Execute(&buf, data)
+	if err != nil {
+		f.Ui.Error("Error executing template: " + err.Error())
+		return
+	}
+	f.Ui.Output(buf.String())
+}
+
+// Fail reports err, and returns code for the command to exit with. In the
+// JSON format, the error is printed as a JSON object with the message and
+// exit code.
+func (f *formatUi) Fail(code int, err error) int {
+	if f.format == formatJSON {
+		b, jsonErr := json.Marshal(map[string]interface{}{
+			"error":    err.Error(),
+			"exitCode": code,
+		})
+		if jsonErr == nil {
+			f.Ui.Output(string(b))
+			return code
+		}
+	}
+	f.Ui.Error(err.Error())
+	return code
+}
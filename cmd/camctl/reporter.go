@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"yall.in"
+)
+
+const (
+	reporterMinBackoff = time.Second
+	reporterMaxBackoff = time.Minute
+)
+
+// reporter sends camera states to the server in the background. Only the
+// latest state matters, so states reported while the server can't be
+// reached replace each other. Failed sends are retried with exponential
+// backoff and jitter, and each retry sends the latest state; a state
+// reported during the backoff waits for the next retry too, rather than
+// being sent early, so changing states can't make a client retry faster.
+type reporter struct {
+	send func(ctx context.Context, state sensorState) error
+
+	// minBackoff and maxBackoff bound how long to wait between retries.
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	// keepalive, if set, turns on heartbeat mode: a state is only sent
+	// when it changes, or when keepalive has passed since the last
+	// send. Otherwise every reported state is sent.
+	keepalive time.Duration
+
+	states chan sensorState
+	rand   *rand.Rand
+}
+
+func newReporter(send func(ctx context.Context, state sensorState) error, keepalive time.Duration) *reporter {
+	return &reporter{
+		send:       send,
+		minBackoff: reporterMinBackoff,
+		maxBackoff: reporterMaxBackoff,
+		keepalive:  keepalive,
+		states:     make(chan sensorState, 1),
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Report queues state to be sent, replacing any state that hasn't been
+// picked up yet. It never blocks.
+func (r *reporter) Report(state sensorState) {
+	for {
+		select {
+		case r.states <- state:
+			return
+		default:
+		}
+		select {
+		case <-r.states:
+		default:
+		}
+	}
+}
+
+// backoff is how long to wait after failures consecutive failed sends:
+// exponential, capped at maxBackoff, with the upper half jittered so
+// clients that lost the server together don't retry together.
+func (r *reporter) backoff(failures int) time.Duration {
+	backoff := r.minBackoff
+	for i := 1; i < failures && backoff < r.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > r.maxBackoff {
+		backoff = r.maxBackoff
+	}
+	return backoff/2 + time.Duration(r.rand.Int63n(int64(backoff/2)+1))
+}
+
+// Run sends reported states until ctx is cancelled.
+func (r *reporter) Run(ctx context.Context) {
+	log := yall.FromContext(ctx)
+	var (
+		latest   sensorState
+		reported bool // whether any state has been reported yet
+		dirty    bool // whether latest still needs to be sent
+		sent     sensorState
+		lastSent time.Time
+		failures int
+		retryAt  time.Time
+	)
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		var wait <-chan time.Time
+		switch {
+		case dirty && failures == 0:
+			timer.Reset(0)
+			wait = timer.C
+		case dirty:
+			timer.Reset(time.Until(retryAt))
+			wait = timer.C
+		case reported && r.keepalive > 0:
+			timer.Reset(time.Until(lastSent.Add(r.keepalive)))
+			wait = timer.C
+		}
+
+		select {
+		case state := <-r.states:
+			// in heartbeat mode, a state the server already has
+			// waits for the keepalive; otherwise, everything is sent
+			dirty = r.keepalive <= 0 || lastSent.IsZero() || state != sent
+			latest, reported = state, true
+		case <-wait:
+			err := r.send(ctx, latest)
+			if err != nil {
+				failures++
+				dirty = true
+				retryAt = time.Now().Add(r.backoff(failures))
+				log.WithField("failures", failures).WithError(err).Warn("error reporting camera state; will retry")
+				break
+			}
+			if failures > 0 {
+				log.WithField("failures", failures).Info("reached server again")
+			}
+			failures = 0
+			dirty = false
+			sent = latest
+			lastSent = time.Now()
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		if !timer.Stop() {
+			// drain the timer if it fired without being received
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// sends records what a reporter sends, failing the first failures sends.
+type sends struct {
+	states   chan sensorState
+	release  chan struct{}
+	failures int
+}
+
+func newSends(failures int) *sends {
+	return &sends{states: make(chan sensorState, 100), failures: failures}
+}
+
+func (s *sends) send(ctx context.Context, state sensorState) error {
+	if s.release != nil {
+		<-s.release
+	}
+	s.states <- state
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("server unavailable")
+	}
+	return nil
+}
+
+func (s *sends) next(t *testing.T) sensorState {
+	t.Helper()
+	select {
+	case state := <-s.states:
+		return state
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a send")
+		return sensorState{}
+	}
+}
+
+func (s *sends) none(t *testing.T, wait time.Duration) {
+	t.Helper()
+	select {
+	case state := <-s.states:
+		t.Errorf("expected no send, got %+v", state)
+	case <-time.After(wait):
+	}
+}
+
+func runReporter(r *reporter) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestReporterBackoff(t *testing.T) {
+	cases := map[string]struct {
+		failures int
+		min, max time.Duration
+	}{
+		"first":  {failures: 1, min: 500 * time.Millisecond, max: time.Second},
+		"second": {failures: 2, min: time.Second, max: 2 * time.Second},
+		"third":  {failures: 3, min: 2 * time.Second, max: 4 * time.Second},
+		"capped": {failures: 7, min: 30 * time.Second, max: time.Minute},
+		"many":   {failures: 100, min: 30 * time.Second, max: time.Minute},
+	}
+	r := newReporter(nil, 0)
+	for name, c := range cases {
+		for i := 0; i < 100; i++ {
+			got := r.backoff(c.failures)
+			if got < c.min || got > c.max {
+				t.Errorf("%s: expected a backoff between %s and %s, got %s", name, c.min, c.max, got)
+				break
+			}
+		}
+	}
+}
+
+func TestReporterCoalesces(t *testing.T) {
+	s := newSends(0)
+	s.release = make(chan struct{})
+	r := newReporter(s.send, 0)
+	stop := runReporter(r)
+	defer stop()
+
+	r.Report(sensorState{CameraOn: true})
+	// the first send is held up, so the next states replace each other
+	// until it's done
+	time.Sleep(10 * time.Millisecond)
+	r.Report(sensorState{MicOn: true})
+	r.Report(sensorState{CameraOn: true, MicOn: true})
+	close(s.release)
+
+	expected := []sensorState{{CameraOn: true}, {CameraOn: true, MicOn: true}}
+	for i, state := range expected {
+		if got := s.next(t); got != state {
+			t.Errorf("send %d: expected %+v, got %+v", i, state, got)
+		}
+	}
+	s.none(t, 50*time.Millisecond)
+}
+
+func TestReporterKeepalive(t *testing.T) {
+	s := newSends(0)
+	r := newReporter(s.send, 100*time.Millisecond)
+	stop := runReporter(r)
+	defer stop()
+
+	on := sensorState{CameraOn: true}
+	r.Report(on)
+	if got := s.next(t); got != on {
+		t.Errorf("expected %+v, got %+v", on, got)
+	}
+	// the server already has it, so it waits for the keepalive
+	r.Report(on)
+	s.none(t, 30*time.Millisecond)
+	if got := s.next(t); got != on {
+		t.Errorf("expected the keepalive to resend %+v, got %+v", on, got)
+	}
+
+	// a change is sent right away
+	off := sensorState{}
+	r.Report(off)
+	select {
+	case got := <-s.states:
+		if got != off {
+			t.Errorf("expected %+v, got %+v", off, got)
+		}
+	case <-time.After(50 * time.Millisecond):
+		t.Error("expected a changed state to be sent before the keepalive")
+	}
+}
+
+func TestReporterFlushesAfterRecovery(t *testing.T) {
+	s := newSends(3)
+	r := newReporter(s.send, 0)
+	r.minBackoff, r.maxBackoff = 20*time.Millisecond, 20*time.Millisecond
+	stop := runReporter(r)
+	defer stop()
+
+	on := sensorState{CameraOn: true}
+	r.Report(on)
+	if got := s.next(t); got != on {
+		t.Errorf("expected %+v, got %+v", on, got)
+	}
+	// reported while the server's unavailable, so it replaces on, and
+	// waits for the next retry
+	off := sensorState{}
+	r.Report(off)
+	s.none(t, 5*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if got := s.next(t); got != off {
+			t.Errorf("retry %d: expected %+v, got %+v", i, off, got)
+		}
+	}
+	// the last retry worked, so there's nothing left to send
+	s.none(t, 60*time.Millisecond)
+}
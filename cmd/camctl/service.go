@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// serviceName is what the service is called, where the platform lets us
+// pick.
+const serviceName = "camctl-watch"
+
+// serviceSpec is what the service runs.
+type serviceSpec struct {
+	// Executable is camctl's absolute path.
+	Executable string
+	// Args are the arguments to run camctl with: the config file, and the
+	// watch command with its options.
+	Args []string
+}
+
+// newServiceSpec runs this camctl binary's watch command with watchArgs,
+// using the config file at configPath.
+func newServiceSpec(configPath string, watchArgs []string) (serviceSpec, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return serviceSpec{}, fmt.Errorf("can't find camctl's path: %w", err)
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return serviceSpec{}, fmt.Errorf("can't find camctl's path: %w", err)
+	}
+	configPath, err = filepath.Abs(configPath)
+	if err != nil {
+		return serviceSpec{}, fmt.Errorf("can't find config file's path: %w", err)
+	}
+	args := append([]string{"-config", configPath, "watch"}, watchArgs...)
+	return serviceSpec{Executable: exe, Args: args}, nil
+}
+
+// serviceManager installs and controls the service, using whatever this
+// platform runs background programs with.
+type serviceManager interface {
+	// Path is where the service's file is installed.
+	Path() string
+	// Install writes the service's file, and sets it to start when the
+	// user logs in.
+	Install(ctx context.Context, spec serviceSpec) error
+	// Uninstall stops the service, and removes its file.
+	Uninstall(ctx context.Context) error
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+	Running(ctx context.Context) (bool, error)
+}
+
+// runServiceTool runs one of the platform's tools for managing services,
+// including its output in the error if it fails.
+func runServiceTool(ctx context.Context, name string, args ...string) error {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error running %s %s: %w: %s", name, strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func serviceCommandFactory(ctx context.Context, ui *formatUi, config clientConfig) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return serviceCommand{}, nil
+	}
+}
+
+type serviceCommand struct{}
+
+func (s serviceCommand) Help() string {
+	return `Usage: camctl service <subcommand> [opts]
+
+Runs camctl watch in the background, as a systemd user unit on linux, a
+launchd agent on macOS, or a scheduled task on windows. It starts when you
+log in, using the same config file camctl is using now.`
+}
+
+func (s serviceCommand) Synopsis() string {
+	return "Run camctl watch in the background"
+}
+
+func (s serviceCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+// serviceActions describes each service subcommand, by name.
+var serviceActions = map[string]struct {
+	synopsis string
+	help     string
+}{
+	"install": {
+		synopsis: "Install camctl watch as a service",
+		help: `Usage: camctl service install [watch options]
+
+Installs camctl watch as a service that starts when you log in, using this
+camctl binary, and the config file camctl is using now. Options after install
+are passed to camctl watch, like -keepalive 5m. Installing again replaces the
+service, so run it again after moving camctl, or to change the options.`,
+	},
+	"uninstall": {
+		synopsis: "Stop and remove the camctl watch service",
+		help: `Usage: camctl service uninstall
+
+Stops the camctl watch service, and removes it, so it no longer starts when
+you log in.`,
+	},
+	"start": {
+		synopsis: "Start the camctl watch service",
+		help: `Usage: camctl service start
+
+Starts the installed camctl watch service now, without waiting for you to
+log in.`,
+	},
+	"stop": {
+		synopsis: "Stop the camctl watch service",
+		help: `Usage: camctl service stop
+
+Stops the camctl watch service until it's started again, or you next log in.`,
+	},
+	"status": {
+		synopsis: "Show whether the camctl watch service is installed and running",
+		help: `Usage: camctl service status
+
+Shows whether the camctl watch service is installed, where, and whether it's
+running.`,
+	},
+}
+
+func serviceActionCommandFactory(ctx context.Context, ui *formatUi, config clientConfig, action string) func() (cli.Command, error) {
+	return func() (cli.Command, error) {
+		return serviceActionCommand{
+			ui:     ui,
+			ctx:    ctx,
+			config: config,
+			action: action,
+		}, nil
+	}
+}
+
+type serviceActionCommand struct {
+	ui     *formatUi
+	ctx    context.Context
+	config clientConfig
+	action string
+}
+
+// serviceResult is the service's state after a service command.
+type serviceResult struct {
+	Action    string `json:"action"`
+	Path      string `json:"path"`
+	Installed bool   `json:"installed"`
+	Running   bool   `json:"running"`
+}
+
+func (r serviceResult) String() string {
+	switch r.Action {
+	case "install":
+		return fmt.Sprintf("Installed the camctl watch service at %s. It starts when you log in; run camctl service start to start it now.", r.Path)
+	case "uninstall":
+		return "Uninstalled the camctl watch service."
+	case "start":
+		return "Started the camctl watch service."
+	case "stop":
+		return "Stopped the camctl watch service."
+	}
+	if !r.Installed {
+		return "The camctl watch service is not installed."
+	}
+	return fmt.Sprintf("The camctl watch service is installed at %s, and is%s running.", r.Path, notStr(r.Running))
+}
+
+func (s serviceActionCommand) Help() string {
+	return serviceActions[s.action].help
+}
+
+func (s serviceActionCommand) Synopsis() string {
+	return serviceActions[s.action].synopsis
+}
+
+func (s serviceActionCommand) Run(args []string) int {
+	// install passes its args to watch, which checks them when it runs
+	if s.action != "install" && len(args) != 0 {
+		return s.ui.Fail(exitUsage, fmt.Errorf("Incorrect number of arguments. service %s command expects 0 args, got %d.", s.action, len(args)))
+	}
+	manager, err := newServiceManager()
+	if err != nil {
+		return s.ui.Fail(exitError, err)
+	}
+	switch s.action {
+	case "install":
+		path, err := clientConfigPath()
+		if err != nil {
+			return s.ui.Fail(exitConfig, err)
+		}
+		spec, err := newServiceSpec(path, args)
+		if err != nil {
+			return s.ui.Fail(exitError, err)
+		}
+		err = manager.Install(s.ctx, spec)
+		if err != nil {
+			return s.ui.Fail(exitError, fmt.Errorf("Error installing service: %w", err))
+		}
+	case "uninstall":
+		err = manager.Uninstall(s.ctx)
+		if err != nil {
+			return s.ui.Fail(exitError, fmt.Errorf("Error uninstalling service: %w", err))
+		}
+	case "start":
+		err = manager.Start(s.ctx)
+		if err != nil {
+			return s.ui.Fail(exitError, fmt.Errorf("Error starting service: %w", err))
+		}
+	case "stop":
+		err = manager.Stop(s.ctx)
+		if err != nil {
+			return s.ui.Fail(exitError, fmt.Errorf("Error stopping service: %w", err))
+		}
+	}
+
+	result := serviceResult{Action: s.action, Path: manager.Path()}
+	if _, err := os.Stat(manager.Path()); err == nil {
+		result.Installed = true
+		result.Running, err = manager.Running(s.ctx)
+		if err != nil {
+			return s.ui.Fail(exitError, fmt.Errorf("Error checking whether service is running: %w", err))
+		}
+	}
+	s.ui.Result(result, result.String())
+	return exitOK
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func newServiceManager() (serviceManager, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("can't find home directory: %w", err)
+	}
+	return launchdManager{
+		dir:     filepath.Join(home, "Library", "LaunchAgents"),
+		logPath: filepath.Join(home, "Library", "Logs", serviceName+".log"),
+	}, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// launchdLabel names the launchd agent.
+const launchdLabel = "dev.carvers.camctl-watch"
+
+var launchdPlist = template.Must(template.New("launchd").Funcs(template.FuncMap{
+	"xml": xmlEscape,
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{ xml .Label }}</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{ xml .Spec.Executable }}</string>
+{{- range .Spec.Args }}
+		<string>{{ xml . }}</string>
+{{- end }}
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>StandardOutPath</key>
+	<string>{{ xml .LogPath }}</string>
+	<key>StandardErrorPath</key>
+	<string>{{ xml .LogPath }}</string>
+</dict>
+</plist>
+`))
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// launchdManager runs the service as a launchd agent.
+type launchdManager struct {
+	// dir is where agents go, usually ~/Library/LaunchAgents.
+	dir string
+	// logPath is where the agent's output is written.
+	logPath string
+}
+
+func (m launchdManager) Path() string {
+	return filepath.Join(m.dir, launchdLabel+".plist")
+}
+
+// write renders the agent's plist for spec into dir.
+func (m launchdManager) write(spec serviceSpec) error {
+	var buf bytes.Buffer
+	err := launchdPlist.Execute(&buf, struct {
+		Label   string
+		Spec    serviceSpec
+		LogPath string
+	}{Label: launchdLabel, Spec: spec, LogPath: m.logPath})
+	if err != nil {
+		return fmt.Errorf("error rendering agent: %w", err)
+	}
+	err = os.MkdirAll(m.dir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating agent directory: %w", err)
+	}
+	return ioutil.WriteFile(m.Path(), buf.Bytes(), 0644)
+}
+
+// Install writes the agent's plist. Agents in ~/Library/LaunchAgents are
+// loaded when the user logs in, and RunAtLoad starts it then.
+func (m launchdManager) Install(ctx context.Context, spec serviceSpec) error {
+	return m.write(spec)
+}
+
+func (m launchdManager) Uninstall(ctx context.Context) error {
+	// unloading fails if the agent isn't loaded, which is fine
+	runServiceTool(ctx, "launchctl", "unload", m.Path())
+	return os.Remove(m.Path())
+}
+
+func (m launchdManager) Start(ctx context.Context) error {
+	return runServiceTool(ctx, "launchctl", "load", m.Path())
+}
+
+func (m launchdManager) Stop(ctx context.Context) error {
+	return runServiceTool(ctx, "launchctl", "unload", m.Path())
+}
+
+func (m launchdManager) Running(ctx context.Context) (bool, error) {
+	out, err := exec.CommandContext(ctx, "launchctl", "list", launchdLabel).Output()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			// list exits non-zero for agents that aren't loaded
+			return false, nil
+		}
+		return false, err
+	}
+	// loaded agents that aren't running have no PID
+	return strings.Contains(string(out), `"PID" =`), nil
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func newServiceManager() (serviceManager, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, fmt.Errorf("can't find config directory: %w", err)
+	}
+	return systemdManager{dir: filepath.Join(dir, "systemd", "user")}, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"unicode/utf16"
+)
+
+var scheduledTask = template.Must(template.New("schtasks").Funcs(template.FuncMap{
+	"xml": xmlEscape,
+}).Parse(`<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <RegistrationInfo>
+    <Description>Report whether this computer's camera is in use to camera-signd</Description>
+  </RegistrationInfo>
+  <Triggers>
+    <LogonTrigger>
+      <Enabled>true</Enabled>
+    </LogonTrigger>
+  </Triggers>
+  <Principals>
+    <Principal id="Author">
+      <LogonType>InteractiveToken</LogonType>
+      <RunLevel>LeastPrivilege</RunLevel>
+    </Principal>
+  </Principals>
+  <Settings>
+    <MultipleInstancesPolicy>IgnoreNew</MultipleInstancesPolicy>
+    <DisallowStartIfOnBatteries>false</DisallowStartIfOnBatteries>
+    <StopIfGoingOnBatteries>false</StopIfGoingOnBatteries>
+    <ExecutionTimeLimit>PT0S</ExecutionTimeLimit>
+    <RestartOnFailure>
+      <Interval>PT1M</Interval>
+      <Count>999</Count>
+    </RestartOnFailure>
+  </Settings>
+  <Actions Context="Author">
+    <Exec>
+      <Command>{{ xml .Command }}</Command>
+      <Arguments>{{ xml .Arguments }}</Arguments>
+    </Exec>
+  </Actions>
+</Task>
+`))
+
+// windowsQuote quotes s as a single argument on a windows command line, the
+// same way syscall.EscapeArg does.
+func windowsQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\\':
+			slashes++
+		case '"':
+			// backslashes before a quote escape each other, and
+			// the quote needs escaping too
+			b.WriteString(strings.Repeat(`\`, slashes+1))
+			slashes = 0
+		default:
+			slashes = 0
+		}
+		b.WriteByte(c)
+	}
+	// so the closing quote isn't escaped
+	b.WriteString(strings.Repeat(`\`, slashes))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// schtasksManager runs the service as a scheduled task that starts when the
+// user logs in.
+type schtasksManager struct {
+	// dir is where the task's XML is kept.
+	dir string
+}
+
+func (m schtasksManager) Path() string {
+	return filepath.Join(m.dir, serviceName+".xml")
+}
+
+// write renders the task's XML for spec into dir. schtasks expects it to be
+// UTF-16, with a byte order mark.
+func (m schtasksManager) write(spec serviceSpec) error {
+	var args []string
+	for _, arg := range spec.Args {
+		args = append(args, windowsQuote(arg))
+	}
+	var buf bytes.Buffer
+	err := scheduledTask.Execute(&buf, struct {
+		Command   string
+		Arguments string
+	}{Command: spec.Executable, Arguments: strings.Join(args, " ")})
+	if err != nil {
+		return fmt.Errorf("error rendering task: %w", err)
+	}
+	units := utf16.Encode([]rune(buf.String()))
+	b := make([]byte, 2+2*len(units))
+	binary.LittleEndian.PutUint16(b, 0xfeff)
+	for pos, unit := range units {
+		binary.LittleEndian.PutUint16(b[2+2*pos:], unit)
+	}
+	err = os.MkdirAll(m.dir, 0700)
+	if err != nil {
+		return fmt.Errorf("error creating task directory: %w", err)
+	}
+	return ioutil.WriteFile(m.Path(), b, 0600)
+}
+
+func (m schtasksManager) Install(ctx context.Context, spec serviceSpec) error {
+	err := m.write(spec)
+	if err != nil {
+		return err
+	}
+	return runServiceTool(ctx, "schtasks", "/Create", "/TN", serviceName, "/XML", m.Path(), "/F")
+}
+
+func (m schtasksManager) Uninstall(ctx context.Context) error {
+	// ending fails if the task isn't running, which is fine
+	runServiceTool(ctx, "schtasks", "/End", "/TN", serviceName)
+	err := runServiceTool(ctx, "schtasks", "/Delete", "/TN", serviceName, "/F")
+	if err != nil {
+		return err
+	}
+	return os.Remove(m.Path())
+}
+
+func (m schtasksManager) Start(ctx context.Context) error {
+	return runServiceTool(ctx, "schtasks", "/Run", "/TN", serviceName)
+}
+
+func (m schtasksManager) Stop(ctx context.Context) error {
+	return runServiceTool(ctx, "schtasks", "/End", "/TN", serviceName)
+}
+
+func (m schtasksManager) Running(ctx context.Context) (bool, error) {
+	out, err := exec.CommandContext(ctx, "schtasks", "/Query", "/TN", serviceName, "/FO", "CSV", "/NH").Output()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			// query exits non-zero for tasks that don't exist
+			return false, nil
+		}
+		return false, err
+	}
+	// each row is the task's name, next run time, and status
+	rows, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+	if err != nil {
+		return false, fmt.Errorf("error parsing schtasks output: %w", err)
+	}
+	for _, row := range rows {
+		if len(row) >= 3 && row[2] == "Running" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
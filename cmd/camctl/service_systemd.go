@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+var systemdUnit = template.Must(template.New("systemd").Funcs(template.FuncMap{
+	"quote": systemdQuote,
+}).Parse(`[Unit]
+Description=Report whether this computer's camera is in use to camera-signd
+
+[Service]
+ExecStart={{ quote .Executable }}{{ range .Args }} {{ quote . }}{{ end }}
+Restart=on-failure
+RestartSec=10
+
+[Install]
+WantedBy=default.target
+`))
+
+// systemdQuote quotes s as a single argument in a unit file, escaping the
+// characters systemd would otherwise expand.
+func systemdQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(s) + `"`
+}
+
+// systemdManager runs the service as a systemd user unit.
+type systemdManager struct {
+	// dir is where user units go, usually ~/.config/systemd/user.
+	dir string
+}
+
+func (m systemdManager) unit() string {
+	return serviceName + ".service"
+}
+
+func (m systemdManager) Path() string {
+	return filepath.Join(m.dir, m.unit())
+}
+
+// write renders the unit file for spec into dir.
+func (m systemdManager) write(spec serviceSpec) error {
+	var buf bytes.Buffer
+	err := systemdUnit.Execute(&buf, spec)
+	if err != nil {
+		return fmt.Errorf("error rendering unit: %w", err)
+	}
+	err = os.MkdirAll(m.dir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating unit directory: %w", err)
+	}
+	return ioutil.WriteFile(m.Path(), buf.Bytes(), 0644)
+}
+
+func (m systemdManager) Install(ctx context.Context, spec serviceSpec) error {
+	err := m.write(spec)
+	if err != nil {
+		return err
+	}
+	err = runServiceTool(ctx, "systemctl", "--user", "daemon-reload")
+	if err != nil {
+		return err
+	}
+	return runServiceTool(ctx, "systemctl", "--user", "enable", m.unit())
+}
+
+func (m systemdManager) Uninstall(ctx context.Context) error {
+	err := runServiceTool(ctx, "systemctl", "--user", "disable", "--now", m.unit())
+	if err != nil {
+		return err
+	}
+	err = os.Remove(m.Path())
+	if err != nil {
+		return err
+	}
+	return runServiceTool(ctx, "systemctl", "--user", "daemon-reload")
+}
+
+func (m systemdManager) Start(ctx context.Context) error {
+	return runServiceTool(ctx, "systemctl", "--user", "start", m.unit())
+}
+
+func (m systemdManager) Stop(ctx context.Context) error {
+	return runServiceTool(ctx, "systemctl", "--user", "stop", m.unit())
+}
+
+func (m systemdManager) Running(ctx context.Context) (bool, error) {
+	out, err := exec.CommandContext(ctx, "systemctl", "--user", "is-active", m.unit()).Output()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			// is-active exits non-zero for units that aren't active
+			return false, nil
+		}
+		return false, err
+	}
+	return strings.TrimSpace(string(out)) == "active", nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+var testServiceSpec = serviceSpec{
+	Executable: "/opt/camera sign/camctl",
+	Args:       []string{"-config", "/home/alice/.config/camera-sign/camctl.json", "watch", "-keepalive", "5m"},
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "camctl-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestSystemdUnit(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	m := systemdManager{dir: filepath.Join(dir, "systemd", "user")}
+	err := m.write(testServiceSpec)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "systemd", "user", "camctl-watch.service"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `ExecStart="/opt/camera sign/camctl" "-config" "/home/alice/.config/camera-sign/camctl.json" "watch" "-keepalive" "5m"` + "\n"
+	if !strings.Contains(string(b), want) {
+		t.Errorf("expected unit to contain %q, got:\n%s", want, b)
+	}
+	if !strings.Contains(string(b), "WantedBy=default.target\n") {
+		t.Errorf("expected unit to be wanted by default.target, got:\n%s", b)
+	}
+}
+
+func TestSystemdQuote(t *testing.T) {
+	tests := map[string]string{
+		"camctl":  `"camctl"`,
+		`a "b"`:   `"a \"b\""`,
+		`C:\x`:    `"C:\\x"`,
+		"100%":    `"100%%"`,
+		"$HOME/x": `"$$HOME/x"`,
+	}
+	for in, want := range tests {
+		if got := systemdQuote(in); got != want {
+			t.Errorf("systemdQuote(%q): expected %s, got %s", in, want, got)
+		}
+	}
+}
+
+func TestLaunchdPlist(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	m := launchdManager{dir: dir, logPath: "/Users/alice/Library/Logs/camctl-watch.log"}
+	spec := testServiceSpec
+	spec.Args = append(spec.Args, "a<b")
+	err := m.write(spec)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "dev.carvers.camctl-watch.plist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<string>dev.carvers.camctl-watch</string>",
+		"<string>/opt/camera sign/camctl</string>\n\t\t<string>-config</string>",
+		"<string>-keepalive</string>\n\t\t<string>5m</string>\n\t\t<string>a&lt;b</string>\n\t</array>",
+		"<string>/Users/alice/Library/Logs/camctl-watch.log</string>",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("expected plist to contain %q, got:\n%s", want, b)
+		}
+	}
+}
+
+func TestScheduledTask(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	m := schtasksManager{dir: dir}
+	spec := serviceSpec{
+		Executable: `C:\Program Files\camera-sign\camctl.exe`,
+		Args:       []string{"-config", `C:\Users\alice\AppData\Roaming\camera-sign\camctl.json`, "watch", "-processes", `say "hi"`},
+	}
+	err := m.write(spec)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "camctl-watch.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) < 2 || len(b)%2 != 0 || binary.LittleEndian.Uint16(b) != 0xfeff {
+		t.Fatalf("expected UTF-16 with a byte order mark, got % x", b[:4])
+	}
+	units := make([]uint16, 0, len(b)/2-1)
+	for pos := 2; pos < len(b); pos += 2 {
+		units = append(units, binary.LittleEndian.Uint16(b[pos:]))
+	}
+	task := string(utf16.Decode(units))
+	for _, want := range []string{
+		`<Command>C:\Program Files\camera-sign\camctl.exe</Command>`,
+		`<Arguments>-config C:\Users\alice\AppData\Roaming\camera-sign\camctl.json watch -processes &#34;say \&#34;hi\&#34;&#34;</Arguments>`,
+		"<LogonTrigger>",
+	} {
+		if !strings.Contains(task, want) {
+			t.Errorf("expected task to contain %q, got:\n%s", want, task)
+		}
+	}
+}
+
+func TestWindowsQuote(t *testing.T) {
+	tests := map[string]string{
+		"plain":       "plain",
+		"":            `""`,
+		"with space":  `"with space"`,
+		`C:\dir\`:     `C:\dir\`,
+		`C:\my dir\`:  `"C:\my dir\\"`,
+		`say "hi"`:    `"say \"hi\""`,
+		`back\"quote`: `"back\\\"quote"`,
+	}
+	for in, want := range tests {
+		if got := windowsQuote(in); got != want {
+			t.Errorf("windowsQuote(%q): expected %s, got %s", in, want, got)
+		}
+	}
+}
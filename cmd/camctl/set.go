@@ -9,18 +9,20 @@ import (
 	"github.com/mitchellh/cli"
 )
 
-func setCommandFactory(ctx context.Context, ui cli.Ui) func() (cli.Command, error) {
+func setCommandFactory(ctx context.Context, ui *formatUi, config clientConfig) func() (cli.Command, error) {
 	return func() (cli.Command, error) {
 		return setCommand{
-			ui:  ui,
-			ctx: ctx,
+			ui:     ui,
+			ctx:    ctx,
+			config: config,
 		}, nil
 	}
 }
 
 type setCommand struct {
-	ui  cli.Ui
-	ctx context.Context
+	ui     *formatUi
+	ctx    context.Context
+	config clientConfig
 }
 
 func (s setCommand) Help() string {
@@ -38,8 +40,7 @@ func (s setCommand) Synopsis() string {
 func (s setCommand) Run(args []string) int {
 	numArgs := 1
 	if len(args) != numArgs {
-		s.ui.Error(fmt.Sprintf("Incorrect number of arguments. check command expects %d args, got %d.", numArgs, len(args)))
-		return 1
+		return s.ui.Fail(exitUsage, fmt.Errorf("Incorrect number of arguments. set command expects %d args, got %d.", numArgs, len(args)))
 	}
 
 	rawStatus := args[0]
@@ -52,13 +53,16 @@ func (s setCommand) Run(args []string) int {
 
 	status, err := strconv.ParseBool(rawStatus)
 	if err != nil {
-		s.ui.Error("Error parsing camera state: " + err.Error())
-		return 1
+		return s.ui.Fail(exitUsage, fmt.Errorf("Error parsing camera state: %w", err))
 	}
-	err = update(s.ctx, status)
+	client, err := newAPIClient(s.config)
 	if err != nil {
-		s.ui.Error(err.Error())
-		return 1
+		return s.ui.Fail(exitConfig, err)
 	}
-	return 0
+	err = client.update(s.ctx, sensorState{CameraOn: status})
+	if err != nil {
+		return s.ui.Fail(exitServer, err)
+	}
+	s.ui.Result(map[string]bool{"cameraOn": status}, fmt.Sprintf("Reported that the camera is %s.", onOff(status)))
+	return exitOK
 }
@@ -1,18 +1,24 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"net/url"
+	"os"
 
 	"yall.in"
 )
 
-func update(ctx context.Context, on bool) error {
+// sensorState is whether this device's camera and microphone are in use.
+type sensorState struct {
+	CameraOn bool
+	MicOn    bool
+}
+
+func (c *apiClient) update(ctx context.Context, state sensorState) error {
 	// the server keeps track of our state by our mac address
 	// meaning we need to know our mac address
 	macs, err := getMacAddr()
@@ -23,32 +29,59 @@ func update(ctx context.Context, on bool) error {
 		return fmt.Errorf("can't find network interface with mac address")
 	}
 	type req struct {
-		CameraOn bool `json:"cameraOn"`
+		Name     string `json:"name,omitempty"`
+		CameraOn bool   `json:"cameraOn"`
+		MicOn    bool   `json:"micOn"`
 	}
-	r := req{CameraOn: on}
+	r := req{CameraOn: state.CameraOn, MicOn: state.MicOn}
+	// the hostname is just to make the device easier to recognise, so
+	// not knowing it isn't worth failing over
+	r.Name, _ = os.Hostname()
 	b, err := json.Marshal(r)
 	if err != nil {
 		return fmt.Errorf("Error building request body: %w", err)
 	}
-	buf := bytes.NewBuffer(b)
-	log.Println("setting", macs[0], "to", on)
-	// TODO: let's not hardcode the server in
-	request, err := http.NewRequest(http.MethodPatch, "http://peter.local.carvers.house:9988/status/"+macs[0], buf)
-	if err != nil {
-		return fmt.Errorf("Error building request: %w", err)
-	}
-	resp, err := http.DefaultClient.Do(request)
+	yall.FromContext(ctx).WithField("device", macs[0]).WithField("cameraOn", state.CameraOn).WithField("micOn", state.MicOn).Info("reporting camera state")
+	resp, err := c.do(ctx, http.MethodPatch, "/v1/status/"+url.PathEscape(macs[0]), b)
 	if err != nil {
 		return fmt.Errorf("Error updating server: %w", err)
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusNoContent {
-		return nil
-	}
 	response, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return fmt.Errorf("Error reading response: %w", err)
 	}
-	yall.FromContext(ctx).WithField("status", resp.Status).WithField("response", string(response)).Warn("unexpected response status")
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, response)
+	}
+	var result struct {
+		Sign struct {
+			State string `json:"state"`
+			Error string `json:"error"`
+		} `json:"sign"`
+	}
+	err = json.Unmarshal(response, &result)
+	if err != nil {
+		return fmt.Errorf("Error parsing response: %w", err)
+	}
+	if result.Sign.Error != "" {
+		// our status was still recorded, the server just couldn't
+		// update the sign, so this isn't our error to return
+		yall.FromContext(ctx).WithField("state", result.Sign.State).WithField("error", result.Sign.Error).Warn("server couldn't update the sign")
+	}
 	return nil
 }
+
+// responseError builds an error from an unsuccessful response from the
+// server, using the message from its JSON error body if it has one.
+func responseError(resp *http.Response, body []byte) error {
+	var apiErr struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	err := json.Unmarshal(body, &apiErr)
+	if err != nil || apiErr.Message == "" {
+		return fmt.Errorf("Unexpected response: %s", resp.Status)
+	}
+	return fmt.Errorf("Server returned %s: %s", resp.Status, apiErr.Message)
+}
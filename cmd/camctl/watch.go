@@ -2,62 +2,69 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"path/filepath"
-	"runtime"
-	"strings"
 	"time"
 
 	"carvers.dev/camera-sign/device"
 	"github.com/mitchellh/cli"
 )
 
-func watchCommandFactory(ctx context.Context, ui cli.Ui) func() (cli.Command, error) {
+func watchCommandFactory(ctx context.Context, ui *formatUi, config clientConfig) func() (cli.Command, error) {
 	return func() (cli.Command, error) {
 		return watchCommand{
-			ui:  ui,
-			ctx: ctx,
+			ui:     ui,
+			ctx:    ctx,
+			config: config,
 		}, nil
 	}
 }
 
 type watchCommand struct {
-	ui  cli.Ui
-	ctx context.Context
+	ui     *formatUi
+	ctx    context.Context
+	config clientConfig
 }
 
 func (w watchCommand) Help() string {
-	helpText := `Usage: camctl watch [opts]`
-	if runtime.GOOS == "windows" {
-		helpText += ` [devices]`
-	}
-	helpText += `
-
-Continuously checks whether a camera is in use according to the options`
-	if runtime.GOOS == "windows" {
-		helpText += ` and
-devices`
-	} else {
-		helpText += `
-`
-	}
-	helpText += `specified, and reports the results to the server.
+	return `Usage: camctl watch [opts] [devices]
 
-When -cycle-time is set to a duration, the webcam status will be checked
-with that duration. By default, it is checked every minute.`
-	if runtime.GOOS == "windows" {
-		helpText += `
+Continuously checks whether a camera is in use according to the options and
+devices specified, and reports the results to the server.
 
-When -processes is specified, it accepts a comma-separated list of process
-substrings to search for. Only those processes usage of the webcam will be
-reported. This speeds up the command considerably.
+When -check-every is set to a duration, the webcam status will be checked
+with that duration. By default, it is checked every minute.
 
-devices should be a comma-separated list of Physical Device Object names,
-retrieved from Device Manager.`
-	}
-	return helpText
+By default, every check is reported to the server. When -keepalive is set to
+a duration, a check is only reported if the status changed, or if the
+keepalive has passed since the last report. The server forgets statuses
+after 15 minutes, so keepalive should be shorter than that.
+
+If the server can't be reached, the latest status is kept and retried with
+backoff, and sent as soon as the server is back.
+
+Cameras are checked by the detectors set in the config file, the same as
+check. -timeout sets how long to wait for each detector; it defaults to 10s.
+
+Which cameras are checked, and which processes count as using them, can be
+limited with the devices and processes include and exclude rules in the
+config file. Rules are patterns, where * matches anything. Device rules match
+a camera's name, USB ID, or driver, and process rules match a process's
+command name, executable, or cgroup. Prefix a rule with name:, usb:,
+driver:, comm:, exe:, or cgroup: to only match that.
+
+When -processes is specified, it accepts a comma-separated list of process
+substrings to search for, which are added to the processes include rules.
+Only those processes usage of the webcam will be reported. Each substring
+matches any process whose command name or executable contains it, so
+-processes chrome matches chrome and chrome-bin. On windows, this speeds up
+the command considerably.
+
+devices is a comma-separated list of device paths to check instead of every
+camera that's listed, like /dev/video0 on linux, or Physical Device Object
+names, as shown in Device Manager, on windows.`
 }
 
 func (w watchCommand) Synopsis() string {
@@ -65,16 +72,8 @@ func (w watchCommand) Synopsis() string {
 }
 
 func (w watchCommand) Run(args []string) int {
-	// Windows can't list devices, so it can't check them all
-	// so we need to tell it which specific devices to check.
-	// It also has to check the handles for every single process
-	// because it has no mapping of processes by handler. This
-	// takes many seconds, and that's silly. We can speed it up
-	// by giving it a list of process substrings to check against.
-	var processes, devicePaths []string
 	var processesString string
-	var err error
-	var cycleTime time.Duration
+	var cycleTime, keepalive, timeout time.Duration
 
 	f := flag.NewFlagSet("watch", flag.ContinueOnError)
 	f.SetOutput(ioutil.Discard)
@@ -82,84 +81,52 @@ func (w watchCommand) Run(args []string) int {
 	f.Usage = func() {}
 
 	f.DurationVar(&cycleTime, "check-every", time.Minute, "how often to check webcam status, as a duration.")
-
-	if runtime.GOOS == "windows" {
-		f.StringVar(&processesString, "processes", "", "a comma-separated list of process substrings to limit your search to.")
-	}
+	f.DurationVar(&keepalive, "keepalive", 0, "only report when the status changes, or this long after the last report. 0 reports every check.")
+	f.DurationVar(&timeout, "timeout", 10*time.Second, "how long to wait for each detector")
+	f.StringVar(&processesString, "processes", "", "a comma-separated list of process substrings to limit your search to.")
 
 	f.Parse(args)
 
-	if runtime.GOOS == "windows" {
-		processes = strings.Split(processesString, ",")
-		for pos, p := range processes {
-			processes[pos] = strings.TrimSpace(p)
-		}
+	if len(f.Args()) > 1 {
+		return w.ui.Fail(exitUsage, fmt.Errorf("Incorrect number of arguments. watch command expects at most 1 arg, got %d.", len(f.Args())))
 	}
 
-	var numArgs int
-	if runtime.GOOS == "windows" {
-		numArgs = 1
+	mode, err := device.ParseMode(w.config.Detection.Mode)
+	if err != nil {
+		return w.ui.Fail(exitConfig, err)
 	}
-	if len(f.Args()) != numArgs {
-		w.ui.Error(fmt.Sprintf("Incorrect number of arguments. watch command expects %d args, got %d.", numArgs, len(f.Args())))
-		return 1
+	// stops anything the detectors leave running between checks
+	watchCtx, stop := context.WithCancel(w.ctx)
+	defer stop()
+	detectors, err := newDetectors(watchCtx, true, w.config, f.Args(), processFilter(w.config, processesString))
+	if errors.Is(err, device.ErrUnknownDetector) {
+		return w.ui.Fail(exitConfig, err)
+	}
+	if err != nil {
+		return w.ui.Fail(exitDevice, err)
 	}
 
-	for {
-
-		if runtime.GOOS == "windows" {
-			if len(f.Args()) > 0 {
-				devicePaths = strings.Split(f.Args()[0], ",")
-				for pos, p := range devicePaths {
-					devicePaths[pos] = strings.TrimSpace(p)
-				}
-			}
-		} else if runtime.GOOS == "linux" {
-			devicePaths, err = filepath.Glob("/dev/video*")
-			if err != nil {
-				w.ui.Error("Error listing devices: " + err.Error())
-				time.Sleep(time.Second)
-				continue
-			}
-		} else if runtime.GOOS == "darwin" {
-			// darwin doesn't use files or handlers to check
-			// we just call out to a binary that checks all our
-			// devices for us. So we just set a single empty
-			// path, because it's not going to be used anyways,
-			// and setting only one makes sure we call the code
-			// just the once.
-			devicePaths = append(devicePaths, "")
-		}
+	client, err := newAPIClient(w.config)
+	if err != nil {
+		return w.ui.Fail(exitConfig, err)
+	}
+	reporter := newReporter(client.update, keepalive)
+	go reporter.Run(w.ctx)
 
-		var webcamOn bool
-		for _, dev := range devicePaths {
-			inUse, err := device.InUse(w.ctx, dev, processes...)
-			if err != nil {
-				w.ui.Error("Error checking if device in use: " + err.Error())
-				time.Sleep(time.Second)
-				continue
-			}
-			inUseStr := ""
-			if !inUse {
-				inUseStr = " not"
-			}
-			if dev != "" {
-				w.ui.Info(fmt.Sprintf("Device %s is%s in use", dev, inUseStr))
-			} else {
-				w.ui.Info(fmt.Sprintf("Camera is%s in use", inUseStr))
-			}
-			if inUse {
-				webcamOn = true
-				break
-			}
-		}
-		err = update(w.ctx, webcamOn)
+	ticker := time.NewTicker(cycleTime)
+	defer ticker.Stop()
+	for {
+		result, err := checkDevices(w.ctx, detectors, mode, timeout)
 		if err != nil {
 			w.ui.Error(err.Error())
-			time.Sleep(time.Second)
-			continue
 		}
-		time.Sleep(cycleTime)
+		result.Reported = true
+		w.ui.Result(result, result.String())
+		reporter.Report(result.state())
+		select {
+		case <-ticker.C:
+		case <-w.ctx.Done():
+			return exitOK
+		}
 	}
-	return 0
 }
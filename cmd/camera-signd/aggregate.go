@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultPriority is the order states win in when more than one applies,
+// highest priority first.
+var defaultPriority = []SignState{SignBusyVideo, SignBusyAudio, SignDoNotDisturb, SignAway, SignFree}
+
+// AggregateInput is everything an Aggregator can take into account when
+// deciding on a SignState.
+type AggregateInput struct {
+	Now time.Time
+
+	// Statuses holds only the statuses that aren't stale.
+	Statuses  map[string]Status
+	Override  *Override
+	Schedules []Schedule
+}
+
+// Decision is the SignState an Aggregator chose, and why.
+type Decision struct {
+	State SignState
+
+	// Reason is what put the sign in State: "override", "device",
+	// "schedule", or "default" when nothing applied. The sign's hold-on
+	// and hold-off delays can also keep it in a state, with the reason
+	// "hold".
+	Reason string
+
+	// Devices lists the devices whose statuses put the sign in State.
+	Devices []string
+}
+
+// Aggregator decides which SignState the sign should display.
+type Aggregator interface {
+	Aggregate(in AggregateInput) Decision
+}
+
+// priorityAggregator collects every state that applies, from devices and
+// schedules, and picks the one that comes first in its order. An active
+// override always wins.
+type priorityAggregator struct {
+	order []SignState
+}
+
+func (a priorityAggregator) Aggregate(in AggregateInput) Decision {
+	if in.Override != nil && in.Override.active(in.Now) {
+		return Decision{State: in.Override.State, Reason: "override"}
+	}
+	candidates := map[SignState]*Decision{}
+	addDevice := func(state SignState, id string) {
+		if candidates[state] == nil {
+			candidates[state] = &Decision{State: state, Reason: "device"}
+		}
+		candidates[state].Devices = append(candidates[state].Devices, id)
+	}
+	for id, status := range in.Statuses {
+		if status.CameraOn {
+			addDevice(SignBusyVideo, id)
+		}
+		if status.MicOn {
+			addDevice(SignBusyAudio, id)
+		}
+	}
+	for _, schedule := range in.Schedules {
+		if schedule.active(in.Now) && candidates[schedule.State] == nil {
+			candidates[schedule.State] = &Decision{State: schedule.State, Reason: "schedule"}
+		}
+	}
+	for _, state := range a.order {
+		if decision, ok := candidates[state]; ok {
+			sort.Strings(decision.Devices)
+			return *decision
+		}
+	}
+	return Decision{State: SignFree, Reason: "default"}
+}
+
+// Override forces the sign into a state, regardless of device statuses and
+// schedules.
+type Override struct {
+	State SignState `json:"state"`
+
+	// Until is when the override expires. The zero value never expires.
+	Until time.Time `json:"until,omitempty"`
+}
+
+func (o Override) active(now time.Time) bool {
+	return o.Until.IsZero() || now.Before(o.Until)
+}
+
+// Schedule puts the sign into a state during a recurring window of time.
+type Schedule struct {
+	State SignState
+
+	// Days the window starts on. An empty set means every day.
+	Days map[time.Weekday]bool
+
+	// Start and End are minutes since midnight. If End is before Start,
+	// the window runs past midnight into the next day.
+	Start int
+	End   int
+}
+
+func (s Schedule) active(now time.Time) bool {
+	minute := now.Hour()*60 + now.Minute()
+	if s.Start <= s.End {
+		return s.onDay(now.Weekday()) && minute >= s.Start && minute < s.End
+	}
+	if minute >= s.Start {
+		return s.onDay(now.Weekday())
+	}
+	if minute < s.End {
+		return s.onDay((now.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+func (s Schedule) onDay(day time.Weekday) bool {
+	return len(s.Days) == 0 || s.Days[day]
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseClock parses a time of day like "17:30" into minutes since
+// midnight. "24:00" is allowed, to mean the end of the day.
+func parseClock(clock string) (int, error) {
+	parts := strings.Split(clock, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q, must be HH:MM", clock)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 24 {
+		return 0, fmt.Errorf("invalid hour in %q", clock)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid minute in %q", clock)
+	}
+	return hour*60 + minute, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScheduleActive(t *testing.T) {
+	// 2026-10-19 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+	}
+	workdays := map[time.Weekday]bool{
+		time.Monday: true, time.Tuesday: true, time.Wednesday: true,
+		time.Thursday: true, time.Friday: true,
+	}
+	workday := Schedule{State: SignDoNotDisturb, Days: workdays, Start: 9 * 60, End: 17 * 60}
+	overnight := Schedule{State: SignAway, Days: workdays, Start: 22 * 60, End: 6 * 60}
+	cases := map[string]struct {
+		schedule Schedule
+		now      time.Time
+		expected bool
+	}{
+		"before-start":               {schedule: workday, now: at(19, 8, 59), expected: false},
+		"at-start":                   {schedule: workday, now: at(19, 9, 0), expected: true},
+		"before-end":                 {schedule: workday, now: at(19, 16, 59), expected: true},
+		"at-end":                     {schedule: workday, now: at(19, 17, 0), expected: false},
+		"weekend":                    {schedule: workday, now: at(18, 10, 0), expected: false},
+		"every-day":                  {schedule: Schedule{Start: 9 * 60, End: 17 * 60}, now: at(18, 10, 0), expected: true},
+		"until-midnight":             {schedule: Schedule{Start: 20 * 60, End: 24 * 60}, now: at(19, 23, 59), expected: true},
+		"overnight-before-midnight":  {schedule: overnight, now: at(19, 23, 0), expected: true},
+		"overnight-after-midnight":   {schedule: overnight, now: at(20, 1, 0), expected: true},
+		"overnight-at-end":           {schedule: overnight, now: at(20, 6, 0), expected: false},
+		"overnight-daytime":          {schedule: overnight, now: at(20, 12, 0), expected: false},
+		"overnight-into-saturday":    {schedule: overnight, now: at(24, 1, 0), expected: true},
+		"overnight-starting-weekend": {schedule: overnight, now: at(24, 23, 0), expected: false},
+		"overnight-into-monday":      {schedule: overnight, now: at(19, 1, 0), expected: false},
+	}
+	for name, c := range cases {
+		if got := c.schedule.active(c.now); got != c.expected {
+			t.Errorf("%s: expected %v, got %v", name, c.expected, got)
+		}
+	}
+}
+
+func TestPriorityAggregator(t *testing.T) {
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	dnd := Schedule{State: SignDoNotDisturb, Start: 9 * 60, End: 17 * 60}
+	busyVideo := Schedule{State: SignBusyVideo, Start: 9 * 60, End: 17 * 60}
+	cases := map[string]struct {
+		order    []SignState
+		in       AggregateInput
+		expected Decision
+	}{
+		"nothing": {
+			in:       AggregateInput{},
+			expected: Decision{State: SignFree, Reason: "default"},
+		},
+		"devices-share-a-state": {
+			in: AggregateInput{Statuses: map[string]Status{
+				"laptop":  {CameraOn: true},
+				"desktop": {CameraOn: true, MicOn: true},
+				"phone":   {MicOn: true},
+			}},
+			expected: Decision{State: SignBusyVideo, Reason: "device", Devices: []string{"desktop", "laptop"}},
+		},
+		"device-beats-schedule": {
+			in: AggregateInput{
+				Statuses:  map[string]Status{"laptop": {MicOn: true}},
+				Schedules: []Schedule{dnd},
+			},
+			expected: Decision{State: SignBusyAudio, Reason: "device", Devices: []string{"laptop"}},
+		},
+		"order-beats-device": {
+			order: []SignState{SignDoNotDisturb, SignBusyVideo},
+			in: AggregateInput{
+				Statuses:  map[string]Status{"laptop": {CameraOn: true}},
+				Schedules: []Schedule{dnd},
+			},
+			expected: Decision{State: SignDoNotDisturb, Reason: "schedule"},
+		},
+		"device-and-schedule-tie": {
+			in: AggregateInput{
+				Statuses:  map[string]Status{"laptop": {CameraOn: true}},
+				Schedules: []Schedule{busyVideo},
+			},
+			expected: Decision{State: SignBusyVideo, Reason: "device", Devices: []string{"laptop"}},
+		},
+		"left-out-of-order": {
+			order: []SignState{SignBusyVideo, SignFree},
+			in: AggregateInput{
+				Statuses:  map[string]Status{"laptop": {MicOn: true}},
+				Schedules: []Schedule{dnd},
+			},
+			expected: Decision{State: SignFree, Reason: "default"},
+		},
+		"override": {
+			in: AggregateInput{
+				Statuses: map[string]Status{"laptop": {CameraOn: true}},
+				Override: &Override{State: SignAway, Until: now.Add(time.Minute)},
+			},
+			expected: Decision{State: SignAway, Reason: "override"},
+		},
+		"expired-override": {
+			in: AggregateInput{
+				Statuses: map[string]Status{"laptop": {CameraOn: true}},
+				Override: &Override{State: SignAway, Until: now},
+			},
+			expected: Decision{State: SignBusyVideo, Reason: "device", Devices: []string{"laptop"}},
+		},
+	}
+	for name, c := range cases {
+		order := c.order
+		if order == nil {
+			order = defaultPriority
+		}
+		c.in.Now = now
+		got := priorityAggregator{order: order}.Aggregate(c.in)
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: expected %+v, got %+v", name, c.expected, got)
+		}
+	}
+}
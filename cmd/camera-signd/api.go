@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"darlinggo.co/trout"
+	"yall.in"
+)
+
+// maxBodySize is the largest request body the API accepts.
+const maxBodySize = 64 * 1024
+
+// maxNameLength is the longest name a device can report.
+const maxNameLength = 128
+
+// deviceIDPattern matches the IDs devices report their status under. Those
+// are usually MAC addresses, but any short, URL-safe identifier works.
+var deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]{0,63}$`)
+
+// Error codes returned in APIError.Code.
+const (
+	errCodeInvalidID        = "invalid_id"
+	errCodeInvalidBody      = "invalid_body"
+	errCodeBodyTooLarge     = "body_too_large"
+	errCodeNotFound         = "not_found"
+	errCodeMethodNotAllowed = "method_not_allowed"
+	errCodeInternal         = "internal_error"
+	errCodeInvalidOverride  = "invalid_override"
+)
+
+// APIError is the body of every error response.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// SignReport tells clients what the sign will show after their request, and
+// whether its last update failed. The sign is updated in the background, so
+// a failure to reach it doesn't fail or slow down the request; the request's
+// changes were still saved.
+type SignReport struct {
+	State SignState `json:"state"`
+	Error string    `json:"error,omitempty"`
+}
+
+type patchStatusResponse struct {
+	Status Status     `json:"status"`
+	Sign   SignReport `json:"sign"`
+}
+
+// signResponse describes what the sign is showing, and why.
+type signResponse struct {
+	State SignState `json:"state"`
+	// Reason is "override", "device", "schedule", "default", or "hold".
+	Reason   string    `json:"reason"`
+	Devices  []string  `json:"devices,omitempty"`
+	Override *Override `json:"override,omitempty"`
+	// StaleAfter is how long device statuses count after they're
+	// reported.
+	StaleAfter duration `json:"staleAfter"`
+}
+
+type overrideResponse struct {
+	Override *Override  `json:"override"`
+	Sign     SignReport `json:"sign"`
+}
+
+// routes builds the router for the API. The unversioned routes are the
+// ones clients used before the API was versioned, and are kept so they
+// keep working.
+func (s *Server) routes(webhooks *webhookNotifier) http.Handler {
+	router := trout.Router{
+		Handle404: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, r, http.StatusNotFound, errCodeNotFound, "no such endpoint")
+		}),
+		Handle405: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, r.Method+" isn't supported for this endpoint")
+		}),
+	}
+	handle := func(route, method string, h http.HandlerFunc) {
+		router.Endpoint(route).Methods(method).Handler(s.metrics.instrument(route, h))
+	}
+	for _, prefix := range []string{"/v1", ""} {
+		handle(prefix+"/status", http.MethodGet, s.getStatusHandler)
+		handle(prefix+"/status/{id}", http.MethodGet, s.getDeviceStatusHandler)
+		handle(prefix+"/status/{id}", http.MethodPatch, s.patchStatusHandler)
+		handle(prefix+"/status", http.MethodDelete, s.deleteStatusHandler)
+		handle(prefix+"/sign", http.MethodGet, s.getSignHandler)
+		handle(prefix+"/override", http.MethodPut, s.putOverrideHandler)
+		handle(prefix+"/override", http.MethodDelete, s.deleteOverrideHandler)
+		if webhooks != nil {
+			handle(prefix+"/webhooks/deliveries", http.MethodGet, webhooks.deliveriesHandler)
+		}
+	}
+	handle("/v1/openapi.json", http.MethodGet, openAPIHandler)
+	router.Endpoint("/metrics").Methods(http.MethodGet).Handler(http.HandlerFunc(s.metricsHandler))
+	return router
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		yall.FromContext(r.Context()).WithError(err).Error("error encoding response")
+		status = http.StatusInternalServerError
+		b = []byte(`{"code":"` + errCodeInternal + `","message":"internal server error"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeJSON(w, r, status, APIError{Code: code, Message: message})
+}
+
+// decodeBody parses the JSON request body into v. If it can't, it writes
+// an error response and returns false.
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	defer r.Body.Close()
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(v)
+	if err == nil {
+		return true
+	}
+	// http.MaxBytesReader doesn't return a typed error we can check for
+	if err.Error() == "http: request body too large" {
+		writeError(w, r, http.StatusRequestEntityTooLarge, errCodeBodyTooLarge, "request body must be smaller than 64KiB")
+		return false
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidBody, "invalid JSON: "+err.Error())
+	default:
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidBody, "invalid request body: "+err.Error())
+	}
+	return false
+}
+
+func (s *Server) signReport() SignReport {
+	settings := s.current()
+	now := s.clock.Now()
+	decision := s.decide(now, settings)
+	s.stateMu.Lock()
+	// this doesn't update the hysteresis; the controller will when it
+	// gets to the change
+	_, decision, _ = s.hold.next(now, decision, settings.holdOn, settings.holdOff)
+	err := s.signErr
+	s.stateMu.Unlock()
+	report := SignReport{State: decision.State}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return report
+}
+
+func (s *Server) getStatusHandler(w http.ResponseWriter, r *http.Request) {
+	s.statusMu.RLock()
+	defer s.statusMu.RUnlock()
+	writeJSON(w, r, http.StatusOK, s.Statuses)
+}
+
+// deviceID returns the device ID from the request's path. If it's
+// invalid, it writes an error response and returns false.
+func deviceID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := trout.RequestVars(r).Get("id")
+	if !deviceIDPattern.MatchString(id) {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidID, "device IDs must be 1-64 letters, numbers, '.', '_', ':', or '-'")
+		return "", false
+	}
+	return id, true
+}
+
+func (s *Server) getDeviceStatusHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := deviceID(w, r)
+	if !ok {
+		return
+	}
+	s.statusMu.RLock()
+	status, ok := s.Statuses[id]
+	s.statusMu.RUnlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, errCodeNotFound, "device "+id+" hasn't reported its status")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, status)
+}
+
+func (s *Server) patchStatusHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := deviceID(w, r)
+	if !ok {
+		return
+	}
+	var status Status
+	if !decodeBody(w, r, &status) {
+		return
+	}
+	if len(status.Name) > maxNameLength {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidBody, "name must be at most 128 bytes")
+		return
+	}
+	status.LastSync = s.clock.Now()
+	settings := s.current()
+	change, stale := true, false
+	s.statusMu.Lock()
+	before, ok := s.Statuses[id]
+	if ok {
+		change = before.CameraOn != status.CameraOn || before.MicOn != status.MicOn
+		// a stale status stopped counting, so it counts again now
+		stale = status.LastSync.Sub(before.LastSync) > settings.staleAfter
+		// clients don't have to send their name every time
+		if status.Name == "" {
+			status.Name = before.Name
+		}
+	}
+	// names assigned in the config replace the ones devices report
+	if name := settings.devices[id].Name; name != "" {
+		status.Name = name
+	}
+	s.Statuses[id] = status
+	s.statusMu.Unlock()
+	if change {
+		yall.FromContext(r.Context()).WithField("device", id).WithField("cameraOn", status.CameraOn).WithField("micOn", status.MicOn).Info("device status changed")
+		for _, n := range s.notifiers {
+			n.DeviceChanged(r.Context(), id, status)
+		}
+	}
+	if change || stale {
+		s.requestSync()
+	}
+	writeJSON(w, r, http.StatusOK, patchStatusResponse{
+		Status: status,
+		Sign:   s.signReport(),
+	})
+}
+
+func (s *Server) deleteStatusHandler(w http.ResponseWriter, r *http.Request) {
+	s.statusMu.Lock()
+	removed := s.Statuses
+	s.Statuses = map[string]Status{}
+	s.statusMu.Unlock()
+
+	yall.FromContext(r.Context()).WithField("devices", len(removed)).Info("device statuses deleted")
+	for id := range removed {
+		for _, n := range s.notifiers {
+			n.DeviceRemoved(r.Context(), id)
+		}
+	}
+	s.requestSync()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getSignHandler(w http.ResponseWriter, r *http.Request) {
+	s.statusMu.RLock()
+	override := s.Override
+	s.statusMu.RUnlock()
+	if override != nil && !override.active(s.clock.Now()) {
+		override = nil
+	}
+	s.stateMu.Lock()
+	resp := signResponse{
+		State:      s.state,
+		Reason:     s.decision.Reason,
+		Devices:    s.decision.Devices,
+		Override:   override,
+		StaleAfter: duration(s.current().staleAfter),
+	}
+	s.stateMu.Unlock()
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+func (s *Server) putOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	var override Override
+	if !decodeBody(w, r, &override) {
+		return
+	}
+	if !override.Until.IsZero() && !override.Until.After(s.clock.Now()) {
+		writeError(w, r, http.StatusBadRequest, errCodeInvalidOverride, "until must be in the future")
+		return
+	}
+	s.setOverride(r.Context(), &override)
+	writeJSON(w, r, http.StatusOK, overrideResponse{
+		Override: &override,
+		Sign:     s.signReport(),
+	})
+}
+
+func (s *Server) deleteOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	s.setOverride(r.Context(), nil)
+	writeJSON(w, r, http.StatusOK, overrideResponse{
+		Sign: s.signReport(),
+	})
+}
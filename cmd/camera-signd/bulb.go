@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	bulbLightService  = "smartlife.iot.smartbulb.lightingservice"
+	stripLightService = "smartlife.iot.lightStrip"
+)
+
+// LightState is the colour and brightness a bulb should show. Hue is in
+// degrees (0-360), saturation and brightness are percentages.
+type LightState struct {
+	Off        bool `json:"off,omitempty"`
+	Hue        int  `json:"hue"`
+	Saturation int  `json:"saturation"`
+	Brightness int  `json:"brightness"`
+}
+
+// defaultLightStates are used for any state that doesn't have a colour
+// configured. Every SignState needs one.
+var defaultLightStates = map[SignState]LightState{
+	SignFree:         {Hue: 120, Saturation: 100, Brightness: 30},
+	SignBusyAudio:    {Hue: 40, Saturation: 100, Brightness: 100},
+	SignBusyVideo:    {Hue: 0, Saturation: 100, Brightness: 100},
+	SignDoNotDisturb: {Hue: 280, Saturation: 100, Brightness: 100},
+	// nobody's there to see it, like the plug turning the lamp off
+	SignAway: {Off: true},
+}
+
+// KLBulb is a KL-series Kasa smart bulb or light strip.
+type KLBulb struct {
+	IPAddress string
+
+	// Strip should be set for KL4xx light strips, which use a different
+	// service than the bulbs.
+	Strip bool
+
+	// Colors overrides defaultLightStates for the states it has set.
+	Colors map[SignState]LightState
+
+	// Transition is how long the bulb takes to fade between states.
+	Transition time.Duration
+}
+
+func (b *KLBulb) SetState(ctx context.Context, state SignState) error {
+	light, ok := b.Colors[state]
+	if !ok {
+		light, ok = defaultLightStates[state]
+	}
+	if !ok {
+		return fmt.Errorf("no light state for %s", state)
+	}
+	return b.SetLightState(ctx, light)
+}
+
+func (b *KLBulb) SetLightState(ctx context.Context, light LightState) error {
+	params := map[string]interface{}{}
+	if light.Off {
+		params["on_off"] = 0
+	} else {
+		params["on_off"] = 1
+		params["hue"] = light.Hue
+		params["saturation"] = light.Saturation
+		params["brightness"] = light.Brightness
+		// a colour temperature of 0 puts the bulb in colour mode
+		params["color_temp"] = 0
+	}
+	return b.transition(ctx, params)
+}
+
+// SetPower turns the bulb on or off, leaving its colour as it is.
+func (b *KLBulb) SetPower(ctx context.Context, on bool) error {
+	onOff := 0
+	if on {
+		onOff = 1
+	}
+	return b.transition(ctx, map[string]interface{}{"on_off": onOff})
+}
+
+// transition changes the bulb's light state to params.
+func (b *KLBulb) transition(ctx context.Context, params map[string]interface{}) error {
+	service, method := bulbLightService, "transition_light_state"
+	if b.Strip {
+		service, method = stripLightService, "set_light_state"
+	}
+	params["ignore_default"] = 1
+	params["transition_period"] = b.Transition.Milliseconds()
+	req, err := json.Marshal(map[string]interface{}{
+		service: map[string]interface{}{
+			method: params,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error building bulb request: %w", err)
+	}
+	reading, err := send(ctx, b.IPAddress, encrypt(string(req)))
+	if err != nil {
+		return err
+	}
+	var resp map[string]map[string]struct {
+		ErrCode int    `json:"err_code"`
+		ErrMsg  string `json:"err_msg"`
+	}
+	err = json.Unmarshal([]byte(decrypt(reading)), &resp)
+	if err != nil {
+		return fmt.Errorf("error parsing bulb response: %w", err)
+	}
+	result, ok := resp[service][method]
+	if !ok {
+		return fmt.Errorf("bulb response missing %s.%s", service, method)
+	}
+	if result.ErrCode != 0 {
+		return fmt.Errorf("bulb returned error %d: %s", result.ErrCode, result.ErrMsg)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDefaultLightStatesCoverEveryState(t *testing.T) {
+	for state := range signStateNames {
+		if _, ok := defaultLightStates[state]; !ok {
+			t.Errorf("expected a default light state for %s", state)
+		}
+	}
+}
+
+func TestKLBulbSetState(t *testing.T) {
+	cases := map[string]struct {
+		strip   bool
+		service string
+	}{
+		"bulb":  {service: bulbLightService},
+		"strip": {strip: true, service: stripLightService},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			kasa := &fakeKasa{lightService: c.service}
+			kasa.start(t)
+			defer kasa.Close()
+			bulb := &KLBulb{
+				IPAddress:  kasa.Addr(),
+				Strip:      c.strip,
+				Colors:     map[SignState]LightState{SignFree: {Hue: 200, Saturation: 50, Brightness: 10}},
+				Transition: 500 * time.Millisecond,
+			}
+			ctx := context.Background()
+
+			steps := []struct {
+				state SignState
+				light map[string]interface{}
+			}{
+				{
+					state: SignBusyVideo,
+					light: map[string]interface{}{"on_off": 1.0, "hue": 0.0, "saturation": 100.0, "brightness": 100.0, "color_temp": 0.0, "ignore_default": 1.0, "transition_period": 500.0},
+				},
+				{
+					// configured colours replace the defaults
+					state: SignFree,
+					light: map[string]interface{}{"on_off": 1.0, "hue": 200.0, "saturation": 50.0, "brightness": 10.0, "color_temp": 0.0, "ignore_default": 1.0, "transition_period": 500.0},
+				},
+				{
+					state: SignDoNotDisturb,
+					light: map[string]interface{}{"on_off": 1.0, "hue": 280.0, "saturation": 100.0, "brightness": 100.0, "color_temp": 0.0, "ignore_default": 1.0, "transition_period": 500.0},
+				},
+				{
+					// turning off leaves the colour as it was
+					state: SignAway,
+					light: map[string]interface{}{"on_off": 0.0, "hue": 280.0, "saturation": 100.0, "brightness": 100.0, "color_temp": 0.0, "ignore_default": 1.0, "transition_period": 500.0},
+				},
+			}
+			for _, step := range steps {
+				err := bulb.SetState(ctx, step.state)
+				if err != nil {
+					t.Fatalf("%s: unexpected error: %s", step.state, err)
+				}
+				if light := kasa.Light(); !reflect.DeepEqual(light, step.light) {
+					t.Errorf("%s: expected %+v, got %+v", step.state, step.light, light)
+				}
+			}
+		})
+	}
+}
+
+func TestKLBulbSetPower(t *testing.T) {
+	kasa := &fakeKasa{lightService: bulbLightService}
+	kasa.start(t)
+	defer kasa.Close()
+	bulb := &KLBulb{IPAddress: kasa.Addr()}
+	ctx := context.Background()
+
+	err := bulb.SetState(ctx, SignBusyAudio)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	err = bulb.SetPower(ctx, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string]interface{}{"on_off": 0.0, "hue": 40.0, "saturation": 100.0, "brightness": 100.0, "color_temp": 0.0, "ignore_default": 1.0, "transition_period": 0.0}
+	if light := kasa.Light(); !reflect.DeepEqual(light, expected) {
+		t.Errorf("expected %+v, got %+v", expected, light)
+	}
+}
+
+func TestKLBulbWrongService(t *testing.T) {
+	// a strip configured as a bulb, which the strip doesn't support
+	kasa := &fakeKasa{lightService: stripLightService}
+	kasa.start(t)
+	defer kasa.Close()
+	bulb := &KLBulb{IPAddress: kasa.Addr()}
+
+	err := bulb.SetState(context.Background(), SignBusyVideo)
+	if err == nil {
+		t.Error("expected an error, got nil")
+	}
+	if light := kasa.Light(); len(light) != 0 {
+		t.Errorf("expected the light state not to change, got %+v", light)
+	}
+}
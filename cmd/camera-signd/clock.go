@@ -0,0 +1,15 @@
+package main
+
+import "time"
+
+// clock tells the Server the time, so tests can control it.
+type clock interface {
+	Now() time.Time
+}
+
+// systemClock is the real time.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	signTypeHs1xx   = "hs1xx"
+	signTypeKLBulb  = "kl-bulb"
+	signTypeKLStrip = "kl-strip"
+	signTypeHs300   = "hs300"
+)
+
+// Config is the JSON configuration file for camera-signd.
+type Config struct {
+	// Listen is the address the API listens on. It defaults to ":9988".
+	Listen string `json:"listen,omitempty"`
+
+	// Sign is the device that displays the state. camera-signd drives
+	// one sign; to drive more, run one camera-signd for each.
+	Sign SignConfig `json:"sign"`
+
+	// Priority lists state names in the order they should win when more
+	// than one applies, highest priority first. States left out never
+	// win, except through an override.
+	Priority []string `json:"priority,omitempty"`
+
+	Schedules []ScheduleConfig `json:"schedules,omitempty"`
+
+	MQTT *MQTTConfig `json:"mqtt,omitempty"`
+
+	Webhooks []WebhookConfig `json:"webhooks,omitempty"`
+
+	Log LogConfig `json:"log,omitempty"`
+
+	TLS *TLSConfig `json:"tls,omitempty"`
+
+	Discovery DiscoveryConfig `json:"discovery,omitempty"`
+
+	Timing TimingConfig `json:"timing,omitempty"`
+
+	// Devices configures devices, by their IDs.
+	Devices map[string]DeviceConfig `json:"devices,omitempty"`
+
+	// StateFile is where device statuses and the override are saved when
+	// camera-signd shuts down, and restored from when it starts. It
+	// defaults to state.json in camera-signd's directory in the user's
+	// config directory.
+	StateFile string `json:"stateFile,omitempty"`
+}
+
+// TimingConfig controls how often the sign is updated.
+type TimingConfig struct {
+	// StaleAfter is how long a device's status counts toward the sign's
+	// state after it was last reported. It defaults to 15m.
+	StaleAfter duration `json:"staleAfter,omitempty"`
+
+	// SyncEvery is how often the sign is set to its state, even if it
+	// hasn't changed, in case it was changed by something else. It
+	// defaults to 1m.
+	SyncEvery duration `json:"syncEvery,omitempty"`
+
+	// Debounce is how long changes are collected before the sign is
+	// updated, so a burst of them only updates it once. It defaults to
+	// 200ms.
+	Debounce duration `json:"debounce,omitempty"`
+}
+
+// DeviceConfig configures a device that reports its status.
+type DeviceConfig struct {
+	// Name replaces the name the device reports, from its next report.
+	Name string `json:"name,omitempty"`
+
+	// Ignore stops the device's status from counting toward the sign's
+	// state. It's still stored and shown.
+	Ignore bool `json:"ignore,omitempty"`
+}
+
+// LogConfig controls camera-signd's logging. Each option falls back to an
+// environment variable, LOG_LEVEL and LOG_FORMAT, when it isn't set.
+type LogConfig struct {
+	// Level is DEBUG, INFO, WARNING, or ERROR. It defaults to INFO.
+	Level string `json:"level,omitempty"`
+
+	// Format is "text" or "json". It defaults to "text".
+	Format string `json:"format,omitempty"`
+}
+
+// ScheduleConfig puts the sign into State between Start and End, given as
+// "HH:MM", on Days, given as "mon", "tue", etc. No Days means every day.
+type ScheduleConfig struct {
+	State string   `json:"state"`
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+// OutletConfig lists the states an outlet of a power strip should be
+// turned on for.
+type OutletConfig struct {
+	Index    int      `json:"index"`
+	OnStates []string `json:"onStates"`
+}
+
+// SignConfig describes the device that displays the sign's state.
+type SignConfig struct {
+	// Type is one of "hs1xx", "hs300", "kl-bulb", or "kl-strip". It
+	// defaults to "hs1xx".
+	Type string `json:"type"`
+
+	// Address is the sign's IP address or hostname, optionally with a
+	// port, which defaults to Kasa's 9999.
+	Address string `json:"address"`
+
+	// OnStates lists the states an hs1xx plug should be turned on for.
+	OnStates []string `json:"onStates,omitempty"`
+
+	// Emeter turns on reading the energy meter of hs1xx plugs that have
+	// one, like the HS110, for the metrics endpoint.
+	Emeter bool `json:"emeter,omitempty"`
+
+	// Outlets configures each outlet of an hs300 strip.
+	Outlets []OutletConfig `json:"outlets,omitempty"`
+
+	// Colors maps state names to the light state bulbs and strips
+	// should display for them.
+	Colors map[string]LightState `json:"colors,omitempty"`
+
+	// Transition is how long bulbs and strips should fade between
+	// colours, e.g. "500ms".
+	Transition duration `json:"transition,omitempty"`
+
+	// HoldOn is how long the sign waits before changing to any state
+	// but free, and HoldOff is how long it waits before changing to
+	// free, like "30s". The change only happens if it's still wanted
+	// after the wait, so apps briefly opening or closing the camera don't
+	// make the sign flicker. They default to 0, changing straight away.
+	// Overrides always apply straight away.
+	HoldOn  duration `json:"holdOn,omitempty"`
+	HoldOff duration `json:"holdOff,omitempty"`
+
+	// OnShutdown is what to do with the sign when camera-signd shuts
+	// down: "leave" it showing its last state, which is the default, or
+	// turn it "off" or "on".
+	OnShutdown string `json:"onShutdown,omitempty"`
+}
+
+// duration is a time.Duration that is written as a string in JSON.
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+// loadConfig reads the config file at path. Unknown fields are errors, so
+// typos don't go unnoticed, and syntax errors say where they are.
+func loadConfig(path string) (Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("error reading config file: %w", err)
+	}
+	var config Config
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&config)
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			// the offset is just after the character that's wrong
+			line, col := lineCol(b, syntaxErr.Offset-1)
+			return Config{}, fmt.Errorf("error parsing config file %s at line %d, column %d: %w", path, line, col, err)
+		case errors.As(err, &typeErr):
+			line, col := lineCol(b, typeErr.Offset)
+			return Config{}, fmt.Errorf("error parsing config file %s at line %d, column %d: %s must be %s, not %s", path, line, col, typeErr.Field, typeErr.Type, typeErr.Value)
+		case strings.HasPrefix(err.Error(), unknownFieldPrefix):
+			// the json package doesn't say where unknown fields are,
+			// so look for the first key with the same name
+			name := strings.TrimPrefix(err.Error(), unknownFieldPrefix)
+			if offset := keyOffset(b, name); offset >= 0 {
+				line, col := lineCol(b, offset)
+				return Config{}, fmt.Errorf("error parsing config file %s at line %d, column %d: unknown field %s", path, line, col, name)
+			}
+		}
+		return Config{}, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// loadServerConfig loads and validates the config camera-signd runs with:
+// the file at path, if it's set, with outlet replacing its sign, if that's
+// set, and defaults filled in.
+func loadServerConfig(path, outlet string) (Config, error) {
+	var config Config
+	if path != "" {
+		var err error
+		config, err = loadConfig(path)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+	if config.Log.Level == "" {
+		config.Log.Level = os.Getenv("LOG_LEVEL")
+	}
+	if config.Log.Format == "" {
+		config.Log.Format = os.Getenv("LOG_FORMAT")
+	}
+	if outlet != "" {
+		config.Sign = SignConfig{Type: signTypeHs1xx, Address: outlet}
+	}
+	if config.Listen == "" {
+		config.Listen = defaultListen
+	}
+	err := config.validate()
+	if err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// configCommand runs camera-signd config, returning its exit code.
+func configCommand(args []string) int {
+	usage := func() int {
+		fmt.Println("Usage: camera-signd config validate {CONFIG FILE}")
+		return 2
+	}
+	if len(args) == 0 || args[0] != "validate" {
+		return usage()
+	}
+	var path string
+	f := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+	f.StringVar(&path, "config", "", "path to a JSON config file")
+	err := f.Parse(args[1:])
+	if err != nil {
+		return usage()
+	}
+	if path == "" && f.NArg() == 1 {
+		path = f.Arg(0)
+	} else if path == "" || f.NArg() != 0 {
+		return usage()
+	}
+	_, err = loadServerConfig(path, "")
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	fmt.Printf("config file %s is valid\n", path)
+	return 0
+}
+
+// unknownFieldPrefix starts the errors json.Decoder returns for unknown
+// fields, followed by the quoted field name.
+const unknownFieldPrefix = "json: unknown field "
+
+// keyOffset returns the offset in b of the first object key that's quoted,
+// which is a JSON string including its quotes, or -1 if there isn't one.
+func keyOffset(b []byte, quoted string) int64 {
+	for start := 0; start < len(b); {
+		i := bytes.Index(b[start:], []byte(quoted))
+		if i < 0 {
+			return -1
+		}
+		i += start
+		rest := bytes.TrimLeft(b[i+len(quoted):], " \t\r\n")
+		if len(rest) > 0 && rest[0] == ':' {
+			return int64(i)
+		}
+		start = i + 1
+	}
+	return -1
+}
+
+// lineCol converts an offset into b to a line and column, counting from 1.
+func lineCol(b []byte, offset int64) (int, int) {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	before := b[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+// configError lists everything wrong with a config.
+type configError []string
+
+func (e configError) Error() string {
+	return "invalid config:\n  " + strings.Join(e, "\n  ")
+}
+
+// validate checks everything in the config that can be checked without
+// connecting to anything, and returns a configError listing every problem
+// it finds.
+func (c Config) validate() error {
+	var problems configError
+	add := func(field string, err error) {
+		if err != nil {
+			problems = append(problems, field+": "+err.Error())
+		}
+	}
+	if c.Listen != "" {
+		_, _, err := net.SplitHostPort(c.Listen)
+		add("listen", err)
+	}
+	_, err := c.Sign.build()
+	add("sign", err)
+	_, err = c.aggregator()
+	add("priority", err)
+	_, err = c.schedules()
+	add("schedules", err)
+	if c.MQTT != nil {
+		add("mqtt", c.MQTT.validate())
+	}
+	if len(c.Webhooks) > 0 {
+		_, err = newWebhookNotifier(context.Background(), c.Webhooks)
+		add("webhooks", err)
+	}
+	if c.TLS != nil {
+		add("tls", c.TLS.validate())
+	}
+	if c.Timing.StaleAfter < 0 {
+		add("timing.staleAfter", errors.New("must not be negative"))
+	}
+	if c.Timing.SyncEvery < 0 {
+		add("timing.syncEvery", errors.New("must not be negative"))
+	}
+	if c.Timing.Debounce < 0 {
+		add("timing.debounce", errors.New("must not be negative"))
+	}
+	ids := make([]string, 0, len(c.Devices))
+	for id := range c.Devices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if !deviceIDPattern.MatchString(id) {
+			add(fmt.Sprintf("devices[%q]", id), errors.New("device IDs must be 1-64 letters, numbers, '.', '_', ':', or '-'"))
+		}
+		if len(c.Devices[id].Name) > maxNameLength {
+			add(fmt.Sprintf("devices[%q].name", id), fmt.Errorf("must be at most %d bytes", maxNameLength))
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// settings are the parts of the config that can be reloaded while
+// camera-signd is running.
+type settings struct {
+	sign       Sign
+	emeter     emeterReader
+	aggregator Aggregator
+	schedules  []Schedule
+	onShutdown string
+	holdOn     time.Duration
+	holdOff    time.Duration
+	staleAfter time.Duration
+	syncEvery  time.Duration
+	debounce   time.Duration
+	devices    map[string]DeviceConfig
+}
+
+// settings builds the config's reloadable settings. The config should
+// already be valid.
+func (c Config) settings() (settings, error) {
+	sign, err := c.Sign.build()
+	if err != nil {
+		return settings{}, fmt.Errorf("invalid sign config: %w", err)
+	}
+	aggregator, err := c.aggregator()
+	if err != nil {
+		return settings{}, fmt.Errorf("invalid priority config: %w", err)
+	}
+	schedules, err := c.schedules()
+	if err != nil {
+		return settings{}, fmt.Errorf("invalid schedules config: %w", err)
+	}
+	st := settings{
+		sign:       sign,
+		aggregator: aggregator,
+		schedules:  schedules,
+		onShutdown: c.Sign.OnShutdown,
+		holdOn:     time.Duration(c.Sign.HoldOn),
+		holdOff:    time.Duration(c.Sign.HoldOff),
+		staleAfter: time.Duration(c.Timing.StaleAfter),
+		syncEvery:  time.Duration(c.Timing.SyncEvery),
+		debounce:   time.Duration(c.Timing.Debounce),
+		devices:    c.Devices,
+	}
+	if meter, ok := sign.(emeterReader); ok && c.Sign.Emeter {
+		st.emeter = meter
+	}
+	if st.staleAfter == 0 {
+		st.staleAfter = defaultStaleAfter
+	}
+	if st.syncEvery == 0 {
+		st.syncEvery = defaultSyncEvery
+	}
+	if st.debounce == 0 {
+		st.debounce = defaultDebounce
+	}
+	if st.onShutdown == "" {
+		st.onShutdown = shutdownLeave
+	}
+	return st, nil
+}
+
+// needsRestart returns the sections of the config that changed between c
+// and next that can't be reloaded while camera-signd is running.
+func (c Config) needsRestart(next Config) []string {
+	var changed []string
+	if c.Listen != next.Listen {
+		changed = append(changed, "listen")
+	}
+	if !reflect.DeepEqual(c.MQTT, next.MQTT) {
+		changed = append(changed, "mqtt")
+	}
+	if !reflect.DeepEqual(c.Webhooks, next.Webhooks) {
+		changed = append(changed, "webhooks")
+	}
+	if c.Log != next.Log {
+		changed = append(changed, "log")
+	}
+	if !reflect.DeepEqual(c.TLS, next.TLS) {
+		changed = append(changed, "tls")
+	}
+	if c.Discovery != next.Discovery {
+		changed = append(changed, "discovery")
+	}
+	if c.StateFile != next.StateFile {
+		changed = append(changed, "stateFile")
+	}
+	return changed
+}
+
+func (c SignConfig) build() (Sign, error) {
+	if c.Address == "" {
+		return nil, fmt.Errorf("sign address must be set")
+	}
+	if c.HoldOn < 0 || c.HoldOff < 0 {
+		return nil, fmt.Errorf("sign holdOn and holdOff must not be negative")
+	}
+	switch c.OnShutdown {
+	case "", shutdownLeave, shutdownOff, shutdownOn:
+	default:
+		return nil, fmt.Errorf("unknown sign onShutdown %q; must be %s, %s, or %s", c.OnShutdown, shutdownLeave, shutdownOff, shutdownOn)
+	}
+	switch c.Type {
+	case "", signTypeHs1xx:
+		plug := &Hs1xxPlug{IPAddress: c.Address}
+		if c.OnStates != nil {
+			onStates, err := parseSignStates(c.OnStates)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sign onStates: %w", err)
+			}
+			plug.OnStates = onStates
+		}
+		return plug, nil
+	case signTypeHs300:
+		if len(c.Outlets) == 0 {
+			return nil, fmt.Errorf("hs300 signs need at least one outlet")
+		}
+		outlets := map[int]map[SignState]bool{}
+		for _, outlet := range c.Outlets {
+			onStates, err := parseSignStates(outlet.OnStates)
+			if err != nil {
+				return nil, fmt.Errorf("invalid onStates for outlet %d: %w", outlet.Index, err)
+			}
+			outlets[outlet.Index] = onStates
+		}
+		return &Hs300Strip{IPAddress: c.Address, Outlets: outlets}, nil
+	case signTypeKLBulb, signTypeKLStrip:
+		colors := map[SignState]LightState{}
+		for name, light := range c.Colors {
+			state, err := parseSignState(name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sign colour: %w", err)
+			}
+			colors[state] = light
+		}
+		return &KLBulb{
+			IPAddress:  c.Address,
+			Strip:      c.Type == signTypeKLStrip,
+			Colors:     colors,
+			Transition: time.Duration(c.Transition),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown sign type %q", c.Type)
+	}
+}
+
+func (c Config) aggregator() (Aggregator, error) {
+	if len(c.Priority) == 0 {
+		return priorityAggregator{order: defaultPriority}, nil
+	}
+	order := make([]SignState, 0, len(c.Priority))
+	for _, name := range c.Priority {
+		state, err := parseSignState(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority: %w", err)
+		}
+		order = append(order, state)
+	}
+	return priorityAggregator{order: order}, nil
+}
+
+func (c Config) schedules() ([]Schedule, error) {
+	schedules := make([]Schedule, 0, len(c.Schedules))
+	for pos, sc := range c.Schedules {
+		state, err := parseSignState(sc.State)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %d: %w", pos, err)
+		}
+		schedule := Schedule{State: state, Days: map[time.Weekday]bool{}}
+		for _, day := range sc.Days {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return nil, fmt.Errorf("invalid schedule %d: unknown day %q", pos, day)
+			}
+			schedule.Days[weekday] = true
+		}
+		schedule.Start, err = parseClock(sc.Start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %d start: %w", pos, err)
+		}
+		schedule.End, err = parseClock(sc.End)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %d end: %w", pos, err)
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
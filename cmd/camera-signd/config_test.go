@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "camera-signd-config")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := map[string]struct {
+		config   string
+		expected string
+	}{
+		"unknown-field": {
+			config: `{
+  "sign": {
+    "adress": "10.0.0.2"
+  }
+}`,
+			expected: `at line 3, column 5: unknown field "adress"`,
+		},
+		"unknown-field-used-as-value-first": {
+			config: `{
+  "listen": "color",
+  "color": "red"
+}`,
+			expected: `at line 3, column 3: unknown field "color"`,
+		},
+		"syntax": {
+			config: `{
+  "listen": ":9988"
+  "sign": {}
+}`,
+			expected: `at line 3, column 3: invalid character '"' after object key:value pair`,
+		},
+		"type": {
+			config: `{
+  "sign": {
+    "address": "10.0.0.2",
+    "emeter": "yes"
+  }
+}`,
+			expected: `at line 4, column 20: sign.emeter must be bool, not string`,
+		},
+		"duration": {
+			config: `{
+  "timing": {"staleAfter": "soon"}
+}`,
+			expected: `time: invalid duration`,
+		},
+	}
+	for name, c := range cases {
+		path := filepath.Join(dir, name+".json")
+		err := ioutil.WriteFile(path, []byte(c.config), 0600)
+		if err != nil {
+			t.Fatalf("%s: unexpected error writing config: %s", name, err)
+		}
+		_, err = loadConfig(path)
+		if err == nil {
+			t.Errorf("%s: expected an error, got nil", name)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("%s: expected an error containing %q, got %q", name, c.expected, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	sign := SignConfig{Address: "10.0.0.2"}
+	cases := map[string]struct {
+		config   Config
+		expected configError
+	}{
+		"valid": {
+			config: Config{Listen: ":9988", Sign: sign},
+		},
+		"no-sign-address": {
+			config:   Config{},
+			expected: configError{"sign: sign address must be set"},
+		},
+		"every-problem": {
+			config: Config{
+				Listen:   "9988",
+				Sign:     SignConfig{Address: "10.0.0.2", Type: "neon", HoldOn: -1},
+				Priority: []string{"busy-video", "purple"},
+				Schedules: []ScheduleConfig{
+					{State: "dnd", Days: []string{"someday"}, Start: "09:00", End: "10:00"},
+				},
+				Timing: TimingConfig{StaleAfter: -1, Debounce: -1},
+				Devices: map[string]DeviceConfig{
+					"laptop":   {Name: strings.Repeat("x", maxNameLength+1)},
+					"a laptop": {},
+				},
+			},
+			expected: configError{
+				"listen: address 9988: missing port in address",
+				"sign: sign holdOn and holdOff must not be negative",
+				`priority: invalid priority: unknown sign state "purple"`,
+				`schedules: invalid schedule 0: unknown day "someday"`,
+				"timing.staleAfter: must not be negative",
+				"timing.debounce: must not be negative",
+				`devices["a laptop"]: device IDs must be 1-64 letters, numbers, '.', '_', ':', or '-'`,
+				fmt.Sprintf(`devices["laptop"].name: must be at most %d bytes`, maxNameLength),
+			},
+		},
+		"schedule-clock": {
+			config: Config{
+				Sign:      sign,
+				Schedules: []ScheduleConfig{{State: "away", Start: "25:00", End: "10:00"}},
+			},
+			expected: configError{`schedules: invalid schedule 0 start: invalid hour in "25:00"`},
+		},
+	}
+	for name, c := range cases {
+		err := c.config.validate()
+		if c.expected == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", name, err)
+			}
+			continue
+		}
+		problems, ok := err.(configError)
+		if !ok {
+			t.Errorf("%s: expected a configError, got %#v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(problems, c.expected) {
+			t.Errorf("%s: expected %+v, got %+v", name, c.expected, problems)
+		}
+	}
+}
+
+func TestNeedsRestart(t *testing.T) {
+	started := Config{
+		Listen: ":9988",
+		Sign:   SignConfig{Address: "10.0.0.2"},
+		MQTT:   &MQTTConfig{Broker: "tcp://broker:1883"},
+		Log:    LogConfig{Level: "INFO"},
+	}
+	cases := map[string]struct {
+		change   func(c *Config)
+		expected []string
+	}{
+		"unchanged": {
+			change: func(c *Config) {},
+		},
+		"reloadable": {
+			change: func(c *Config) {
+				c.Sign = SignConfig{Address: "10.0.0.3", Type: signTypeKLBulb, HoldOn: duration(time.Second)}
+				c.Priority = []string{"dnd", "busy-video"}
+				c.Schedules = []ScheduleConfig{{State: "away", Start: "18:00", End: "09:00"}}
+				c.Timing = TimingConfig{StaleAfter: duration(time.Minute)}
+				c.Devices = map[string]DeviceConfig{"laptop": {Ignore: true}}
+			},
+		},
+		"listen": {
+			change:   func(c *Config) { c.Listen = ":9989" },
+			expected: []string{"listen"},
+		},
+		"mqtt-broker": {
+			change:   func(c *Config) { c.MQTT = &MQTTConfig{Broker: "tcp://other:1883"} },
+			expected: []string{"mqtt"},
+		},
+		"mqtt-removed": {
+			change:   func(c *Config) { c.MQTT = nil },
+			expected: []string{"mqtt"},
+		},
+		"several": {
+			change: func(c *Config) {
+				c.Log.Format = "json"
+				c.TLS = &TLSConfig{CertFile: "sign.crt", KeyFile: "sign.key"}
+				c.StateFile = "/var/lib/camera-signd/state.json"
+				c.Webhooks = []WebhookConfig{{URL: "https://example.com/hook"}}
+			},
+			expected: []string{"webhooks", "log", "tls", "stateFile"},
+		},
+	}
+	for name, c := range cases {
+		next := started
+		next.MQTT = &MQTTConfig{Broker: started.MQTT.Broker}
+		c.change(&next)
+		got := started.needsRestart(next)
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: expected %+v, got %+v", name, c.expected, got)
+		}
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	kasa := &fakeKasa{}
+	kasa.start(t)
+	defer kasa.Close()
+	started := Config{Sign: SignConfig{Address: kasa.Addr()}}
+	st, err := started.settings()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s := newTestServer(t, st.sign, 0)
+	defer s.stop()
+
+	patchStatus(t, s, "laptop", Status{CameraOn: true})
+	waitFor(t, "the plug to turn on", func() bool { return kasa.Relay() == 1 })
+
+	// the plug is only on for dnd now, so it turns off, without the
+	// laptop's status being forgotten
+	next := started
+	next.Sign.OnStates = []string{"dnd"}
+	next.Timing.StaleAfter = duration(time.Hour)
+	s.reloadConfig(context.Background(), started, func() (Config, error) {
+		return next, nil
+	})
+	waitFor(t, "the plug to turn off", func() bool { return kasa.Relay() == 0 })
+	if got := s.current().staleAfter; got != time.Hour {
+		t.Errorf("expected staleAfter to be reloaded as %s, got %s", time.Hour, got)
+	}
+	s.statusMu.RLock()
+	status, ok := s.Statuses["laptop"]
+	s.statusMu.RUnlock()
+	if !ok || !status.CameraOn {
+		t.Errorf("expected the laptop's status to be kept, got %+v", status)
+	}
+
+	// a config that doesn't load keeps the current settings
+	s.reloadConfig(context.Background(), started, func() (Config, error) {
+		return Config{}, configError{"sign: sign address must be set"}
+	})
+	if got := s.current().staleAfter; got != time.Hour {
+		t.Errorf("expected staleAfter to be kept as %s, got %s", time.Hour, got)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"yall.in"
+)
+
+// requestSync tells runSign to update the sign, without waiting for it.
+func (s *Server) requestSync() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+		// runSign already has a wake-up waiting
+	}
+}
+
+// runSign is the only thing that talks to the sign, so nothing else waits on
+// it. When it's woken by requestSync, it collects changes for the debounce
+// interval and then updates the sign. It also updates it when a held change
+// is due, and every syncEvery, in case something else changed it. It
+// returns when ctx is done.
+func (s *Server) runSign(ctx context.Context) {
+	periodic := time.NewTimer(s.current().syncEvery)
+	defer periodic.Stop()
+	var debounce, held <-chan time.Time
+	for {
+		select {
+		case <-s.wake:
+			if debounce == nil {
+				debounce = time.After(s.current().debounce)
+			}
+			continue
+		case <-debounce:
+		case <-held:
+		case <-periodic.C:
+			if emeter := s.current().emeter; emeter != nil {
+				reading, err := emeter.Realtime(ctx)
+				if err != nil {
+					yall.FromContext(ctx).WithError(err).Warn("error reading emeter")
+				}
+				s.metrics.observeEmeter(reading, err)
+			}
+		case <-ctx.Done():
+			yall.FromContext(ctx).WithError(ctx.Err()).Debug("context finished")
+			return
+		}
+		debounce, held = nil, nil
+		recheck, err := s.syncSign(ctx)
+		if err != nil && ctx.Err() == nil {
+			yall.FromContext(ctx).WithError(err).Error("error syncing sign")
+		}
+		if recheck > 0 {
+			held = time.After(recheck)
+		}
+		// the interval can change when the config is reloaded
+		if !periodic.Stop() {
+			select {
+			case <-periodic.C:
+			default:
+			}
+		}
+		periodic.Reset(s.current().syncEvery)
+	}
+}
+
+// decide works out what the sign should show, from a snapshot of the device
+// statuses and override.
+func (s *Server) decide(now time.Time, settings settings) Decision {
+	s.statusMu.RLock()
+	statuses := make(map[string]Status, len(s.Statuses))
+	for id, status := range s.Statuses {
+		if now.Sub(status.LastSync) > settings.staleAfter || settings.devices[id].Ignore {
+			continue
+		}
+		statuses[id] = status
+	}
+	override := s.Override
+	s.statusMu.RUnlock()
+
+	return settings.aggregator.Aggregate(AggregateInput{
+		Now:       now,
+		Statuses:  statuses,
+		Override:  override,
+		Schedules: settings.schedules,
+	})
+}
+
+// syncSign sets the sign to the state it should be showing, and returns how
+// long until a held change is due, if one is. Only runSign calls it, and it
+// holds no locks while it talks to the sign.
+func (s *Server) syncSign(ctx context.Context) (time.Duration, error) {
+	settings := s.current()
+	now := s.clock.Now()
+	decision := s.decide(now, settings)
+
+	s.stateMu.Lock()
+	var recheck time.Duration
+	s.hold, decision, recheck = s.hold.next(now, decision, settings.holdOn, settings.holdOff)
+	state := decision.State
+	before, known := s.state, s.stateKnown
+	changed := !known || before != state
+	s.state, s.stateKnown = state, true
+	s.decision = decision
+	s.stateMu.Unlock()
+	if changed {
+		logger := yall.FromContext(ctx).WithField("state", state.String()).WithField("reason", decision.Reason)
+		if known {
+			logger = logger.WithField("from", before.String())
+		}
+		if len(decision.Devices) > 0 {
+			logger = logger.WithField("devices", decision.Devices)
+		}
+		logger.Info("sign state changed")
+		for _, n := range s.notifiers {
+			n.SignChanged(ctx, state)
+		}
+	}
+	start := time.Now()
+	err := settings.sign.SetState(ctx, state)
+	s.metrics.observeSign(state, time.Since(start), err)
+
+	s.stateMu.Lock()
+	s.signErr = err
+	s.stateMu.Unlock()
+	return recheck, err
+}
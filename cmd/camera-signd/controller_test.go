@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPatchStatusDoesNotWaitForSign(t *testing.T) {
+	sign := &fakeSign{block: make(chan struct{})}
+	s := newTestServer(t, sign, 0)
+	defer s.stop()
+	defer close(sign.block)
+
+	resp := patchStatus(t, s, "laptop", Status{CameraOn: true})
+	if resp.Sign.State != SignBusyVideo {
+		t.Errorf("expected %+v, got %+v", SignBusyVideo, resp.Sign.State)
+	}
+
+	// the controller is now stuck talking to the sign, and updates
+	// shouldn't have to wait for it
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			patchStatus(t, s, fmt.Sprintf("device-%d", i), Status{MicOn: true})
+		}(i)
+	}
+	wg.Wait()
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("expected updates not to wait for the sign, took %s", took)
+	}
+}
+
+func TestSyncSignDebounces(t *testing.T) {
+	sign := &fakeSign{}
+	s := newTestServer(t, sign, 100*time.Millisecond)
+	defer s.stop()
+
+	patchStatus(t, s, "laptop", Status{CameraOn: true})
+	patchStatus(t, s, "phone", Status{MicOn: true})
+	patchStatus(t, s, "laptop", Status{CameraOn: false})
+
+	waitFor(t, "the sign to be set", func() bool {
+		return len(sign.States()) > 0
+	})
+	// give any extra updates a chance to happen
+	time.Sleep(200 * time.Millisecond)
+	states := sign.States()
+	if len(states) != 1 || states[0] != SignBusyAudio {
+		t.Errorf("expected %+v, got %+v", []SignState{SignBusyAudio}, states)
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	sign := &fakeSign{}
+	s := newTestServer(t, sign, time.Millisecond)
+	defer s.stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("device-%d", i)
+			for j := 0; j < 20; j++ {
+				patchStatus(t, s, id, Status{CameraOn: j%2 == 0})
+				for _, path := range []string{"/v1/sign", "/v1/status", "/metrics"} {
+					w := s.do(http.MethodGet, path, "")
+					if w.Code != http.StatusOK {
+						t.Errorf("expected status %d for %s, got %d", http.StatusOK, path, w.Code)
+					}
+				}
+			}
+			// every device ends with its camera off
+			patchStatus(t, s, id, Status{CameraOn: false})
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 20; j++ {
+			s.settingsMu.Lock()
+			s.settings.debounce = time.Duration(j%3) * time.Millisecond
+			s.settingsMu.Unlock()
+			s.requestSync()
+		}
+	}()
+	wg.Wait()
+
+	waitFor(t, "the sign to show every camera is off", func() bool {
+		states := sign.States()
+		return len(states) > 0 && states[len(states)-1] == SignFree
+	})
+}
+
+func TestSyncSignIgnoresStaleStatuses(t *testing.T) {
+	kasa := &fakeKasa{}
+	kasa.start(t)
+	defer kasa.Close()
+	s := newTestServer(t, &Hs1xxPlug{IPAddress: kasa.Addr()}, 0)
+	defer s.stop()
+
+	patchStatus(t, s, "laptop", Status{CameraOn: true})
+	waitFor(t, "the plug to turn on", func() bool {
+		return kasa.Relay() == 1
+	})
+
+	// still counts right up until it's stale
+	s.clock.Advance(defaultStaleAfter)
+	if decision := s.decide(s.clock.Now(), s.current()); decision.State != SignBusyVideo {
+		t.Errorf("expected %+v, got %+v", SignBusyVideo, decision.State)
+	}
+
+	s.clock.Advance(time.Second)
+	if decision := s.decide(s.clock.Now(), s.current()); decision.State != SignFree {
+		t.Errorf("expected %+v, got %+v", SignFree, decision.State)
+	}
+	s.requestSync()
+	waitFor(t, "the plug to turn off", func() bool {
+		return kasa.Relay() == 0
+	})
+
+	// reporting again makes it count again, even though nothing changed
+	patchStatus(t, s, "laptop", Status{CameraOn: true})
+	waitFor(t, "the plug to turn back on", func() bool {
+		return kasa.Relay() == 1
+	})
+}
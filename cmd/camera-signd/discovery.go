@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	"carvers.dev/camera-sign/discovery"
+	"yall.in"
+)
+
+const defaultListen = ":9988"
+
+// DiscoveryConfig controls advertising camera-signd on the local network
+// over mDNS, so camctl can find it without being configured.
+type DiscoveryConfig struct {
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Instance is the name to advertise. It defaults to "camera-signd on"
+	// followed by the hostname, and can't contain dots.
+	Instance string `json:"instance,omitempty"`
+}
+
+// advertise answers mDNS queries for camera-signd, listening on port, until
+// ctx is cancelled. Failing to advertise isn't fatal; camctl can still be
+// pointed at the server directly.
+func advertise(ctx context.Context, config DiscoveryConfig, port int, tls bool) {
+	log := yall.FromContext(ctx)
+	svc, err := discovery.LocalService(port, "path=/v1", "tls="+strconv.FormatBool(tls))
+	if err != nil {
+		log.WithError(err).Warn("can't advertise over mDNS")
+		return
+	}
+	if config.Instance != "" {
+		svc.Instance = config.Instance
+	}
+	conn, err := discovery.ListenMulticast()
+	if err != nil {
+		log.WithError(err).Warn("can't advertise over mDNS")
+		return
+	}
+	log.WithField("instance", svc.Instance).WithField("host", svc.Host).WithField("port", port).Info("advertising over mDNS")
+	err = discovery.Advertise(ctx, conn, discovery.Group, svc)
+	if err != nil {
+		log.WithError(err).Warn("stopped advertising over mDNS")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import "time"
+
+// hysteresis holds the sign in its state for a while before letting it
+// change, so apps opening and closing the camera while joining a call
+// don't make the sign flicker.
+//
+// Changes to SignFree wait for holdOff, and every other change waits for
+// holdOn. A change only happens once the aggregator has wanted the new state
+// for the whole wait. Overrides, and changes away from them, happen
+// straight away, since someone asked for them.
+type hysteresis struct {
+	// shown is the decision the sign was last set to.
+	shown Decision
+	known bool
+
+	// wanted is the state the aggregator wants, if it's different from
+	// shown, and since is when it started wanting it.
+	wanted SignState
+	since  time.Time
+}
+
+// next returns the hysteresis after the aggregator decided on decision at
+// now, the decision to show, and how long until it should be asked again,
+// if a change is waiting.
+func (h hysteresis) next(now time.Time, decision Decision, holdOn, holdOff time.Duration) (hysteresis, Decision, time.Duration) {
+	show := func() (hysteresis, Decision, time.Duration) {
+		return hysteresis{shown: decision, known: true}, decision, 0
+	}
+	if !h.known || decision.State == h.shown.State {
+		return show()
+	}
+	if decision.Reason == "override" || h.shown.Reason == "override" {
+		return show()
+	}
+	hold := holdOn
+	if decision.State == SignFree {
+		hold = holdOff
+	}
+	if h.wanted != decision.State || h.since.IsZero() {
+		h.wanted, h.since = decision.State, now
+	}
+	wait := h.since.Add(hold).Sub(now)
+	if wait <= 0 {
+		return show()
+	}
+	return h, Decision{State: h.shown.State, Reason: "hold"}, wait
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHysteresis(t *testing.T) {
+	type step struct {
+		// at is seconds since the test's clock started
+		at       int
+		decision Decision
+		state    SignState
+		wait     time.Duration
+	}
+	video := Decision{State: SignBusyVideo, Reason: "device", Devices: []string{"laptop"}}
+	audio := Decision{State: SignBusyAudio, Reason: "device", Devices: []string{"laptop"}}
+	free := Decision{State: SignFree, Reason: "default"}
+	override := Decision{State: SignDoNotDisturb, Reason: "override"}
+
+	cases := map[string]struct {
+		holdOn, holdOff time.Duration
+		steps           []step
+	}{
+		"no-holds": {
+			steps: []step{
+				{at: 0, decision: video, state: SignBusyVideo},
+				{at: 1, decision: free, state: SignFree},
+				{at: 2, decision: video, state: SignBusyVideo},
+			},
+		},
+		"first-decision-is-shown": {
+			holdOn: 10 * time.Second,
+			steps: []step{
+				{at: 0, decision: video, state: SignBusyVideo},
+			},
+		},
+		"hold-off-ignores-flicker": {
+			holdOff: 30 * time.Second,
+			steps: []step{
+				{at: 0, decision: video, state: SignBusyVideo},
+				{at: 5, decision: free, state: SignBusyVideo, wait: 30 * time.Second},
+				{at: 10, decision: video, state: SignBusyVideo},
+				{at: 20, decision: free, state: SignBusyVideo, wait: 30 * time.Second},
+				{at: 35, decision: free, state: SignBusyVideo, wait: 15 * time.Second},
+				{at: 50, decision: free, state: SignFree},
+			},
+		},
+		"hold-on-turns-on-immediately-by-default": {
+			holdOff: 30 * time.Second,
+			steps: []step{
+				{at: 0, decision: free, state: SignFree},
+				{at: 1, decision: video, state: SignBusyVideo},
+			},
+		},
+		"hold-on": {
+			holdOn: 5 * time.Second,
+			steps: []step{
+				{at: 0, decision: free, state: SignFree},
+				{at: 1, decision: video, state: SignFree, wait: 5 * time.Second},
+				{at: 2, decision: free, state: SignFree},
+				{at: 3, decision: video, state: SignFree, wait: 5 * time.Second},
+				{at: 8, decision: video, state: SignBusyVideo},
+			},
+		},
+		"changing-wanted-state-restarts-hold": {
+			holdOn:  5 * time.Second,
+			holdOff: 5 * time.Second,
+			steps: []step{
+				{at: 0, decision: video, state: SignBusyVideo},
+				{at: 1, decision: free, state: SignBusyVideo, wait: 5 * time.Second},
+				{at: 4, decision: audio, state: SignBusyVideo, wait: 5 * time.Second},
+				{at: 9, decision: audio, state: SignBusyAudio},
+			},
+		},
+		"overrides-skip-holds": {
+			holdOn:  time.Minute,
+			holdOff: time.Minute,
+			steps: []step{
+				{at: 0, decision: video, state: SignBusyVideo},
+				{at: 1, decision: override, state: SignDoNotDisturb},
+				{at: 2, decision: free, state: SignFree},
+			},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			start := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+			var h hysteresis
+			for _, step := range c.steps {
+				now := start.Add(time.Duration(step.at) * time.Second)
+				var decision Decision
+				var wait time.Duration
+				h, decision, wait = h.next(now, step.decision, c.holdOn, c.holdOff)
+				if decision.State != step.state {
+					t.Errorf("at %ds: expected %+v, got %+v", step.at, step.state, decision.State)
+				}
+				if wait != step.wait {
+					t.Errorf("at %ds: expected wait %s, got %s", step.at, step.wait, wait)
+				}
+				if wait > 0 && decision.Reason != "hold" {
+					t.Errorf("at %ds: expected reason %q, got %q", step.at, "hold", decision.Reason)
+				}
+			}
+		})
+	}
+}
@@ -2,163 +2,231 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
-	"darlinggo.co/trout"
+	"carvers.dev/camera-sign/logging"
+	"yall.in"
 )
 
 type Server struct {
 	Statuses map[string]Status `json:"statuses"`
+	Override *Override         `json:"override,omitempty"`
 	statusMu sync.RWMutex
-	plug     Hs1xxPlug
+
+	// settings are the parts of the config that are reloaded when it
+	// changes.
+	settingsMu sync.RWMutex
+	settings   settings
+
+	notifiers []Notifier
+	metrics   *metrics
+	clock     clock
+
+	// wake tells runSign the sign's state might have changed.
+	wake chan struct{}
+
+	stateMu    sync.Mutex
+	state      SignState
+	stateKnown bool
+	decision   Decision
+	// hold delays changes to the sign. camera-signd drives one sign, so
+	// there's one hold, using that sign's holdOn and holdOff.
+	hold hysteresis
+	// signErr is why the sign's last update failed, if it did.
+	signErr error
 }
 
+const (
+	// defaultStaleAfter is how long a device's status counts toward the
+	// sign's state after it was last reported, unless the config says
+	// otherwise.
+	defaultStaleAfter = 15 * time.Minute
+
+	// defaultSyncEvery is how often the sign is set to its state, unless
+	// the config says otherwise.
+	defaultSyncEvery = time.Minute
+
+	// defaultDebounce is how long changes are collected before the sign is
+	// updated, unless the config says otherwise.
+	defaultDebounce = 200 * time.Millisecond
+)
+
 type Status struct {
+	// Name is a human-readable name for the device, like its hostname.
+	Name     string    `json:"name,omitempty"`
 	CameraOn bool      `json:"cameraOn"`
+	MicOn    bool      `json:"micOn"`
 	LastSync time.Time `json:"lastSync"`
 }
 
 func main() {
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCommand(os.Args[2:]))
+	}
 
-	if len(os.Args) < 2 {
+	var configPath string
+	flag.StringVar(&configPath, "config", "", "path to a JSON config file")
+	flag.Parse()
+
+	if configPath == "" && flag.NArg() == 0 {
 		fmt.Println("Usage: camera-signd {OUTLET IP}")
+		fmt.Println("       camera-signd -config {CONFIG FILE}")
+		fmt.Println("       camera-signd config validate {CONFIG FILE}")
 		os.Exit(1)
 	}
-
-	s := &Server{
-		Statuses: map[string]Status{},
-		plug:     Hs1xxPlug{IPAddress: os.Args[1]},
+	load := func() (Config, error) {
+		return loadServerConfig(configPath, flag.Arg(0))
 	}
-	go s.syncSignLoop(ctx)
-
-	var router trout.Router
-	router.Endpoint("/status").Methods(http.MethodGet).Handler(http.HandlerFunc(s.getStatusHandler))
-	router.Endpoint("/status/{mac}").Methods(http.MethodPatch).Handler(http.HandlerFunc(s.patchStatusHandler))
-	router.Endpoint("/status").Methods(http.MethodDelete).Handler(http.HandlerFunc(s.deleteStatusHandler))
-
-	http.Handle("/", router)
-	err := http.ListenAndServe(":9988", nil)
+	config, err := load()
 	if err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
 	}
-}
-
-func (s *Server) getStatusHandler(w http.ResponseWriter, r *http.Request) {
-	s.statusMu.RLock()
-	defer s.statusMu.RUnlock()
-	b, err := json.Marshal(s.Statuses)
+	logger, err := logging.New(os.Stdout, config.Log.Level, config.Log.Format)
 	if err != nil {
-		log.Println(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("server error"))
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(b)
-}
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	ctx = yall.InContext(ctx, logger)
 
-func (s *Server) patchStatusHandler(w http.ResponseWriter, r *http.Request) {
-	mac := trout.RequestVars(r).Get("mac")
-	b, err := ioutil.ReadAll(r.Body)
+	settings, err := config.settings()
 	if err != nil {
-		log.Println(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("internal server error"))
-		return
-	}
-	defer r.Body.Close()
-	var status Status
-	err = json.Unmarshal(b, &status)
+		logger.WithError(err).Error("invalid config")
+		os.Exit(1)
+	}
+	s := newServer(settings)
+	statePath, err := stateFilePath(config)
 	if err != nil {
-		log.Println(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("internal server error"))
-		return
+		logger.WithError(err).Warn("state won't be saved")
+	} else {
+		err = s.loadState(statePath)
+		if err != nil {
+			logger.WithError(err).WithField("path", statePath).Warn("error restoring state")
+		}
 	}
-	status.LastSync = time.Now()
-	change := true
-	s.statusMu.Lock()
-	before, ok := s.Statuses[mac]
-	if ok {
-		change = before.CameraOn != status.CameraOn
+	if config.MQTT != nil {
+		publisher := newMQTTPublisher(ctx, *config.MQTT, s.setOverride)
+		s.notifiers = append(s.notifiers, publisher)
+		go publisher.Run(ctx)
 	}
-	s.Statuses[mac] = status
-	s.statusMu.Unlock()
-	if change {
-		err = s.syncSign(r.Context())
+	var webhooks *webhookNotifier
+	if len(config.Webhooks) > 0 {
+		webhooks, err = newWebhookNotifier(ctx, config.Webhooks)
 		if err != nil {
-			log.Println(err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("internal server error"))
-			return
+			logger.WithError(err).Error("invalid webhooks config")
+			os.Exit(1)
 		}
+		s.notifiers = append(s.notifiers, webhooks)
+	}
+	signDone := make(chan struct{})
+	go func() {
+		s.runSign(ctx)
+		close(signDone)
+	}()
+	// restored state may need showing
+	s.requestSync()
+	if configPath != "" {
+		go s.watchConfig(ctx, configPath, config, load)
 	}
-	w.WriteHeader(http.StatusNoContent)
-}
-
-func (s *Server) deleteStatusHandler(w http.ResponseWriter, r *http.Request) {
-	s.statusMu.Lock()
-	defer s.statusMu.Unlock()
 
-	s.Statuses = map[string]Status{}
-	w.WriteHeader(http.StatusNoContent)
-}
+	server := &http.Server{
+		Addr:    config.Listen,
+		Handler: logRequests(logger, s.routes(webhooks)),
+	}
+	if config.TLS != nil {
+		server.TLSConfig, err = config.TLS.build(ctx)
+		if err != nil {
+			logger.WithError(err).Error("invalid TLS config")
+			os.Exit(1)
+		}
+	}
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		logger.WithField("addr", server.Addr).WithError(err).Error("error listening")
+		os.Exit(1)
+	}
+	logger.WithField("addr", listener.Addr().String()).WithField("tls", server.TLSConfig != nil).Info("listening")
+	if !config.Discovery.Disabled {
+		go advertise(ctx, config.Discovery, listener.Addr().(*net.TCPAddr).Port, server.TLSConfig != nil)
+	}
 
-func (s *Server) syncSignLoop(ctx context.Context) {
-	t := time.NewTicker(time.Minute)
-	defer t.Stop()
-	for {
-		select {
-		case <-t.C:
-			err := s.syncSign(ctx)
-			if err != nil {
-				log.Println("error syncing sign:", err.Error())
-				continue
-			}
-		case <-ctx.Done():
-			err := ctx.Err()
-			if err != nil {
-				log.Println("context finished:", err.Error())
-			}
-			return
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	serveErr := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			// the certificate is already loaded into TLSConfig
+			serveErr <- server.ServeTLS(listener, "", "")
+		} else {
+			serveErr <- server.Serve(listener)
 		}
+	}()
+	exitCode := 0
+	select {
+	case sig := <-stop:
+		logger.WithField("signal", sig.String()).Info("shutting down")
+	case err := <-serveErr:
+		logger.WithError(err).Error("error serving HTTP")
+		exitCode = 1
+	}
+	signal.Stop(stop)
+	// stop the background loops, then give requests and the sign a while
+	// to finish, with a context that isn't cancelled
+	cancel()
+	<-signDone
+	shutdownCtx, cancelShutdown := context.WithTimeout(yall.InContext(context.Background(), logger), shutdownTimeout)
+	defer cancelShutdown()
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		logger.WithError(err).Warn("error waiting for requests to finish")
+	}
+	s.shutdown(shutdownCtx, statePath)
+	if exitCode != 0 {
+		os.Exit(exitCode)
 	}
 }
 
-func (s *Server) syncSign(ctx context.Context) error {
-	s.statusMu.RLock()
-	defer s.statusMu.RUnlock()
-
-	desiredOn := false
-	for _, status := range s.Statuses {
-		if time.Since(status.LastSync) > time.Minute*15 {
-			continue
-		}
-		if status.CameraOn {
-			desiredOn = true
-			break
-		}
+func newServer(settings settings) *Server {
+	return &Server{
+		Statuses: map[string]Status{},
+		settings: settings,
+		metrics:  newMetrics(),
+		clock:    systemClock{},
+		wake:     make(chan struct{}, 1),
 	}
-	if desiredOn {
-		err := s.plug.TurnOn()
-		if err != nil {
-			return fmt.Errorf("error turning plug on: %w", err)
-		}
-		return nil
+}
+
+// setOverride replaces the sign's override, or clears it if override is nil,
+// and has the sign updated to match.
+func (s *Server) setOverride(ctx context.Context, override *Override) {
+	s.statusMu.Lock()
+	s.Override = override
+	s.statusMu.Unlock()
+	if override != nil {
+		yall.FromContext(ctx).WithField("state", override.State.String()).WithField("until", override.Until).Info("sign override set")
+	} else {
+		yall.FromContext(ctx).Info("sign override cleared")
 	}
-	err := s.plug.TurnOff()
-	if err != nil {
-		return fmt.Errorf("error turning plug off: %w", err)
+	for _, n := range s.notifiers {
+		n.OverrideChanged(ctx, override)
 	}
-	return nil
+	s.requestSync()
+}
+
+// current returns the settings from the latest config.
+func (s *Server) current() settings {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.settings
 }
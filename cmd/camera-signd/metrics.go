@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// signDurationBuckets are the upper bounds, in seconds, of the histogram
+// buckets for how long sign commands take.
+var signDurationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type httpRequestKey struct {
+	route  string
+	method string
+	code   int
+}
+
+// metrics collects the counters camera-signd exposes in the Prometheus text
+// format. Gauges that can be read from the Server's state are computed
+// when scraped instead.
+type metrics struct {
+	mu sync.Mutex
+
+	signCommands     map[SignState]uint64
+	signErrors       uint64
+	signDurations    []uint64
+	signDurationSum  float64
+	signDurationN    uint64
+	lastSignSuccess  time.Time
+	httpRequests     map[httpRequestKey]uint64
+	emeter           *EmeterReading
+	emeterReadAt     time.Time
+	emeterReadErrors uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		signCommands:  map[SignState]uint64{},
+		signDurations: make([]uint64, len(signDurationBuckets)),
+		httpRequests:  map[httpRequestKey]uint64{},
+	}
+}
+
+func (m *metrics) observeSign(state SignState, took time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.signCommands[state]++
+	if err != nil {
+		m.signErrors++
+	} else {
+		m.lastSignSuccess = time.Now()
+	}
+	seconds := took.Seconds()
+	for pos, bound := range signDurationBuckets {
+		if seconds <= bound {
+			m.signDurations[pos]++
+		}
+	}
+	m.signDurationSum += seconds
+	m.signDurationN++
+}
+
+func (m *metrics) observeEmeter(reading *EmeterReading, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.emeterReadErrors++
+		return
+	}
+	m.emeter = reading
+	m.emeterReadAt = time.Now()
+}
+
+// instrument counts requests to h by route, method, and response code.
+func (m *metrics) instrument(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(rec, r)
+		m.mu.Lock()
+		m.httpRequests[httpRequestKey{route: route, method: r.Method, code: rec.code}]++
+		m.mu.Unlock()
+	})
+}
+
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *metricsWriter) header(name, kind, help string) {
+	fmt.Fprintf(&w.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a single sample. labels alternate between names and
+// values.
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.buf.WriteString(name)
+	if len(labels) > 0 {
+		w.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&w.buf, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		w.buf.WriteByte('}')
+	}
+	w.buf.WriteByte(' ')
+	w.buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.buf.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var out metricsWriter
+	now := s.clock.Now()
+
+	s.statusMu.RLock()
+	ids := make([]string, 0, len(s.Statuses))
+	for id := range s.Statuses {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	statuses := make([]Status, 0, len(ids))
+	for _, id := range ids {
+		statuses = append(statuses, s.Statuses[id])
+	}
+	s.statusMu.RUnlock()
+
+	out.header("camera_sign_device_camera_on", "gauge", "Whether the device last reported its camera on.")
+	for pos, id := range ids {
+		out.sample("camera_sign_device_camera_on", boolValue(statuses[pos].CameraOn), "device", id)
+	}
+	out.header("camera_sign_device_mic_on", "gauge", "Whether the device last reported its microphone on.")
+	for pos, id := range ids {
+		out.sample("camera_sign_device_mic_on", boolValue(statuses[pos].MicOn), "device", id)
+	}
+	out.header("camera_sign_device_last_sync_age_seconds", "gauge", "Seconds since the device last reported its status.")
+	for pos, id := range ids {
+		out.sample("camera_sign_device_last_sync_age_seconds", now.Sub(statuses[pos].LastSync).Seconds(), "device", id)
+	}
+
+	s.stateMu.Lock()
+	state, known := s.state, s.stateKnown
+	s.stateMu.Unlock()
+	out.header("camera_sign_state", "gauge", "The state the sign is showing, as 1 for the current state and 0 for the others.")
+	for st := SignFree; st <= SignAway; st++ {
+		out.sample("camera_sign_state", boolValue(known && st == state), "state", st.String())
+	}
+
+	m := s.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out.header("camera_sign_sign_commands_total", "counter", "Commands sent to the sign, by the state requested.")
+	for st := SignFree; st <= SignAway; st++ {
+		out.sample("camera_sign_sign_commands_total", float64(m.signCommands[st]), "state", st.String())
+	}
+	out.header("camera_sign_sign_errors_total", "counter", "Commands sent to the sign that failed.")
+	out.sample("camera_sign_sign_errors_total", float64(m.signErrors))
+	out.header("camera_sign_sign_command_duration_seconds", "histogram", "How long commands sent to the sign took.")
+	for pos, bound := range signDurationBuckets {
+		out.sample("camera_sign_sign_command_duration_seconds_bucket", float64(m.signDurations[pos]), "le", strconv.FormatFloat(bound, 'g', -1, 64))
+	}
+	out.sample("camera_sign_sign_command_duration_seconds_bucket", float64(m.signDurationN), "le", "+Inf")
+	out.sample("camera_sign_sign_command_duration_seconds_sum", m.signDurationSum)
+	out.sample("camera_sign_sign_command_duration_seconds_count", float64(m.signDurationN))
+	if !m.lastSignSuccess.IsZero() {
+		out.header("camera_sign_sign_last_success_timestamp_seconds", "gauge", "When a command last reached the sign successfully.")
+		out.sample("camera_sign_sign_last_success_timestamp_seconds", float64(m.lastSignSuccess.UnixNano())/1e9)
+	}
+
+	keys := make([]httpRequestKey, 0, len(m.httpRequests))
+	for k := range m.httpRequests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].code < keys[j].code
+	})
+	out.header("camera_sign_http_requests_total", "counter", "HTTP requests handled, by route, method, and status code.")
+	for _, k := range keys {
+		out.sample("camera_sign_http_requests_total", float64(m.httpRequests[k]), "route", k.route, "method", k.method, "code", strconv.Itoa(k.code))
+	}
+
+	out.header("camera_sign_emeter_read_errors_total", "counter", "Failed attempts to read the plug's energy meter.")
+	out.sample("camera_sign_emeter_read_errors_total", float64(m.emeterReadErrors))
+	if m.emeter != nil {
+		out.header("camera_sign_emeter_power_watts", "gauge", "Power drawn through the plug.")
+		out.sample("camera_sign_emeter_power_watts", m.emeter.Power)
+		out.header("camera_sign_emeter_voltage_volts", "gauge", "Voltage at the plug.")
+		out.sample("camera_sign_emeter_voltage_volts", m.emeter.Voltage)
+		out.header("camera_sign_emeter_current_amperes", "gauge", "Current drawn through the plug.")
+		out.sample("camera_sign_emeter_current_amperes", m.emeter.Current)
+		out.header("camera_sign_emeter_energy_kwh_total", "counter", "Energy used through the plug since its meter was reset.")
+		out.sample("camera_sign_emeter_energy_kwh_total", m.emeter.Total)
+		out.header("camera_sign_emeter_read_timestamp_seconds", "gauge", "When the energy meter was last read.")
+		out.sample("camera_sign_emeter_read_timestamp_seconds", float64(m.emeterReadAt.UnixNano())/1e9)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(out.buf.Bytes())
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	now := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	s := newServer(settings{})
+	s.clock = &fakeClock{now: now}
+	s.Statuses = map[string]Status{
+		"laptop":  {CameraOn: true, MicOn: true, LastSync: now.Add(-30 * time.Second)},
+		"desktop": {LastSync: now.Add(-15 * time.Minute)},
+	}
+	s.state, s.stateKnown = SignBusyVideo, true
+
+	m := s.metrics
+	m.observeSign(SignBusyVideo, 250*time.Millisecond, nil)
+	m.observeSign(SignBusyVideo, 2*time.Second, errors.New("plug unreachable"))
+	m.observeSign(SignFree, 250*time.Millisecond, nil)
+	m.lastSignSuccess = now
+	m.httpRequests[httpRequestKey{route: "/v1/status/{id}", method: http.MethodPatch, code: http.StatusOK}] = 3
+	m.httpRequests[httpRequestKey{route: "/v1/status/{id}", method: http.MethodPatch, code: http.StatusBadRequest}] = 1
+	m.observeEmeter(nil, errors.New("no emeter"))
+	m.observeEmeter(&EmeterReading{Power: 1.5, Voltage: 230, Current: 0.5, Total: 12.25}, nil)
+	m.emeterReadAt = now
+
+	w := httptest.NewRecorder()
+	s.metricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("expected the Prometheus text format, got %q", ct)
+	}
+	expected := `# HELP camera_sign_device_camera_on Whether the device last reported its camera on.
+# TYPE camera_sign_device_camera_on gauge
+camera_sign_device_camera_on{device="desktop"} 0
+camera_sign_device_camera_on{device="laptop"} 1
+# HELP camera_sign_device_mic_on Whether the device last reported its microphone on.
+# TYPE camera_sign_device_mic_on gauge
+camera_sign_device_mic_on{device="desktop"} 0
+camera_sign_device_mic_on{device="laptop"} 1
+# HELP camera_sign_device_last_sync_age_seconds Seconds since the device last reported its status.
+# TYPE camera_sign_device_last_sync_age_seconds gauge
+camera_sign_device_last_sync_age_seconds{device="desktop"} 900
+camera_sign_device_last_sync_age_seconds{device="laptop"} 30
+# HELP camera_sign_state The state the sign is showing, as 1 for the current state and 0 for the others.
+# TYPE camera_sign_state gauge
+camera_sign_state{state="free"} 0
+camera_sign_state{state="busy-audio"} 0
+camera_sign_state{state="busy-video"} 1
+camera_sign_state{state="dnd"} 0
+camera_sign_state{state="away"} 0
+# HELP camera_sign_sign_commands_total Commands sent to the sign, by the state requested.
+# TYPE camera_sign_sign_commands_total counter
+camera_sign_sign_commands_total{state="free"} 1
+camera_sign_sign_commands_total{state="busy-audio"} 0
+camera_sign_sign_commands_total{state="busy-video"} 2
+camera_sign_sign_commands_total{state="dnd"} 0
+camera_sign_sign_commands_total{state="away"} 0
+# HELP camera_sign_sign_errors_total Commands sent to the sign that failed.
+# TYPE camera_sign_sign_errors_total counter
+camera_sign_sign_errors_total 1
+# HELP camera_sign_sign_command_duration_seconds How long commands sent to the sign took.
+# TYPE camera_sign_sign_command_duration_seconds histogram
+camera_sign_sign_command_duration_seconds_bucket{le="0.01"} 0
+camera_sign_sign_command_duration_seconds_bucket{le="0.05"} 0
+camera_sign_sign_command_duration_seconds_bucket{le="0.1"} 0
+camera_sign_sign_command_duration_seconds_bucket{le="0.25"} 2
+camera_sign_sign_command_duration_seconds_bucket{le="0.5"} 2
+camera_sign_sign_command_duration_seconds_bucket{le="1"} 2
+camera_sign_sign_command_duration_seconds_bucket{le="2.5"} 3
+camera_sign_sign_command_duration_seconds_bucket{le="5"} 3
+camera_sign_sign_command_duration_seconds_bucket{le="10"} 3
+camera_sign_sign_command_duration_seconds_bucket{le="+Inf"} 3
+camera_sign_sign_command_duration_seconds_sum 2.5
+camera_sign_sign_command_duration_seconds_count 3
+# HELP camera_sign_sign_last_success_timestamp_seconds When a command last reached the sign successfully.
+# TYPE camera_sign_sign_last_success_timestamp_seconds gauge
+camera_sign_sign_last_success_timestamp_seconds 1.7924004e+09
+# HELP camera_sign_http_requests_total HTTP requests handled, by route, method, and status code.
+# TYPE camera_sign_http_requests_total counter
+camera_sign_http_requests_total{route="/v1/status/{id}",method="PATCH",code="200"} 3
+camera_sign_http_requests_total{route="/v1/status/{id}",method="PATCH",code="400"} 1
+# HELP camera_sign_emeter_read_errors_total Failed attempts to read the plug's energy meter.
+# TYPE camera_sign_emeter_read_errors_total counter
+camera_sign_emeter_read_errors_total 1
+# HELP camera_sign_emeter_power_watts Power drawn through the plug.
+# TYPE camera_sign_emeter_power_watts gauge
+camera_sign_emeter_power_watts 1.5
+# HELP camera_sign_emeter_voltage_volts Voltage at the plug.
+# TYPE camera_sign_emeter_voltage_volts gauge
+camera_sign_emeter_voltage_volts 230
+# HELP camera_sign_emeter_current_amperes Current drawn through the plug.
+# TYPE camera_sign_emeter_current_amperes gauge
+camera_sign_emeter_current_amperes 0.5
+# HELP camera_sign_emeter_energy_kwh_total Energy used through the plug since its meter was reset.
+# TYPE camera_sign_emeter_energy_kwh_total counter
+camera_sign_emeter_energy_kwh_total 12.25
+# HELP camera_sign_emeter_read_timestamp_seconds When the energy meter was last read.
+# TYPE camera_sign_emeter_read_timestamp_seconds gauge
+camera_sign_emeter_read_timestamp_seconds 1.7924004e+09
+`
+	if got := w.Body.String(); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
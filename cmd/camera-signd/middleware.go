@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"yall.in"
+)
+
+// statusRecorder remembers the status code written to a ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// logRequests puts a logger describing the request into its context, and
+// logs every request once it's been handled.
+func logRequests(logger *yall.Logger, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		reqLogger := logger.WithField("method", r.Method).WithField("path", r.URL.Path).WithField("remote", r.RemoteAddr)
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(rec, r.WithContext(yall.InContext(r.Context(), reqLogger)))
+		reqLogger.WithField("status", rec.code).WithField("duration", time.Since(start).String()).Info("request handled")
+	})
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"yall.in"
+)
+
+const (
+	mqttOnline  = "online"
+	mqttOffline = "offline"
+
+	// mqttOverrideAuto is the override command that clears the override.
+	mqttOverrideAuto = "auto"
+)
+
+// MQTTConfig configures publishing to an MQTT broker, with Home Assistant
+// discovery.
+type MQTTConfig struct {
+	// Broker is the broker's URL, like tcp://localhost:1883 or
+	// tls://broker:8883.
+	Broker   string `json:"broker"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// ClientID defaults to "camera-signd".
+	ClientID string `json:"clientId,omitempty"`
+
+	// TopicPrefix is prepended to every topic camera-signd publishes
+	// to. It defaults to "camera-sign".
+	TopicPrefix string `json:"topicPrefix,omitempty"`
+
+	// DiscoveryPrefix is the Home Assistant discovery prefix. It
+	// defaults to "homeassistant".
+	DiscoveryPrefix string `json:"discoveryPrefix,omitempty"`
+}
+
+// validate checks the broker's URL, without connecting to it.
+func (c MQTTConfig) validate() error {
+	if c.Broker == "" {
+		return errors.New("broker must be set")
+	}
+	u, err := url.Parse(c.Broker)
+	if err != nil {
+		return fmt.Errorf("invalid broker URL: %w", err)
+	}
+	switch u.Scheme {
+	case "tcp", "mqtt", "tls", "ssl", "mqtts":
+	default:
+		return fmt.Errorf("unsupported broker scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("broker URL %q has no host", c.Broker)
+	}
+	return nil
+}
+
+// mqttPublisher publishes device statuses and the sign's state to MQTT,
+// along with the Home Assistant discovery config to find them, and turns
+// messages on the override command topic into overrides.
+//
+// Everything is published retained, so the latest value of every topic is
+// kept in retained and republished whenever we reconnect.
+type mqttPublisher struct {
+	config      MQTTConfig
+	setOverride func(context.Context, *Override)
+
+	mu       sync.Mutex
+	retained map[string][]byte
+	dirty    map[string]bool
+	devices  map[string]bool
+	wake     chan struct{}
+}
+
+func newMQTTPublisher(ctx context.Context, config MQTTConfig, setOverride func(context.Context, *Override)) *mqttPublisher {
+	if config.ClientID == "" {
+		config.ClientID = "camera-signd"
+	}
+	if config.TopicPrefix == "" {
+		config.TopicPrefix = "camera-sign"
+	}
+	if config.DiscoveryPrefix == "" {
+		config.DiscoveryPrefix = "homeassistant"
+	}
+	p := &mqttPublisher{
+		config:      config,
+		setOverride: setOverride,
+		retained:    map[string][]byte{},
+		dirty:       map[string]bool{},
+		devices:     map[string]bool{},
+		wake:        make(chan struct{}, 1),
+	}
+	p.discoverSign(ctx)
+	return p
+}
+
+func (p *mqttPublisher) topic(parts ...string) string {
+	return p.config.TopicPrefix + "/" + strings.Join(parts, "/")
+}
+
+func (p *mqttPublisher) discoveryTopic(component, objectID string) string {
+	return p.config.DiscoveryPrefix + "/" + component + "/" + p.config.ClientID + "/" + objectID + "/config"
+}
+
+// objectID turns a device ID, usually a MAC address, into something Home
+// Assistant accepts as an object ID.
+func objectID(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, id)
+}
+
+func (p *mqttPublisher) haDevice() map[string]interface{} {
+	return map[string]interface{}{
+		"identifiers":  []string{p.config.ClientID},
+		"name":         "Camera Sign",
+		"manufacturer": "camera-sign",
+	}
+}
+
+func (p *mqttPublisher) discoverSign(ctx context.Context) {
+	options := []string{mqttOverrideAuto}
+	for state := SignFree; state <= SignAway; state++ {
+		options = append(options, state.String())
+	}
+	p.setJSON(ctx, p.discoveryTopic("sensor", "sign_state"), map[string]interface{}{
+		"name":               "Sign state",
+		"unique_id":          p.config.ClientID + "_sign_state",
+		"state_topic":        p.topic("sign", "state"),
+		"availability_topic": p.topic("status"),
+		"icon":               "mdi:sign-real-estate",
+		"device":             p.haDevice(),
+	})
+	p.setJSON(ctx, p.discoveryTopic("select", "override"), map[string]interface{}{
+		"name":               "Sign override",
+		"unique_id":          p.config.ClientID + "_override",
+		"state_topic":        p.topic("override", "state"),
+		"command_topic":      p.topic("override", "set"),
+		"availability_topic": p.topic("status"),
+		"options":            options,
+		"device":             p.haDevice(),
+	})
+	p.set(p.topic("override", "state"), []byte(mqttOverrideAuto))
+}
+
+func (p *mqttPublisher) discoverDevice(ctx context.Context, id string) {
+	oid := objectID(id)
+	for _, kind := range []string{"camera", "mic"} {
+		p.setJSON(ctx, p.discoveryTopic("binary_sensor", oid+"_"+kind), map[string]interface{}{
+			"name":               id + " " + kind,
+			"unique_id":          p.config.ClientID + "_" + oid + "_" + kind,
+			"state_topic":        p.topic("devices", oid, kind),
+			"availability_topic": p.topic("status"),
+			"device_class":       "running",
+			"device":             p.haDevice(),
+		})
+	}
+}
+
+// set marks payload as the latest value for topic, to be published by Run.
+// Callers must hold p.mu.
+func (p *mqttPublisher) set(topic string, payload []byte) {
+	p.retained[topic] = payload
+	p.dirty[topic] = true
+}
+
+func (p *mqttPublisher) setJSON(ctx context.Context, topic string, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		yall.FromContext(ctx).WithField("topic", topic).WithError(err).Error("error encoding MQTT payload")
+		return
+	}
+	p.set(topic, b)
+}
+
+func (p *mqttPublisher) poke() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func onOff(on bool) []byte {
+	if on {
+		return []byte("ON")
+	}
+	return []byte("OFF")
+}
+
+func (p *mqttPublisher) DeviceChanged(ctx context.Context, id string, status Status) {
+	p.mu.Lock()
+	if !p.devices[id] {
+		p.devices[id] = true
+		p.discoverDevice(ctx, id)
+	}
+	oid := objectID(id)
+	p.set(p.topic("devices", oid, "camera"), onOff(status.CameraOn))
+	p.set(p.topic("devices", oid, "mic"), onOff(status.MicOn))
+	p.mu.Unlock()
+	p.poke()
+}
+
+// DeviceRemoved clears the device's retained topics, and removes it from
+// Home Assistant, which deletes entities whose discovery config is empty.
+// If the device reports again, it's discovered again.
+func (p *mqttPublisher) DeviceRemoved(ctx context.Context, id string) {
+	p.mu.Lock()
+	delete(p.devices, id)
+	oid := objectID(id)
+	for _, kind := range []string{"camera", "mic"} {
+		// an empty retained message deletes the retained one
+		p.set(p.topic("devices", oid, kind), nil)
+		p.set(p.discoveryTopic("binary_sensor", oid+"_"+kind), nil)
+	}
+	p.mu.Unlock()
+	p.poke()
+}
+
+func (p *mqttPublisher) SignChanged(ctx context.Context, state SignState) {
+	p.mu.Lock()
+	p.set(p.topic("sign", "state"), []byte(state.String()))
+	p.mu.Unlock()
+	p.poke()
+}
+
+func (p *mqttPublisher) OverrideChanged(ctx context.Context, override *Override) {
+	state := mqttOverrideAuto
+	if override != nil {
+		state = override.State.String()
+	}
+	p.mu.Lock()
+	p.set(p.topic("override", "state"), []byte(state))
+	p.mu.Unlock()
+	p.poke()
+}
+
+// handleCommand turns a message on the override command topic into an
+// override. The payload is either a state name, "auto" to clear the
+// override, or a JSON Override.
+func (p *mqttPublisher) handleCommand(ctx context.Context, topic string, payload []byte) {
+	if topic != p.topic("override", "set") {
+		return
+	}
+	command := strings.TrimSpace(string(payload))
+	var override *Override
+	switch {
+	case command == mqttOverrideAuto || command == "":
+	case strings.HasPrefix(command, "{"):
+		override = &Override{}
+		err := json.Unmarshal(payload, override)
+		if err != nil {
+			yall.FromContext(ctx).WithField("command", command).WithError(err).Warn("invalid MQTT override command")
+			return
+		}
+	default:
+		state, err := parseSignState(command)
+		if err != nil {
+			yall.FromContext(ctx).WithField("command", command).WithError(err).Warn("invalid MQTT override command")
+			return
+		}
+		override = &Override{State: state}
+	}
+	p.setOverride(ctx, override)
+}
+
+// Run keeps a connection to the broker open, publishing changes as they
+// happen, until ctx is cancelled.
+func (p *mqttPublisher) Run(ctx context.Context) {
+	backoff := time.Second
+	for {
+		err := p.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		yall.FromContext(ctx).WithField("broker", p.config.Broker).WithField("retryIn", backoff.String()).WithError(err).Warn("MQTT connection lost")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// session runs a single connection to the broker, returning when it fails.
+func (p *mqttPublisher) session(ctx context.Context) error {
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	client, err := dialMQTT(dialCtx, mqttOptions{
+		Broker:      p.config.Broker,
+		ClientID:    p.config.ClientID,
+		Username:    p.config.Username,
+		Password:    p.config.Password,
+		WillTopic:   p.topic("status"),
+		WillPayload: []byte(mqttOffline),
+	})
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	err = client.Subscribe(p.topic("override", "set"))
+	if err != nil {
+		return err
+	}
+	err = client.Publish(p.topic("status"), []byte(mqttOnline), true)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	for topic := range p.retained {
+		p.dirty[topic] = true
+	}
+	p.mu.Unlock()
+
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- client.Run(ctx, func(topic string, payload []byte) {
+			p.handleCommand(ctx, topic, payload)
+		})
+	}()
+	for {
+		err = p.flush(client)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-p.wake:
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// flush publishes every topic that's changed since it was last published.
+// It doesn't hold p.mu while it writes to the broker, so a slow broker
+// never holds up the changes being published. If it fails, the session
+// ends, and every topic is published again when it reconnects.
+func (p *mqttPublisher) flush(client *mqttClient) error {
+	p.mu.Lock()
+	pending := make(map[string][]byte, len(p.dirty))
+	for topic := range p.dirty {
+		pending[topic] = p.retained[topic]
+		delete(p.dirty, topic)
+	}
+	p.mu.Unlock()
+	for topic, payload := range pending {
+		err := client.Publish(topic, payload, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
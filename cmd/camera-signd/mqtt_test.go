@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBroker is an MQTT broker, speaking just enough MQTT 3.1.1 on a
+// loopback port to keep one client at a time happy, once it's started.
+type fakeBroker struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	conn     net.Conn
+	connects []mqttConnectPacket
+	will     *mqttConnectPacket
+	retained map[string]string
+	subs     []string
+}
+
+// mqttConnectPacket is what a client said when it connected.
+type mqttConnectPacket struct {
+	ClientID    string
+	Username    string
+	Password    string
+	WillTopic   string
+	WillPayload string
+}
+
+func (b *fakeBroker) start(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %s", err)
+	}
+	b.listener, b.retained = listener, map[string]string{}
+	go b.serve()
+}
+
+func (b *fakeBroker) URL() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func (b *fakeBroker) Close() {
+	b.listener.Close()
+	b.Drop()
+}
+
+// Drop closes the client's connection, like a broker restarting.
+func (b *fakeBroker) Drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn != nil {
+		b.conn.Close()
+	}
+}
+
+func (b *fakeBroker) Retained(topic string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	payload, ok := b.retained[topic]
+	return payload, ok
+}
+
+func (b *fakeBroker) Connects() []mqttConnectPacket {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]mqttConnectPacket(nil), b.connects...)
+}
+
+func (b *fakeBroker) Subscribed(topic string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, sub := range b.subs {
+		if sub == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// Send publishes payload to the connected client on topic.
+func (b *fakeBroker) Send(topic, payload string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		return errors.New("no client connected")
+	}
+	body := appendMQTTString(nil, []byte(topic))
+	body = append(body, payload...)
+	return writeTestPacket(b.conn, mqttPublish<<4, body)
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conn, b.subs = conn, nil
+		b.mu.Unlock()
+		b.handle(conn)
+	}
+}
+
+// handle answers a client's packets until it disconnects. If it goes
+// without saying goodbye, its will is published.
+func (b *fakeBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		header, body, err := readTestPacket(r)
+		if err != nil {
+			b.mu.Lock()
+			if b.will != nil {
+				b.retained[b.will.WillTopic] = b.will.WillPayload
+			}
+			b.mu.Unlock()
+			return
+		}
+		b.mu.Lock()
+		switch header >> 4 {
+		case mqttConnect:
+			connect := parseTestConnect(body)
+			b.connects = append(b.connects, connect)
+			b.will = nil
+			if connect.WillTopic != "" {
+				b.will = &connect
+			}
+			writeTestPacket(conn, mqttConnack<<4, []byte{0, 0})
+		case mqttPublish:
+			topicLen := int(binary.BigEndian.Uint16(body))
+			topic, payload := string(body[2:2+topicLen]), string(body[2+topicLen:])
+			switch {
+			case header&0x01 == 0:
+			case payload == "":
+				// an empty retained message deletes the
+				// retained one
+				delete(b.retained, topic)
+			default:
+				b.retained[topic] = payload
+			}
+		case mqttSubscribe:
+			resp := []byte{body[0], body[1]}
+			for rest := body[2:]; len(rest) > 2; {
+				n := int(binary.BigEndian.Uint16(rest))
+				b.subs = append(b.subs, string(rest[2:2+n]))
+				rest = rest[2+n+1:]
+				resp = append(resp, 0)
+			}
+			writeTestPacket(conn, mqttSuback<<4, resp)
+		case mqttPingreq:
+			writeTestPacket(conn, mqttPingresp<<4, nil)
+		case mqttDisconnect:
+			b.will = nil
+			b.mu.Unlock()
+			return
+		}
+		b.mu.Unlock()
+	}
+}
+
+func parseTestConnect(body []byte) mqttConnectPacket {
+	var connect mqttConnectPacket
+	next := func() string {
+		n := int(binary.BigEndian.Uint16(body))
+		s := string(body[2 : 2+n])
+		body = body[2+n:]
+		return s
+	}
+	next() // the protocol name
+	flags := body[1]
+	body = body[4:] // the level, flags, and keep alive
+	connect.ClientID = next()
+	if flags&0x04 != 0 {
+		connect.WillTopic, connect.WillPayload = next(), next()
+	}
+	if flags&0x80 != 0 {
+		connect.Username = next()
+	}
+	if flags&0x40 != 0 {
+		connect.Password = next()
+	}
+	return connect
+}
+
+func readTestPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var n, multiplier int = 0, 1
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return header, body, err
+}
+
+// writeTestPacket writes a packet with a body shorter than 128 bytes.
+func writeTestPacket(conn net.Conn, header byte, body []byte) error {
+	_, err := conn.Write(append([]byte{header, byte(len(body))}, body...))
+	return err
+}
+
+// overrideRecorder records the overrides an mqttPublisher sets.
+type overrideRecorder struct {
+	mu        sync.Mutex
+	overrides []*Override
+}
+
+func (o *overrideRecorder) set(ctx context.Context, override *Override) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.overrides = append(o.overrides, override)
+}
+
+func (o *overrideRecorder) Overrides() []*Override {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]*Override(nil), o.overrides...)
+}
+
+// waitFor waits up to a couple of seconds for cond to be true.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitForRetained waits for the broker to have payload retained on topic.
+func waitForRetained(t *testing.T, broker *fakeBroker, topic, payload string) {
+	t.Helper()
+	waitFor(t, topic+" to be "+payload, func() bool {
+		got, _ := broker.Retained(topic)
+		return got == payload
+	})
+}
+
+func TestMQTTClient(t *testing.T) {
+	broker := &fakeBroker{}
+	broker.start(t)
+	defer broker.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, err := dialMQTT(ctx, mqttOptions{
+		Broker:      broker.URL(),
+		ClientID:    "test",
+		Username:    "user",
+		Password:    "secret",
+		WillTopic:   "test/status",
+		WillPayload: []byte("offline"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error connecting: %s", err)
+	}
+	defer client.Close()
+	expected := []mqttConnectPacket{{ClientID: "test", Username: "user", Password: "secret", WillTopic: "test/status", WillPayload: "offline"}}
+	if connects := broker.Connects(); !reflect.DeepEqual(connects, expected) {
+		t.Errorf("expected %+v, got %+v", expected, connects)
+	}
+
+	err = client.Publish("test/status", []byte("online"), true)
+	if err != nil {
+		t.Fatalf("unexpected error publishing: %s", err)
+	}
+	waitForRetained(t, broker, "test/status", "online")
+
+	messages := make(chan string, 1)
+	go client.Run(ctx, func(topic string, payload []byte) {
+		messages <- topic + " " + string(payload)
+	})
+	err = client.Subscribe("test/set")
+	if err != nil {
+		t.Fatalf("unexpected error subscribing: %s", err)
+	}
+	waitFor(t, "the subscription", func() bool {
+		return broker.Subscribed("test/set")
+	})
+	err = broker.Send("test/set", "hello")
+	if err != nil {
+		t.Fatalf("unexpected error sending: %s", err)
+	}
+	if message := <-messages; message != "test/set hello" {
+		t.Errorf("expected %q, got %q", "test/set hello", message)
+	}
+}
+
+func TestMQTTPublisher(t *testing.T) {
+	broker := &fakeBroker{}
+	broker.start(t)
+	defer broker.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	recorder := &overrideRecorder{}
+	p := newMQTTPublisher(ctx, MQTTConfig{Broker: broker.URL()}, recorder.set)
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	waitForRetained(t, broker, "camera-sign/status", mqttOnline)
+	connect := broker.Connects()[0]
+	if connect.ClientID != "camera-signd" || connect.WillTopic != "camera-sign/status" || connect.WillPayload != mqttOffline {
+		t.Errorf("expected a camera-signd connection with an offline will, got %+v", connect)
+	}
+	if _, ok := broker.Retained("homeassistant/select/camera-signd/override/config"); !ok {
+		t.Error("expected the override to be discoverable")
+	}
+
+	p.DeviceChanged(ctx, "aa:bb:cc", Status{CameraOn: true})
+	p.SignChanged(ctx, SignBusyVideo)
+	waitForRetained(t, broker, "camera-sign/devices/aa_bb_cc/camera", "ON")
+	waitForRetained(t, broker, "camera-sign/devices/aa_bb_cc/mic", "OFF")
+	waitForRetained(t, broker, "camera-sign/sign/state", "busy-video")
+	if _, ok := broker.Retained("homeassistant/binary_sensor/camera-signd/aa_bb_cc_camera/config"); !ok {
+		t.Error("expected the device to be discoverable")
+	}
+
+	waitFor(t, "the override subscription", func() bool {
+		return broker.Subscribed("camera-sign/override/set")
+	})
+	commands := []string{"dnd", "purple", `{"state":"away"}`, "auto"}
+	for _, command := range commands {
+		err := broker.Send("camera-sign/override/set", command)
+		if err != nil {
+			t.Fatalf("unexpected error sending %q: %s", command, err)
+		}
+	}
+	waitFor(t, "the overrides", func() bool {
+		return len(recorder.Overrides()) == 3
+	})
+	// purple isn't a state, so it's ignored
+	expected := []*Override{{State: SignDoNotDisturb}, {State: SignAway}, nil}
+	if overrides := recorder.Overrides(); !reflect.DeepEqual(overrides, expected) {
+		t.Errorf("expected %+v, got %+v", expected, overrides)
+	}
+
+	// everything's published again after reconnecting
+	p.DeviceChanged(ctx, "dd:ee", Status{MicOn: true})
+	p.DeviceRemoved(ctx, "dd:ee")
+	broker.Drop()
+	waitForRetained(t, broker, "camera-sign/status", mqttOffline)
+	broker.mu.Lock()
+	broker.retained = map[string]string{}
+	broker.mu.Unlock()
+	waitForRetained(t, broker, "camera-sign/status", mqttOnline)
+	waitForRetained(t, broker, "camera-sign/devices/aa_bb_cc/camera", "ON")
+	waitForRetained(t, broker, "camera-sign/sign/state", "busy-video")
+	// removed devices stay removed
+	for _, topic := range []string{"camera-sign/devices/dd_ee/mic", "homeassistant/binary_sensor/camera-signd/dd_ee_mic/config"} {
+		if payload, ok := broker.Retained(topic); ok {
+			t.Errorf("expected %s to be removed, got %q", topic, payload)
+		}
+	}
+
+	cancel()
+	<-done
+}
+
+func TestMQTTPublisherDeviceRemoved(t *testing.T) {
+	broker := &fakeBroker{}
+	broker.start(t)
+	defer broker.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := newMQTTPublisher(ctx, MQTTConfig{Broker: broker.URL()}, (&overrideRecorder{}).set)
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	p.DeviceChanged(ctx, "laptop", Status{CameraOn: true})
+	waitForRetained(t, broker, "camera-sign/devices/laptop/camera", "ON")
+	topics := []string{
+		"camera-sign/devices/laptop/camera",
+		"camera-sign/devices/laptop/mic",
+		"homeassistant/binary_sensor/camera-signd/laptop_camera/config",
+		"homeassistant/binary_sensor/camera-signd/laptop_mic/config",
+	}
+
+	p.DeviceRemoved(ctx, "laptop")
+	waitFor(t, "the device's topics to be removed", func() bool {
+		for _, topic := range topics {
+			if _, ok := broker.Retained(topic); ok {
+				return false
+			}
+		}
+		return true
+	})
+
+	// reporting again makes it discoverable again
+	p.DeviceChanged(ctx, "laptop", Status{})
+	waitForRetained(t, broker, "camera-sign/devices/laptop/camera", "OFF")
+	if _, ok := broker.Retained(topics[2]); !ok {
+		t.Error("expected the device to be discoverable again")
+	}
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types.
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttSubscribe  = 8
+	mqttSuback     = 9
+	mqttPingreq    = 12
+	mqttPingresp   = 13
+	mqttDisconnect = 14
+)
+
+// mqttMaxRemaining is the largest body the remaining length field can
+// describe.
+const mqttMaxRemaining = 268435455
+
+// mqttWriteTimeout is how long a write to the broker can take before the
+// broker is given up on.
+const mqttWriteTimeout = 10 * time.Second
+
+// mqttOptions configures a connection to an MQTT broker.
+type mqttOptions struct {
+	// Broker is the broker's URL, like tcp://localhost:1883 or
+	// tls://broker:8883.
+	Broker    string
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+
+	// WillTopic and WillPayload are published, retained, by the broker
+	// if we disconnect without saying goodbye.
+	WillTopic   string
+	WillPayload []byte
+}
+
+// mqttClient is a minimal MQTT 3.1.1 client. It only publishes and
+// subscribes at QoS 0, which is all camera-signd needs.
+type mqttClient struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	keepAlive time.Duration
+
+	writeMu  sync.Mutex
+	packetID uint16
+}
+
+func dialMQTT(ctx context.Context, opts mqttOptions) (*mqttClient, error) {
+	u, err := url.Parse(opts.Broker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker URL: %w", err)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to broker: %w", err)
+	}
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "tls", "ssl", "mqtts":
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		err = tlsConn.Handshake()
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake with broker failed: %w", err)
+		}
+		conn = tlsConn
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("unsupported broker scheme %q", u.Scheme)
+	}
+	c := &mqttClient{
+		conn:      conn,
+		r:         bufio.NewReader(conn),
+		keepAlive: opts.KeepAlive,
+	}
+	if c.keepAlive <= 0 {
+		c.keepAlive = time.Minute
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	err = c.connect(opts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (c *mqttClient) connect(opts mqttOptions) error {
+	var flags byte = 0x02 // clean session
+	var payload []byte
+	payload = appendMQTTString(payload, []byte(opts.ClientID))
+	if opts.WillTopic != "" {
+		// will flag, will retain, QoS 0
+		flags |= 0x04 | 0x20
+		payload = appendMQTTString(payload, []byte(opts.WillTopic))
+		payload = appendMQTTString(payload, opts.WillPayload)
+	}
+	if opts.Username != "" {
+		flags |= 0x80
+		payload = appendMQTTString(payload, []byte(opts.Username))
+		if opts.Password != "" {
+			flags |= 0x40
+			payload = appendMQTTString(payload, []byte(opts.Password))
+		}
+	}
+	var body []byte
+	body = appendMQTTString(body, []byte("MQTT"))
+	body = append(body, 4, flags)
+	body = append(body, byte(c.keepAlive/time.Second>>8), byte(c.keepAlive/time.Second))
+	body = append(body, payload...)
+	err := c.write(mqttConnect<<4, body)
+	if err != nil {
+		return err
+	}
+	packetType, resp, err := c.read()
+	if err != nil {
+		return fmt.Errorf("error reading CONNACK: %w", err)
+	}
+	if packetType != mqttConnack || len(resp) != 2 {
+		return fmt.Errorf("expected CONNACK, got packet type %d", packetType)
+	}
+	if resp[1] != 0 {
+		return fmt.Errorf("broker refused connection with code %d", resp[1])
+	}
+	return nil
+}
+
+// Publish sends payload to topic at QoS 0.
+func (c *mqttClient) Publish(topic string, payload []byte, retain bool) error {
+	var header byte = mqttPublish << 4
+	if retain {
+		header |= 0x01
+	}
+	body := appendMQTTString(nil, []byte(topic))
+	body = append(body, payload...)
+	return c.write(header, body)
+}
+
+// Subscribe asks the broker to send us messages published to topics, at QoS
+// 0. The SUBACK is handled by Run.
+func (c *mqttClient) Subscribe(topics ...string) error {
+	c.writeMu.Lock()
+	c.packetID++
+	if c.packetID == 0 {
+		c.packetID++
+	}
+	id := c.packetID
+	c.writeMu.Unlock()
+	body := []byte{byte(id >> 8), byte(id)}
+	for _, topic := range topics {
+		body = appendMQTTString(body, []byte(topic))
+		body = append(body, 0)
+	}
+	return c.write(mqttSubscribe<<4|0x02, body)
+}
+
+// Run reads packets from the broker, passing messages to handler and
+// keeping the connection alive, until the connection fails or ctx is
+// cancelled.
+func (c *mqttClient) Run(ctx context.Context, handler func(topic string, payload []byte)) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		t := time.NewTicker(c.keepAlive / 2)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				err := c.write(mqttPingreq<<4, nil)
+				if err != nil {
+					c.conn.Close()
+					return
+				}
+			case <-ctx.Done():
+				c.conn.Close()
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+		packetType, body, err := c.read()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		switch packetType {
+		case mqttPublish:
+			topic, payload, err := parseMQTTPublish(body)
+			if err != nil {
+				return err
+			}
+			handler(topic, payload)
+		case mqttSuback:
+			if len(body) > 2 && body[2] == 0x80 {
+				return errors.New("broker refused subscription")
+			}
+		case mqttPingresp:
+		default:
+			return fmt.Errorf("unexpected packet type %d from broker", packetType)
+		}
+	}
+}
+
+// Close disconnects cleanly from the broker, so it doesn't publish our will.
+func (c *mqttClient) Close() error {
+	c.write(mqttDisconnect<<4, nil)
+	return c.conn.Close()
+}
+
+func (c *mqttClient) write(header byte, body []byte) error {
+	if len(body) > mqttMaxRemaining {
+		return errors.New("MQTT packet too large")
+	}
+	packet := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(mqttWriteTimeout))
+	_, err := c.conn.Write(packet)
+	if err != nil {
+		return fmt.Errorf("cannot write to broker: %w", err)
+	}
+	return nil
+}
+
+func (c *mqttClient) read() (byte, []byte, error) {
+	header, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var n, multiplier int = 0, 1
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+		if multiplier > 128*128*128 {
+			return 0, nil, errors.New("malformed remaining length from broker")
+		}
+	}
+	body := make([]byte, n)
+	_, err = io.ReadFull(c.r, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	// we only subscribe at QoS 0, and brokers only ever downgrade the
+	// QoS of messages they send us, so PUBLISH bodies never have a packet
+	// ID to strip.
+	packetType := header >> 4
+	return packetType, body, nil
+}
+
+func parseMQTTPublish(body []byte) (string, []byte, error) {
+	if len(body) < 2 {
+		return "", nil, errors.New("malformed PUBLISH from broker")
+	}
+	topicLen := int(binary.BigEndian.Uint16(body))
+	if len(body) < topicLen+2 {
+		return "", nil, errors.New("malformed PUBLISH from broker")
+	}
+	return string(body[2 : 2+topicLen]), body[2+topicLen:], nil
+}
+
+func appendMQTTString(b []byte, s []byte) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,14 @@
+package main
+
+import "context"
+
+// Notifier is told when something changes, so it can pass the news on to
+// other systems. Implementations must not block for long; they're called
+// while handling requests.
+type Notifier interface {
+	DeviceChanged(ctx context.Context, id string, status Status)
+	// DeviceRemoved is called when a device's status is deleted.
+	DeviceRemoved(ctx context.Context, id string)
+	SignChanged(ctx context.Context, state SignState)
+	OverrideChanged(ctx context.Context, override *Override)
+}
@@ -0,0 +1,230 @@
+package main
+
+import "net/http"
+
+// openAPISpec describes the v1 API. Keep it up to date when changing the
+// routes in api.go.
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "camera-signd",
+    "description": "Tracks whether cameras and microphones are in use, and shows it on a sign.",
+    "version": "1"
+  },
+  "paths": {
+    "/v1/status": {
+      "get": {
+        "summary": "List the status of every device",
+        "responses": {
+          "200": {
+            "description": "Statuses, keyed by device ID",
+            "content": {"application/json": {"schema": {"type": "object", "additionalProperties": {"$ref": "#/components/schemas/Status"}}}}
+          }
+        }
+      },
+      "delete": {
+        "summary": "Forget every device's status",
+        "description": "MQTT and webhooks are told each device was removed.",
+        "responses": {
+          "204": {"description": "Statuses cleared"}
+        }
+      }
+    },
+    "/v1/status/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/DeviceID"}],
+      "get": {
+        "summary": "Get a device's status",
+        "responses": {
+          "200": {
+            "description": "The device's status",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Status"}}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "404": {
+            "description": "The device hasn't reported its status",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+          }
+        }
+      },
+      "patch": {
+        "summary": "Report a device's status",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/StatusUpdate"}}}
+        },
+        "responses": {
+          "200": {
+            "description": "The status was stored, and the sign will be updated in the background. If its last update failed, sign.error says why.",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "properties": {
+                "status": {"$ref": "#/components/schemas/Status"},
+                "sign": {"$ref": "#/components/schemas/SignReport"}
+              }
+            }}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "413": {"$ref": "#/components/responses/TooLarge"}
+        }
+      }
+    },
+    "/v1/sign": {
+      "get": {
+        "summary": "Get what the sign is showing, and why",
+        "responses": {
+          "200": {
+            "description": "The sign's state",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Sign"}}}
+          }
+        }
+      }
+    },
+    "/v1/override": {
+      "put": {
+        "summary": "Force the sign into a state",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Override"}}}
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/Override"},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "413": {"$ref": "#/components/responses/TooLarge"}
+        }
+      },
+      "delete": {
+        "summary": "Clear the override",
+        "responses": {
+          "200": {"$ref": "#/components/responses/Override"}
+        }
+      }
+    },
+    "/v1/webhooks/deliveries": {
+      "get": {
+        "summary": "List recent webhook deliveries, newest first",
+        "description": "Only available when webhooks are configured.",
+        "responses": {
+          "200": {
+            "description": "Deliveries",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/WebhookDelivery"}}}}
+          }
+        }
+      }
+    },
+    "/v1/openapi.json": {
+      "get": {
+        "summary": "This document",
+        "responses": {
+          "200": {"description": "The OpenAPI spec", "content": {"application/json": {}}}
+        }
+      }
+    }
+  },
+  "components": {
+    "parameters": {
+      "DeviceID": {
+        "name": "id",
+        "in": "path",
+        "required": true,
+        "description": "The device's ID, usually its MAC address.",
+        "schema": {"type": "string", "pattern": "^[A-Za-z0-9][A-Za-z0-9._:-]{0,63}$"}
+      }
+    },
+    "responses": {
+      "BadRequest": {
+        "description": "The request was invalid",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "TooLarge": {
+        "description": "The request body was larger than 64KiB",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "Override": {
+        "description": "The override was changed, and the sign will be updated in the background. If its last update failed, sign.error says why.",
+        "content": {"application/json": {"schema": {
+          "type": "object",
+          "properties": {
+            "override": {"$ref": "#/components/schemas/Override"},
+            "sign": {"$ref": "#/components/schemas/SignReport"}
+          }
+        }}}
+      }
+    },
+    "schemas": {
+      "SignState": {
+        "type": "string",
+        "enum": ["free", "busy-audio", "busy-video", "dnd", "away"]
+      },
+      "StatusUpdate": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string", "maxLength": 128, "description": "A human-readable name for the device. Omit to keep the name it already has."},
+          "cameraOn": {"type": "boolean"},
+          "micOn": {"type": "boolean"}
+        }
+      },
+      "Status": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "cameraOn": {"type": "boolean"},
+          "micOn": {"type": "boolean"},
+          "lastSync": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Override": {
+        "type": "object",
+        "required": ["state"],
+        "properties": {
+          "state": {"$ref": "#/components/schemas/SignState"},
+          "until": {"type": "string", "format": "date-time", "description": "When the override expires. Omit to never expire."}
+        }
+      },
+      "Sign": {
+        "type": "object",
+        "properties": {
+          "state": {"$ref": "#/components/schemas/SignState"},
+          "reason": {"type": "string", "enum": ["override", "device", "schedule", "default", "hold"]},
+          "devices": {"type": "array", "items": {"type": "string"}, "description": "The devices that put the sign in its state, when reason is device."},
+          "override": {"$ref": "#/components/schemas/Override"},
+          "staleAfter": {"type": "string", "description": "How long a device's status counts after it's reported, as a Go duration like 15m0s."}
+        }
+      },
+      "SignReport": {
+        "type": "object",
+        "properties": {
+          "state": {"$ref": "#/components/schemas/SignState"},
+          "error": {"type": "string", "description": "Why the sign's last update failed, if it did."}
+        }
+      },
+      "WebhookDelivery": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer"},
+          "url": {"type": "string"},
+          "event": {"type": "string", "enum": ["device", "sign", "override"]},
+          "attempts": {"type": "integer"},
+          "delivered": {"type": "boolean"},
+          "statusCode": {"type": "integer"},
+          "error": {"type": "string", "description": "Why the last attempt failed, or, for sign and override events, that a newer event superseded this one before it was delivered."},
+          "created": {"type": "string", "format": "date-time"},
+          "updated": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "properties": {
+          "code": {"type": "string", "enum": ["invalid_id", "invalid_body", "body_too_large", "not_found", "method_not_allowed", "internal_error", "invalid_override"]},
+          "message": {"type": "string"}
+        }
+      }
+    }
+  }
+}
+`
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(openAPISpec))
+}
@@ -2,36 +2,83 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"time"
 )
 
-const maxResponseSize = 4096
+// maxResponseSize is the longest response accepted from a plug. Daily stats
+// for a whole month are the longest responses we ask for.
+const maxResponseSize = 64 * 1024
+
+// defaultOnStates are the states a relay is switched on for when the config
+// doesn't say otherwise.
+var defaultOnStates = map[SignState]bool{
+	SignBusyAudio:    true,
+	SignBusyVideo:    true,
+	SignDoNotDisturb: true,
+}
 
 type Hs1xxPlug struct {
 	IPAddress string
+
+	// OnStates are the states the plug is turned on for. It's turned off
+	// for every other state. If nil, defaultOnStates is used.
+	OnStates map[SignState]bool
+}
+
+// SetState turns the plug on or off, depending on whether state is one of
+// its OnStates.
+func (p *Hs1xxPlug) SetState(ctx context.Context, state SignState) error {
+	onStates := p.OnStates
+	if onStates == nil {
+		onStates = defaultOnStates
+	}
+	if !onStates[state] {
+		err := p.TurnOff(ctx)
+		if err != nil {
+			return fmt.Errorf("error turning plug off: %w", err)
+		}
+		return nil
+	}
+	err := p.TurnOn(ctx)
+	if err != nil {
+		return fmt.Errorf("error turning plug on: %w", err)
+	}
+	return nil
 }
 
-func (p *Hs1xxPlug) TurnOn() error {
+func (p *Hs1xxPlug) TurnOn(ctx context.Context) error {
 	json := `{"system":{"set_relay_state":{"state":1}}}`
 	data := encrypt(json)
-	_, err := send(p.IPAddress, data)
+	_, err := send(ctx, p.IPAddress, data)
 	return err
 }
 
-func (p *Hs1xxPlug) TurnOff() error {
+func (p *Hs1xxPlug) TurnOff(ctx context.Context) error {
 	json := `{"system":{"set_relay_state":{"state":0}}}`
 	data := encrypt(json)
-	_, err := send(p.IPAddress, data)
+	_, err := send(ctx, p.IPAddress, data)
 	return err
 }
 
-func (p *Hs1xxPlug) SystemInfo() (string, error) {
+// SetPower turns the plug on or off, whatever state it's showing.
+func (p *Hs1xxPlug) SetPower(ctx context.Context, on bool) error {
+	if on {
+		return p.TurnOn(ctx)
+	}
+	return p.TurnOff(ctx)
+}
+
+func (p *Hs1xxPlug) SystemInfo(ctx context.Context) (string, error) {
 	json := `{"system":{"get_sysinfo":{}}}`
 	data := encrypt(json)
-	reading, err := send(p.IPAddress, data)
+	reading, err := send(ctx, p.IPAddress, data)
 	if err != nil {
 		return "", err
 	}
@@ -39,20 +86,88 @@ func (p *Hs1xxPlug) SystemInfo() (string, error) {
 	return results, nil
 }
 
-func (p *Hs1xxPlug) MeterInfo() (string, error) {
+func (p *Hs1xxPlug) MeterInfo(ctx context.Context) (string, error) {
 	json := `{"system":{"get_sysinfo":{}}, "emeter":{"get_realtime":{},"get_vgain_igain":{}}}`
 	data := encrypt(json)
-	reading, err := send(p.IPAddress, data)
+	reading, err := send(ctx, p.IPAddress, data)
 	if err != nil {
 		return "", nil
 	}
 	return decrypt(reading), nil
 }
 
-func (p *Hs1xxPlug) DailyStats(month int, year int) (string, error) {
+// EmeterReading is a realtime reading from a plug's energy meter.
+type EmeterReading struct {
+	Power   float64 // watts
+	Voltage float64 // volts
+	Current float64 // amperes
+	Total   float64 // kilowatt hours
+}
+
+// emeterReader is implemented by signs with an energy meter.
+type emeterReader interface {
+	Realtime(ctx context.Context) (*EmeterReading, error)
+}
+
+// Realtime reads the plug's energy meter. Only HS110 plugs have one.
+func (p *Hs1xxPlug) Realtime(ctx context.Context) (*EmeterReading, error) {
+	data := encrypt(`{"emeter":{"get_realtime":{}}}`)
+	reading, err := send(ctx, p.IPAddress, data)
+	if err != nil {
+		return nil, err
+	}
+	// older firmware reports volts, amps, watts, and kilowatt hours,
+	// newer firmware reports millivolts, milliamps, milliwatts, and
+	// watt hours
+	var resp struct {
+		Emeter struct {
+			Realtime struct {
+				ErrCode   int      `json:"err_code"`
+				ErrMsg    string   `json:"err_msg"`
+				Power     *float64 `json:"power"`
+				Voltage   *float64 `json:"voltage"`
+				Current   *float64 `json:"current"`
+				Total     *float64 `json:"total"`
+				PowerMW   float64  `json:"power_mw"`
+				VoltageMV float64  `json:"voltage_mv"`
+				CurrentMA float64  `json:"current_ma"`
+				TotalWH   float64  `json:"total_wh"`
+			} `json:"get_realtime"`
+		} `json:"emeter"`
+	}
+	err = json.Unmarshal([]byte(decrypt(reading)), &resp)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing emeter response: %w", err)
+	}
+	rt := resp.Emeter.Realtime
+	if rt.ErrCode != 0 {
+		return nil, fmt.Errorf("plug returned error %d: %s", rt.ErrCode, rt.ErrMsg)
+	}
+	if rt.Power != nil {
+		result := &EmeterReading{Power: *rt.Power}
+		if rt.Voltage != nil {
+			result.Voltage = *rt.Voltage
+		}
+		if rt.Current != nil {
+			result.Current = *rt.Current
+		}
+		if rt.Total != nil {
+			result.Total = *rt.Total
+		}
+		return result, nil
+	}
+	return &EmeterReading{
+		Power:   rt.PowerMW / 1000,
+		Voltage: rt.VoltageMV / 1000,
+		Current: rt.CurrentMA / 1000,
+		Total:   rt.TotalWH / 1000,
+	}, nil
+}
+
+func (p *Hs1xxPlug) DailyStats(ctx context.Context, month int, year int) (string, error) {
 	json := fmt.Sprintf(`{"emeter":{"get_daystat":{"month":%d,"year":%d}}}`, month, year)
 	data := encrypt(json)
-	reading, err := send(p.IPAddress, data)
+	reading, err := send(ctx, p.IPAddress, data)
 	if err != nil {
 		return "", err
 	}
@@ -91,22 +206,78 @@ func decrypt(ciphertext []byte) string {
 	return string(ciphertext)
 }
 
-func send(ip string, payload []byte) ([]byte, error) {
-	// 10 second timeout
-	conn, err := net.DialTimeout("tcp", ip+":9999", time.Duration(10)*time.Second)
+// kasaAddr adds Kasa's port to address, unless it already has a port.
+func kasaAddr(address string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	return net.JoinHostPort(address, "9999")
+}
+
+// plugTimeout is how long to wait for a plug, if ctx doesn't say.
+const plugTimeout = 10 * time.Second
+
+// send sends an encrypted request to the Kasa device at ip, and returns its
+// encrypted response, without the length prefix. It gives up when ctx is
+// done.
+func send(ctx context.Context, ip string, payload []byte) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, plugTimeout)
+		defer cancel()
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", kasaAddr(ip))
 	if err != nil {
 		return nil, fmt.Errorf("cannot connect to plug: %w", err)
 	}
 	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	// the deadline doesn't cover ctx being cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
 	_, err = conn.Write(payload)
 	if err != nil {
-		return nil, fmt.Errorf("cannot write data to plug: %w", err)
+		return nil, fmt.Errorf("cannot write data to plug: %w", ctxErr(ctx, err))
 	}
-	data := make([]byte, 4096)
-	num, err := conn.Read(data)
-	data = data[4:num]
+	var header [4]byte
+	_, err = io.ReadFull(conn, header[:])
 	if err != nil {
-		return data, err
+		return nil, fmt.Errorf("cannot read data from plug: %w", ctxErr(ctx, err))
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n > maxResponseSize {
+		return nil, fmt.Errorf("plug response is %d bytes, more than the %d allowed", n, maxResponseSize)
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(conn, data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read data from plug: %w", ctxErr(ctx, err))
 	}
 	return data, nil
 }
+
+// ctxErr returns ctx's error if it's done, since that's why err happened,
+// or err if it isn't. The connection's deadline can pass just before ctx
+// notices its own, so timeouts after ctx's deadline count too.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			return context.DeadlineExceeded
+		}
+	}
+	return err
+}
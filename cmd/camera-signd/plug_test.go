@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKasa is a Kasa plug, bulb, or light strip, speaking the real
+// protocol on a loopback port once it's started.
+type fakeKasa struct {
+	listener net.Listener
+	done     chan struct{}
+
+	// hang makes the plug read requests and never answer them.
+	hang bool
+	// realtime is the plug's answer to emeter.get_realtime.
+	realtime map[string]interface{}
+	// lightService is the service the bulb's light state is set
+	// through: bulbLightService for bulbs, or stripLightService for
+	// strips. Other devices don't have lights.
+	lightService string
+
+	mu    sync.Mutex
+	relay int
+	light map[string]interface{}
+}
+
+func (k *fakeKasa) start(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %s", err)
+	}
+	k.listener, k.done = listener, make(chan struct{})
+	go k.serve()
+}
+
+func (k *fakeKasa) Addr() string {
+	return k.listener.Addr().String()
+}
+
+func (k *fakeKasa) Close() {
+	close(k.done)
+	k.listener.Close()
+}
+
+func (k *fakeKasa) Relay() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.relay
+}
+
+// Light returns the bulb's light state, with every parameter it's been
+// sent.
+func (k *fakeKasa) Light() map[string]interface{} {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	light := map[string]interface{}{}
+	for param, value := range k.light {
+		light[param] = value
+	}
+	return light
+}
+
+func (k *fakeKasa) serve() {
+	for {
+		conn, err := k.listener.Accept()
+		if err != nil {
+			return
+		}
+		go k.handle(conn)
+	}
+}
+
+// handle answers a request. Like a real plug, it takes one request per
+// connection.
+func (k *fakeKasa) handle(conn net.Conn) {
+	defer conn.Close()
+	var header [4]byte
+	_, err := io.ReadFull(conn, header[:])
+	if err != nil {
+		return
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[:]))
+	_, err = io.ReadFull(conn, payload)
+	if err != nil {
+		return
+	}
+	if k.hang {
+		<-k.done
+		return
+	}
+	var req map[string]map[string]json.RawMessage
+	err = json.Unmarshal([]byte(decrypt(payload)), &req)
+	if err != nil {
+		return
+	}
+	resp := map[string]map[string]interface{}{}
+	k.mu.Lock()
+	for module, methods := range req {
+		resp[module] = map[string]interface{}{}
+		for method, params := range methods {
+			resp[module][method] = k.call(module, method, params)
+		}
+	}
+	k.mu.Unlock()
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	conn.Write(encrypt(string(b)))
+}
+
+// call runs a method, with mu held.
+func (k *fakeKasa) call(module, method string, params json.RawMessage) interface{} {
+	switch module + "." + method {
+	case "system.set_relay_state":
+		var p struct {
+			State int `json:"state"`
+		}
+		json.Unmarshal(params, &p)
+		k.relay = p.State
+		return map[string]interface{}{"err_code": 0}
+	case "system.get_sysinfo":
+		return map[string]interface{}{"err_code": 0, "relay_state": k.relay, "model": "HS110(US)"}
+	case "emeter.get_realtime":
+		if k.realtime == nil {
+			return map[string]interface{}{"err_code": -1, "err_msg": "module not support"}
+		}
+		return k.realtime
+	case bulbLightService + ".transition_light_state", stripLightService + ".set_light_state":
+		if module != k.lightService {
+			return map[string]interface{}{"err_code": -1, "err_msg": "module not support"}
+		}
+		var p map[string]interface{}
+		json.Unmarshal(params, &p)
+		if k.light == nil {
+			k.light = map[string]interface{}{}
+		}
+		// like a real bulb, parameters that aren't sent are left
+		// as they are
+		for param, value := range p {
+			k.light[param] = value
+		}
+		return map[string]interface{}{"err_code": 0}
+	}
+	return map[string]interface{}{"err_code": -2, "err_msg": "member not support"}
+}
+
+func TestEncrypt(t *testing.T) {
+	// what Kasa's own apps send to ask for system info
+	expected, err := hex.DecodeString("0000001dd0f281f88bff9af7d5ef94b6d1b4c09fec95e68fe187e8caf08bf68bf6")
+	if err != nil {
+		t.Fatalf("unexpected error decoding expected bytes: %s", err)
+	}
+	got := encrypt(`{"system":{"get_sysinfo":{}}}`)
+	if !bytes.Equal(got, expected) {
+		t.Errorf("expected %x, got %x", expected, got)
+	}
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	cases := map[string]string{
+		"empty":   "",
+		"one":     "{",
+		"relay":   `{"system":{"set_relay_state":{"state":1}}}`,
+		"unicode": `{"alias":"Büro ☎"}`,
+		"long":    string(bytes.Repeat([]byte(`{"emeter":{"get_daystat":{}}}`), 200)),
+	}
+	for name, plaintext := range cases {
+		plaintext := plaintext
+		t.Run(name, func(t *testing.T) {
+			ciphertext := encrypt(plaintext)
+			if n := binary.BigEndian.Uint32(ciphertext); int(n) != len(plaintext) {
+				t.Errorf("expected length prefix %d, got %d", len(plaintext), n)
+			}
+			if len(plaintext) > 0 && bytes.Equal(ciphertext[4:], []byte(plaintext)) {
+				t.Error("expected ciphertext to differ from plaintext")
+			}
+			got := decrypt(ciphertext[4:])
+			if got != plaintext {
+				t.Errorf("expected %q, got %q", plaintext, got)
+			}
+		})
+	}
+}
+
+func TestKasaAddr(t *testing.T) {
+	cases := map[string]string{
+		"192.168.1.20":       "192.168.1.20:9999",
+		"192.168.1.20:10000": "192.168.1.20:10000",
+		"plug.local":         "plug.local:9999",
+		"fe80::1":            "[fe80::1]:9999",
+		"[fe80::1]:10000":    "[fe80::1]:10000",
+	}
+	for address, expected := range cases {
+		if got := kasaAddr(address); got != expected {
+			t.Errorf("%s: expected %q, got %q", address, expected, got)
+		}
+	}
+}
+
+func TestHs1xxPlugSetState(t *testing.T) {
+	kasa := &fakeKasa{}
+	kasa.start(t)
+	defer kasa.Close()
+	plug := &Hs1xxPlug{IPAddress: kasa.Addr()}
+	ctx := context.Background()
+
+	steps := []struct {
+		state SignState
+		relay int
+	}{
+		{state: SignBusyVideo, relay: 1},
+		{state: SignFree, relay: 0},
+		{state: SignDoNotDisturb, relay: 1},
+		{state: SignAway, relay: 0},
+	}
+	for _, step := range steps {
+		err := plug.SetState(ctx, step.state)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", step.state, err)
+		}
+		if relay := kasa.Relay(); relay != step.relay {
+			t.Errorf("%s: expected relay %d, got %d", step.state, step.relay, relay)
+		}
+	}
+}
+
+func TestHs1xxPlugRealtime(t *testing.T) {
+	cases := map[string]struct {
+		realtime map[string]interface{}
+		expected *EmeterReading
+		err      bool
+	}{
+		"old-firmware": {
+			realtime: map[string]interface{}{"err_code": 0, "power": 3.5, "voltage": 120.1, "current": 0.05, "total": 1.25},
+			expected: &EmeterReading{Power: 3.5, Voltage: 120.1, Current: 0.05, Total: 1.25},
+		},
+		"new-firmware": {
+			realtime: map[string]interface{}{"err_code": 0, "power_mw": 3500, "voltage_mv": 120100, "current_ma": 50, "total_wh": 1250},
+			expected: &EmeterReading{Power: 3.5, Voltage: 120.1, Current: 0.05, Total: 1.25},
+		},
+		"no-emeter": {
+			err: true,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			kasa := &fakeKasa{realtime: c.realtime}
+			kasa.start(t)
+			defer kasa.Close()
+			plug := &Hs1xxPlug{IPAddress: kasa.Addr()}
+
+			reading, err := plug.Realtime(context.Background())
+			if c.err {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", reading)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(reading, c.expected) {
+				t.Errorf("expected %+v, got %+v", c.expected, reading)
+			}
+		})
+	}
+}
+
+func TestSendGivesUpWithContext(t *testing.T) {
+	kasa := &fakeKasa{hang: true}
+	kasa.start(t)
+	defer kasa.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := send(ctx, kasa.Addr(), encrypt(`{"system":{"get_sysinfo":{}}}`))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %+v, got %+v", context.DeadlineExceeded, err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("expected send to give up when its context did, took %s", took)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"yall.in"
+)
+
+// configPollInterval is how often the config file is checked for changes.
+const configPollInterval = 5 * time.Second
+
+// watchConfig reloads the config whenever camera-signd gets a SIGHUP, or the
+// file at path changes, until ctx is done. load loads and validates it; a
+// config that doesn't load is logged, and the last good one is kept. started
+// is the config camera-signd started with.
+//
+// Device statuses and overrides are kept when the config is reloaded. Only
+// the settings are replaced; the rest of the config needs a restart to
+// change.
+func (s *Server) watchConfig(ctx context.Context, path string, started Config, load func() (Config, error)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	t := time.NewTicker(configPollInterval)
+	defer t.Stop()
+
+	modTime, size := fileVersion(path)
+	for {
+		select {
+		case <-hup:
+			yall.FromContext(ctx).WithField("path", path).Info("reloading config after SIGHUP")
+		case <-t.C:
+			newModTime, newSize := fileVersion(path)
+			if newModTime.Equal(modTime) && newSize == size {
+				continue
+			}
+			yall.FromContext(ctx).WithField("path", path).Info("reloading config after it changed")
+		case <-ctx.Done():
+			return
+		}
+		modTime, size = fileVersion(path)
+		s.reloadConfig(ctx, started, load)
+	}
+}
+
+// reloadConfig loads the config, and if it's valid, replaces the server's
+// settings and has the sign updated to match. It warns about changes from started
+// that won't apply until camera-signd restarts.
+func (s *Server) reloadConfig(ctx context.Context, started Config, load func() (Config, error)) {
+	logger := yall.FromContext(ctx)
+	next, err := load()
+	if err != nil {
+		logger.WithError(err).Error("error reloading config; keeping the current one")
+		return
+	}
+	settings, err := next.settings()
+	if err != nil {
+		logger.WithError(err).Error("error reloading config; keeping the current one")
+		return
+	}
+	s.settingsMu.Lock()
+	s.settings = settings
+	s.settingsMu.Unlock()
+	logger.Info("config reloaded")
+	if changed := started.needsRestart(next); len(changed) > 0 {
+		logger.WithField("sections", changed).Warn("restart camera-signd to apply changes to these sections of the config")
+	}
+	s.requestSync()
+}
+
+// fileVersion returns the modification time and size of the file at path,
+// which change when it's written to. They're zero if it can't be read.
+func fileVersion(path string) (time.Time, int64) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return info.ModTime(), info.Size()
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when it's told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeSign records the states it's set to. If block is set, SetState waits
+// for it to be closed, like a plug that isn't answering.
+type fakeSign struct {
+	block chan struct{}
+
+	mu     sync.Mutex
+	states []SignState
+}
+
+func (f *fakeSign) SetState(ctx context.Context, state SignState) error {
+	if f.block != nil {
+		select {
+		case <-f.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.states = append(f.states, state)
+	return nil
+}
+
+func (f *fakeSign) States() []SignState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]SignState(nil), f.states...)
+}
+
+// testServer is a Server with a fake clock, and its controller running
+// until stop is called.
+type testServer struct {
+	*Server
+	clock   *fakeClock
+	handler http.Handler
+	stop    func()
+}
+
+func newTestServer(t *testing.T, sign Sign, debounce time.Duration) *testServer {
+	aggregator, err := Config{}.aggregator()
+	if err != nil {
+		t.Fatalf("unexpected error building aggregator: %s", err)
+	}
+	s := newServer(settings{
+		sign:       sign,
+		aggregator: aggregator,
+		onShutdown: shutdownLeave,
+		staleAfter: defaultStaleAfter,
+		syncEvery:  time.Hour,
+		debounce:   debounce,
+	})
+	clock := &fakeClock{now: time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)}
+	s.clock = clock
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.runSign(ctx)
+		close(done)
+	}()
+	return &testServer{
+		Server:  s,
+		clock:   clock,
+		handler: s.routes(nil),
+		stop: func() {
+			cancel()
+			<-done
+		},
+	}
+}
+
+// do sends a request to the server, and returns its response.
+func (s *testServer) do(method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+func patchStatus(t *testing.T, s *testServer, id string, status Status) patchStatusResponse {
+	b, err := json.Marshal(status)
+	if err != nil {
+		t.Errorf("unexpected error encoding status: %s", err)
+	}
+	w := s.do(http.MethodPatch, "/v1/status/"+id, string(b))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var resp patchStatusResponse
+	err = json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		// this runs in other goroutines, so it can't stop the test
+		t.Errorf("unexpected error decoding response: %s", err)
+	}
+	return resp
+}
+
+func TestPatchStatusHandlerRejects(t *testing.T) {
+	cases := map[string]struct {
+		id     string
+		body   string
+		status int
+		code   string
+	}{
+		"invalid-id": {
+			id:     "-laptop",
+			body:   `{"cameraOn":true}`,
+			status: http.StatusBadRequest,
+			code:   errCodeInvalidID,
+		},
+		"long-id": {
+			id:     strings.Repeat("a", 65),
+			body:   `{"cameraOn":true}`,
+			status: http.StatusBadRequest,
+			code:   errCodeInvalidID,
+		},
+		"invalid-json": {
+			id:     "laptop",
+			body:   `{"cameraOn":`,
+			status: http.StatusBadRequest,
+			code:   errCodeInvalidBody,
+		},
+		"wrong-type": {
+			id:     "laptop",
+			body:   `{"cameraOn":"yes"}`,
+			status: http.StatusBadRequest,
+			code:   errCodeInvalidBody,
+		},
+		"long-name": {
+			id:     "laptop",
+			body:   `{"name":"` + strings.Repeat("a", maxNameLength+1) + `"}`,
+			status: http.StatusBadRequest,
+			code:   errCodeInvalidBody,
+		},
+		"too-large": {
+			id:     "laptop",
+			body:   `{"name":"` + strings.Repeat("a", maxBodySize) + `"}`,
+			status: http.StatusRequestEntityTooLarge,
+			code:   errCodeBodyTooLarge,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			s := newTestServer(t, &fakeSign{}, 0)
+			defer s.stop()
+
+			w := s.do(http.MethodPatch, "/v1/status/"+c.id, c.body)
+			if w.Code != c.status {
+				t.Errorf("expected status %d, got %d: %s", c.status, w.Code, w.Body.String())
+			}
+			var apiErr APIError
+			err := json.Unmarshal(w.Body.Bytes(), &apiErr)
+			if err != nil {
+				t.Fatalf("unexpected error decoding response: %s", err)
+			}
+			if apiErr.Code != c.code {
+				t.Errorf("expected code %q, got %q", c.code, apiErr.Code)
+			}
+			s.statusMu.RLock()
+			defer s.statusMu.RUnlock()
+			if len(s.Statuses) != 0 {
+				t.Errorf("expected no statuses to be stored, got %+v", s.Statuses)
+			}
+		})
+	}
+}
+
+func TestPatchStatusHandler(t *testing.T) {
+	s := newTestServer(t, &fakeSign{}, 0)
+	defer s.stop()
+	s.settingsMu.Lock()
+	s.settings.devices = map[string]DeviceConfig{
+		"desktop": {Name: "Office desktop"},
+	}
+	s.settingsMu.Unlock()
+
+	type step struct {
+		id       string
+		status   Status
+		expected Status
+		sign     SignState
+	}
+	steps := []step{
+		{
+			id:       "laptop",
+			status:   Status{Name: "Work laptop", CameraOn: true},
+			expected: Status{Name: "Work laptop", CameraOn: true},
+			sign:     SignBusyVideo,
+		},
+		{
+			// the name is kept when it isn't sent
+			id:       "laptop",
+			status:   Status{MicOn: true},
+			expected: Status{Name: "Work laptop", MicOn: true},
+			sign:     SignBusyAudio,
+		},
+		{
+			// the config's name wins
+			id:       "desktop",
+			status:   Status{Name: "DESKTOP-1234", CameraOn: true},
+			expected: Status{Name: "Office desktop", CameraOn: true},
+			sign:     SignBusyVideo,
+		},
+	}
+	for pos, step := range steps {
+		s.clock.Advance(time.Minute)
+		step.expected.LastSync = s.clock.Now()
+
+		resp := patchStatus(t, s, step.id, step.status)
+		if resp.Status != step.expected {
+			t.Errorf("step %d: expected %+v, got %+v", pos, step.expected, resp.Status)
+		}
+		if resp.Sign.State != step.sign {
+			t.Errorf("step %d: expected sign %+v, got %+v", pos, step.sign, resp.Sign.State)
+		}
+		s.statusMu.RLock()
+		stored := s.Statuses[step.id]
+		s.statusMu.RUnlock()
+		if stored != step.expected {
+			t.Errorf("step %d: expected %+v to be stored, got %+v", pos, step.expected, stored)
+		}
+	}
+}
+
+// fakeNotifier records the devices it's told were removed.
+type fakeNotifier struct {
+	mu      sync.Mutex
+	removed []string
+}
+
+func (n *fakeNotifier) DeviceChanged(ctx context.Context, id string, status Status) {}
+
+func (n *fakeNotifier) DeviceRemoved(ctx context.Context, id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.removed = append(n.removed, id)
+}
+
+func (n *fakeNotifier) SignChanged(ctx context.Context, state SignState) {}
+
+func (n *fakeNotifier) OverrideChanged(ctx context.Context, override *Override) {}
+
+func TestDeleteStatusHandler(t *testing.T) {
+	s := newTestServer(t, &fakeSign{}, 0)
+	defer s.stop()
+	notifier := &fakeNotifier{}
+	s.notifiers = append(s.notifiers, notifier)
+
+	patchStatus(t, s, "laptop", Status{CameraOn: true})
+	patchStatus(t, s, "phone", Status{MicOn: true})
+	w := s.do(http.MethodDelete, "/v1/status", "")
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+
+	s.statusMu.RLock()
+	statuses := len(s.Statuses)
+	s.statusMu.RUnlock()
+	if statuses != 0 {
+		t.Errorf("expected no statuses, got %d", statuses)
+	}
+	notifier.mu.Lock()
+	removed := append([]string(nil), notifier.removed...)
+	notifier.mu.Unlock()
+	sort.Strings(removed)
+	if expected := []string{"laptop", "phone"}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("expected %+v to be removed, got %+v", expected, removed)
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	cases := map[string]struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		"no-such-endpoint": {
+			method: http.MethodGet,
+			path:   "/v1/nothing",
+			status: http.StatusNotFound,
+			code:   errCodeNotFound,
+		},
+		"wrong-method": {
+			method: http.MethodPost,
+			path:   "/v1/status",
+			status: http.StatusMethodNotAllowed,
+			code:   errCodeMethodNotAllowed,
+		},
+		"wrong-method-unversioned": {
+			method: http.MethodGet,
+			path:   "/override",
+			status: http.StatusMethodNotAllowed,
+			code:   errCodeMethodNotAllowed,
+		},
+	}
+	s := newTestServer(t, &fakeSign{}, 0)
+	defer s.stop()
+	for name, c := range cases {
+		w := s.do(c.method, c.path, "")
+		if w.Code != c.status {
+			t.Errorf("%s: expected status %d, got %d", name, c.status, w.Code)
+		}
+		var apiErr APIError
+		err := json.Unmarshal(w.Body.Bytes(), &apiErr)
+		if err != nil {
+			t.Errorf("%s: unexpected error decoding %q: %s", name, w.Body.String(), err)
+			continue
+		}
+		if apiErr.Code != c.code {
+			t.Errorf("%s: expected code %q, got %q", name, c.code, apiErr.Code)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"yall.in"
+)
+
+// shutdownTimeout is how long camera-signd waits for requests to finish,
+// and for the sign, when it shuts down.
+const shutdownTimeout = 10 * time.Second
+
+// stateFilePath returns where the config says to save state.
+func stateFilePath(config Config) (string, error) {
+	if config.StateFile != "" {
+		return config.StateFile, nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("can't find a directory for the state file: %w", err)
+	}
+	return filepath.Join(configDir, "camera-signd", "state.json"), nil
+}
+
+// saveState writes the device statuses and override to path, replacing it
+// all at once so a crash can't leave half a file.
+func (s *Server) saveState(path string) error {
+	s.statusMu.RLock()
+	b, err := json.MarshalIndent(s, "", "  ")
+	s.statusMu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("error encoding state: %w", err)
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return fmt.Errorf("error creating state directory: %w", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".state-*.json")
+	if err != nil {
+		return fmt.Errorf("error creating state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadState restores the device statuses and override saved at path. It's
+// not an error for there to be nothing saved. Expired overrides are
+// dropped; stale statuses are kept, and ignored like any other stale
+// status.
+func (s *Server) loadState(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading state file: %w", err)
+	}
+	var saved struct {
+		Statuses map[string]Status `json:"statuses"`
+		Override *Override         `json:"override"`
+	}
+	err = json.Unmarshal(b, &saved)
+	if err != nil {
+		return fmt.Errorf("error parsing state file %s: %w", path, err)
+	}
+	if saved.Override != nil && !saved.Override.active(s.clock.Now()) {
+		saved.Override = nil
+	}
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	if saved.Statuses != nil {
+		s.Statuses = saved.Statuses
+	}
+	s.Override = saved.Override
+	return nil
+}
+
+// shutdown puts the sign in the state the config says to leave it in, and
+// saves state to statePath, if it's set. Both are attempted even if the
+// other fails.
+func (s *Server) shutdown(ctx context.Context, statePath string) {
+	logger := yall.FromContext(ctx)
+	settings := s.current()
+	if settings.onShutdown != shutdownLeave {
+		if sign, ok := settings.sign.(powerSwitch); ok {
+			err := sign.SetPower(ctx, settings.onShutdown == shutdownOn)
+			if err != nil {
+				logger.WithError(err).WithField("onShutdown", settings.onShutdown).Error("error setting sign for shutdown")
+			}
+		} else {
+			logger.WithField("onShutdown", settings.onShutdown).Warn("sign can't be switched on or off; leaving it as it is")
+		}
+	}
+	if statePath != "" {
+		err := s.saveState(statePath)
+		if err != nil {
+			logger.WithError(err).WithField("path", statePath).Error("error saving state")
+		} else {
+			logger.WithField("path", statePath).Info("state saved")
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShutdownSavesState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "camera-signd-state")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "camera-signd", "state.json")
+
+	kasa := &fakeKasa{relay: 1}
+	kasa.start(t)
+	defer kasa.Close()
+	now := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	s := newServer(settings{sign: &Hs1xxPlug{IPAddress: kasa.Addr()}, onShutdown: shutdownOff})
+	s.clock = &fakeClock{now: now}
+	statuses := map[string]Status{
+		"laptop":  {Name: "Work laptop", CameraOn: true, LastSync: now.Add(-time.Minute)},
+		"desktop": {MicOn: true, LastSync: now.Add(-time.Hour)},
+	}
+	override := &Override{State: SignDoNotDisturb, Until: now.Add(time.Hour)}
+	s.Statuses, s.Override = statuses, override
+
+	s.shutdown(context.Background(), path)
+	if relay := kasa.Relay(); relay != 0 {
+		t.Errorf("expected the plug to be turned off, got relay %d", relay)
+	}
+
+	cases := map[string]struct {
+		now      time.Time
+		override *Override
+	}{
+		"restored":         {now: now.Add(time.Minute), override: override},
+		"override-expired": {now: now.Add(2 * time.Hour)},
+	}
+	for name, c := range cases {
+		restored := newServer(settings{})
+		restored.clock = &fakeClock{now: c.now}
+		err = restored.loadState(path)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+		if !reflect.DeepEqual(restored.Statuses, statuses) {
+			t.Errorf("%s: expected statuses %+v, got %+v", name, statuses, restored.Statuses)
+		}
+		if !reflect.DeepEqual(restored.Override, c.override) {
+			t.Errorf("%s: expected override %+v, got %+v", name, c.override, restored.Override)
+		}
+	}
+
+	// nothing saved isn't an error
+	err = newServer(settings{}).loadState(filepath.Join(dir, "missing.json"))
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestRunSignStopsWhenCancelled(t *testing.T) {
+	// the sign never answers, so cancelling has to stop the controller
+	// even while it's talking to the sign
+	sign := &fakeSign{block: make(chan struct{})}
+	defer close(sign.block)
+	s := newTestServer(t, sign, 0)
+	patchStatus(t, s, "laptop", Status{CameraOn: true})
+
+	stopped := make(chan struct{})
+	go func() {
+		s.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the controller to stop when its context was cancelled")
+	}
+	if states := sign.States(); len(states) != 0 {
+		t.Errorf("expected the sign not to be set, got %+v", states)
+	}
+}
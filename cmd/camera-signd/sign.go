@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// SignState is what the sign should be telling people.
+type SignState int
+
+const (
+	SignFree SignState = iota
+	SignBusyAudio
+	SignBusyVideo
+	SignDoNotDisturb
+	SignAway
+)
+
+var signStateNames = map[SignState]string{
+	SignFree:         "free",
+	SignBusyAudio:    "busy-audio",
+	SignBusyVideo:    "busy-video",
+	SignDoNotDisturb: "dnd",
+	SignAway:         "away",
+}
+
+func (s SignState) String() string {
+	if name, ok := signStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("SignState(%d)", int(s))
+}
+
+func parseSignState(name string) (SignState, error) {
+	for state, n := range signStateNames {
+		if n == name {
+			return state, nil
+		}
+	}
+	return SignFree, fmt.Errorf("unknown sign state %q", name)
+}
+
+func (s SignState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s *SignState) UnmarshalJSON(b []byte) error {
+	var name string
+	err := json.Unmarshal(b, &name)
+	if err != nil {
+		return err
+	}
+	state, err := parseSignState(name)
+	if err != nil {
+		return err
+	}
+	*s = state
+	return nil
+}
+
+// parseSignStates turns a list of state names into a set of states.
+func parseSignStates(names []string) (map[SignState]bool, error) {
+	states := make(map[SignState]bool, len(names))
+	for _, name := range names {
+		state, err := parseSignState(name)
+		if err != nil {
+			return nil, err
+		}
+		states[state] = true
+	}
+	return states, nil
+}
+
+// Sign is anything that can display a SignState: a plug switching a lamp on
+// and off, a bulb changing colour, a power strip lighting a different lamp
+// per state, etc.
+type Sign interface {
+	SetState(ctx context.Context, state SignState) error
+}
+
+// powerSwitch is implemented by signs that can be switched on or off as a
+// whole, whatever state they're showing.
+type powerSwitch interface {
+	SetPower(ctx context.Context, on bool) error
+}
+
+// What to do with the sign when camera-signd shuts down.
+const (
+	shutdownLeave = "leave"
+	shutdownOff   = "off"
+	shutdownOn    = "on"
+)
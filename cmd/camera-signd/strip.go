@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Hs300Strip is a multi-outlet Kasa power strip, like the HS300 or KP303,
+// with a lamp plugged into each outlet it uses.
+type Hs300Strip struct {
+	IPAddress string
+
+	// Outlets maps outlet indexes, starting at 0, to the states that
+	// outlet should be turned on for. Outlets not listed are left alone.
+	Outlets map[int]map[SignState]bool
+
+	childMu  sync.Mutex
+	childIDs []string
+}
+
+func (p *Hs300Strip) SetState(ctx context.Context, state SignState) error {
+	return p.setOutlets(ctx, func(onStates map[SignState]bool) bool {
+		return onStates[state]
+	})
+}
+
+// SetPower turns every outlet the strip uses on or off.
+func (p *Hs300Strip) SetPower(ctx context.Context, on bool) error {
+	return p.setOutlets(ctx, func(map[SignState]bool) bool {
+		return on
+	})
+}
+
+// setOutlets turns each outlet the strip uses on or off, depending on what
+// on says for the states it's turned on for.
+func (p *Hs300Strip) setOutlets(ctx context.Context, on func(onStates map[SignState]bool) bool) error {
+	childIDs, err := p.children(ctx)
+	if err != nil {
+		return err
+	}
+	for outlet, onStates := range p.Outlets {
+		if outlet < 0 || outlet >= len(childIDs) {
+			return fmt.Errorf("strip has no outlet %d", outlet)
+		}
+		relay := 0
+		if on(onStates) {
+			relay = 1
+		}
+		req := fmt.Sprintf(`{"context":{"child_ids":[%q]},"system":{"set_relay_state":{"state":%d}}}`, childIDs[outlet], relay)
+		_, err := send(ctx, p.IPAddress, encrypt(req))
+		if err != nil {
+			return fmt.Errorf("error setting outlet %d: %w", outlet, err)
+		}
+	}
+	return nil
+}
+
+// children returns the IDs of the strip's outlets, in order, looking them
+// up the first time it's called.
+func (p *Hs300Strip) children(ctx context.Context) ([]string, error) {
+	p.childMu.Lock()
+	defer p.childMu.Unlock()
+	if p.childIDs != nil {
+		return p.childIDs, nil
+	}
+	reading, err := send(ctx, p.IPAddress, encrypt(`{"system":{"get_sysinfo":{}}}`))
+	if err != nil {
+		return nil, fmt.Errorf("error getting strip info: %w", err)
+	}
+	var info struct {
+		System struct {
+			GetSysinfo struct {
+				DeviceID string `json:"deviceId"`
+				Children []struct {
+					ID string `json:"id"`
+				} `json:"children"`
+			} `json:"get_sysinfo"`
+		} `json:"system"`
+	}
+	err = json.Unmarshal([]byte(decrypt(reading)), &info)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing strip info: %w", err)
+	}
+	sysinfo := info.System.GetSysinfo
+	if len(sysinfo.Children) == 0 {
+		return nil, fmt.Errorf("device at %s isn't a power strip", p.IPAddress)
+	}
+	ids := make([]string, 0, len(sysinfo.Children))
+	for _, child := range sysinfo.Children {
+		id := child.ID
+		// some firmware only reports the outlet's suffix
+		if len(id) <= 2 {
+			id = sysinfo.DeviceID + id
+		}
+		ids = append(ids, id)
+	}
+	p.childIDs = ids
+	return ids, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"carvers.dev/camera-sign/certs"
+	"yall.in"
+)
+
+// TLSConfig turns on HTTPS for the API.
+type TLSConfig struct {
+	// CertFile and KeyFile are the PEM-encoded certificate and key to
+	// serve. If they're not set, a self-signed certificate is generated
+	// the first time camera-signd runs, and reused after that.
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+
+	// SelfSignedDir is where the self-signed certificate is kept. It
+	// defaults to camera-signd in the user's config directory.
+	SelfSignedDir string `json:"selfSignedDir,omitempty"`
+
+	// Hosts are extra hostnames and IP addresses to put in the
+	// self-signed certificate, on top of this machine's hostname and
+	// localhost.
+	Hosts []string `json:"hosts,omitempty"`
+
+	// ClientCAFile, if set, requires every client to present a
+	// certificate signed by one of the PEM-encoded CAs in the file.
+	ClientCAFile string `json:"clientCAFile,omitempty"`
+}
+
+// validate checks that the files the config names can be read, without
+// generating a self-signed certificate.
+func (c TLSConfig) validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("certFile and keyFile must be set together")
+	}
+	if c.CertFile != "" {
+		_, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return fmt.Errorf("error loading TLS certificate: %w", err)
+		}
+	}
+	if c.ClientCAFile != "" {
+		caPEM, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("error reading client CA file: %w", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("no certificates found in client CA file %s", c.ClientCAFile)
+		}
+	}
+	return nil
+}
+
+func (c TLSConfig) build(ctx context.Context) (*tls.Config, error) {
+	certFile, keyFile := c.CertFile, c.KeyFile
+	if certFile == "" && keyFile == "" {
+		var err error
+		certFile, keyFile, err = c.selfSigned(ctx)
+		if err != nil {
+			return nil, err
+		}
+	} else if certFile == "" || keyFile == "" {
+		return nil, errors.New("tls certFile and keyFile must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.ClientCAFile != "" {
+		caPEM, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", c.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	yall.FromContext(ctx).WithField("fingerprint", certs.Fingerprint(cert.Certificate[0])).WithField("clientCerts", c.ClientCAFile != "").Info("serving TLS")
+	return config, nil
+}
+
+// selfSigned returns the paths to a self-signed certificate and key,
+// generating them if they don't exist yet.
+func (c TLSConfig) selfSigned(ctx context.Context) (string, string, error) {
+	dir := c.SelfSignedDir
+	if dir == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return "", "", fmt.Errorf("can't find a directory for the self-signed certificate: %w", err)
+		}
+		dir = filepath.Join(configDir, "camera-signd")
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if certErr == nil && keyErr == nil {
+		return certFile, keyFile, nil
+	}
+
+	yall.FromContext(ctx).WithField("dir", dir).Info("generating self-signed certificate")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("error generating key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", fmt.Errorf("error generating serial number: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "camera-signd"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		// marking it as a CA lets clients trust it as their caFile,
+		// instead of pinning it
+		IsCA: true,
+	}
+	hosts := append([]string{"localhost", "127.0.0.1", "::1"}, c.Hosts...)
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname, hostname+".local")
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", fmt.Errorf("error creating certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", fmt.Errorf("error encoding key: %w", err)
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", "", fmt.Errorf("error creating %s: %w", dir, err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		return "", "", fmt.Errorf("error writing key: %w", err)
+	}
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		return "", "", fmt.Errorf("error writing certificate: %w", err)
+	}
+	return certFile, keyFile, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a certificate authority that signs client certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error generating key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unexpected error creating CA certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("unexpected error parsing CA certificate: %s", err)
+	}
+	return testCA{cert: cert, key: key}
+}
+
+// write writes the CA's certificate to path, PEM-encoded.
+func (ca testCA) write(t *testing.T, path string) {
+	err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0644)
+	if err != nil {
+		t.Fatalf("unexpected error writing CA certificate: %s", err)
+	}
+}
+
+// clientCert returns a client certificate signed by the CA.
+func (ca testCA) clientCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error generating key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "laptop"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("unexpected error creating client certificate: %s", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestSelfSigned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "camera-signd-tls")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	c := TLSConfig{SelfSignedDir: filepath.Join(dir, "certs"), Hosts: []string{"sign.example", "10.0.0.9"}}
+
+	config, err := c.build(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatalf("unexpected error parsing certificate: %s", err)
+	}
+	for _, host := range []string{"localhost", "sign.example", "127.0.0.1", "::1", "10.0.0.9"} {
+		if err := cert.VerifyHostname(host); err != nil {
+			t.Errorf("expected the certificate to be valid for %s: %s", host, err)
+		}
+	}
+	if !cert.IsCA {
+		t.Error("expected the certificate to be usable as a CA")
+	}
+	if config.ClientAuth != tls.NoClientCert {
+		t.Errorf("expected no client certificates to be required, got %v", config.ClientAuth)
+	}
+	info, err := os.Stat(filepath.Join(c.SelfSignedDir, "key.pem"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("expected the key to only be readable by its owner, got %s", perm)
+	}
+
+	// the certificate is reused, so pinned fingerprints keep working
+	again, err := c.build(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(again.Certificates[0].Certificate[0], cert.Raw) {
+		t.Error("expected the self-signed certificate to be reused")
+	}
+}
+
+func TestRequireClientCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "camera-signd-tls")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t)
+	ca.write(t, filepath.Join(dir, "clients.pem"))
+
+	c := TLSConfig{SelfSignedDir: dir, ClientCAFile: filepath.Join(dir, "clients.pem")}
+	config, err := c.build(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("expected client certificates to be required, got %v", config.ClientAuth)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	srv.TLS = config
+	// refused handshakes are expected
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	// clients can trust the self-signed certificate as their CA
+	serverCert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatalf("unexpected error parsing certificate: %s", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert)
+
+	cases := map[string]struct {
+		certs []tls.Certificate
+		ok    bool
+	}{
+		"no-certificate":   {},
+		"wrong-ca":         {certs: []tls.Certificate{newTestCA(t).clientCert(t)}},
+		"signed-by-the-ca": {certs: []tls.Certificate{ca.clientCert(t)}, ok: true},
+	}
+	for name, c := range cases {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: c.certs},
+		}}
+		resp, err := client.Get(srv.URL)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%s: expected the connection to be refused, got %s", name, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"yall.in"
+)
+
+const (
+	webhookEventDevice   = "device"
+	webhookEventSign     = "sign"
+	webhookEventOverride = "override"
+
+	// webhookSignatureHeader holds the hex-encoded HMAC-SHA256 of the
+	// body, keyed with the webhook's secret, prefixed with "sha256=".
+	webhookSignatureHeader = "X-Camera-Sign-Signature"
+
+	// maxDeliveries is how many deliveries the delivery log remembers.
+	maxDeliveries = 100
+)
+
+// WebhookConfig configures a URL that gets POSTed to when things change.
+type WebhookConfig struct {
+	URL string `json:"url"`
+
+	// Events limits the webhook to "device", "sign", or "override"
+	// events. Empty means all of them.
+	Events []string `json:"events,omitempty"`
+
+	// Template is a text/template that renders the JSON body. It's
+	// executed with a WebhookEvent. If empty, the WebhookEvent itself
+	// is sent as JSON. The "json" function encodes a value as JSON.
+	Template string `json:"template,omitempty"`
+
+	// Secret, if set, is used to sign the body with HMAC-SHA256.
+	Secret string `json:"secret,omitempty"`
+
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// MaxAttempts is how many times delivery is tried before giving up.
+	// It defaults to 5.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+}
+
+// WebhookEvent is what a webhook is told about.
+type WebhookEvent struct {
+	Event  string    `json:"event"`
+	Time   time.Time `json:"time"`
+	Device string    `json:"device,omitempty"`
+	Status *Status   `json:"status,omitempty"`
+	// Removed is set on device events when the device's status was
+	// deleted.
+	Removed  bool       `json:"removed,omitempty"`
+	State    *SignState `json:"state,omitempty"`
+	Override *Override  `json:"override,omitempty"`
+}
+
+// WebhookDelivery records an attempt to tell a webhook about an event.
+type WebhookDelivery struct {
+	ID         int       `json:"id"`
+	URL        string    `json:"url"`
+	Event      string    `json:"event"`
+	Attempts   int       `json:"attempts"`
+	Delivered  bool      `json:"delivered"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Created    time.Time `json:"created"`
+	Updated    time.Time `json:"updated"`
+}
+
+type webhook struct {
+	config   WebhookConfig
+	events   map[string]bool
+	template *template.Template
+
+	// queue holds the deliveries waiting to be sent, oldest first. They
+	// are sent one at a time, so a webhook hears about events in the
+	// order they happened, even when some need retrying.
+	queueMu sync.Mutex
+	queue   []queuedDelivery
+	sending bool
+}
+
+// queuedDelivery is a delivery waiting in a webhook's queue.
+type queuedDelivery struct {
+	delivery *WebhookDelivery
+	body     []byte
+	// replaces is set for events that only matter until a newer one
+	// with the same replaces arrives, like the sign's state.
+	replaces string
+}
+
+// webhookNotifier delivers events to the configured webhooks in the
+// background, in order, retrying with exponential backoff unless the
+// webhook rejects them with a 4xx response.
+type webhookNotifier struct {
+	ctx      context.Context
+	client   *http.Client
+	webhooks []*webhook
+	// backoff is how long to wait before the first retry.
+	backoff time.Duration
+
+	mu         sync.Mutex
+	nextID     int
+	deliveries []*WebhookDelivery
+}
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func newWebhookNotifier(ctx context.Context, configs []WebhookConfig) (*webhookNotifier, error) {
+	n := &webhookNotifier{
+		ctx:     ctx,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		backoff: time.Second,
+	}
+	for pos, config := range configs {
+		if config.URL == "" {
+			return nil, fmt.Errorf("webhook %d has no url", pos)
+		}
+		hook := &webhook{config: config, events: map[string]bool{}}
+		for _, event := range config.Events {
+			switch event {
+			case webhookEventDevice, webhookEventSign, webhookEventOverride:
+			default:
+				return nil, fmt.Errorf("webhook %d has unknown event %q", pos, event)
+			}
+			hook.events[event] = true
+		}
+		if config.Template != "" {
+			tmpl, err := template.New(config.URL).Funcs(webhookFuncs).Parse(config.Template)
+			if err != nil {
+				return nil, fmt.Errorf("invalid template for webhook %d: %w", pos, err)
+			}
+			hook.template = tmpl
+		}
+		if hook.config.MaxAttempts <= 0 {
+			hook.config.MaxAttempts = 5
+		}
+		n.webhooks = append(n.webhooks, hook)
+	}
+	return n, nil
+}
+
+func (n *webhookNotifier) DeviceChanged(ctx context.Context, id string, status Status) {
+	n.fire(WebhookEvent{Event: webhookEventDevice, Time: time.Now(), Device: id, Status: &status})
+}
+
+func (n *webhookNotifier) DeviceRemoved(ctx context.Context, id string) {
+	n.fire(WebhookEvent{Event: webhookEventDevice, Time: time.Now(), Device: id, Removed: true})
+}
+
+func (n *webhookNotifier) SignChanged(ctx context.Context, state SignState) {
+	n.fire(WebhookEvent{Event: webhookEventSign, Time: time.Now(), State: &state})
+}
+
+func (n *webhookNotifier) OverrideChanged(ctx context.Context, override *Override) {
+	n.fire(WebhookEvent{Event: webhookEventOverride, Time: time.Now(), Override: override})
+}
+
+func (n *webhookNotifier) fire(event WebhookEvent) {
+	for _, hook := range n.webhooks {
+		if len(hook.events) > 0 && !hook.events[event.Event] {
+			continue
+		}
+		body, err := hook.render(event)
+		if err != nil {
+			yall.FromContext(n.ctx).WithField("url", hook.config.URL).WithError(err).Error("error rendering webhook body")
+			continue
+		}
+		queued := queuedDelivery{delivery: n.newDelivery(hook.config.URL, event.Event), body: body}
+		if event.Event != webhookEventDevice {
+			// only the sign's latest state, and the latest override,
+			// matter
+			queued.replaces = event.Event
+		}
+		n.enqueue(hook, queued)
+	}
+}
+
+// enqueue adds queued to hook's queue, dropping any deliveries it
+// replaces, and starts sending the queue if it isn't already being sent.
+func (n *webhookNotifier) enqueue(hook *webhook, queued queuedDelivery) {
+	hook.queueMu.Lock()
+	defer hook.queueMu.Unlock()
+	if queued.replaces != "" {
+		kept := hook.queue[:0]
+		for _, q := range hook.queue {
+			if q.replaces == queued.replaces {
+				n.supersede(q.delivery, queued.delivery)
+				continue
+			}
+			kept = append(kept, q)
+		}
+		hook.queue = kept
+	}
+	hook.queue = append(hook.queue, queued)
+	if !hook.sending {
+		hook.sending = true
+		go n.send(hook)
+	}
+}
+
+// send delivers hook's queue in order, until it's empty.
+func (n *webhookNotifier) send(hook *webhook) {
+	for {
+		hook.queueMu.Lock()
+		if len(hook.queue) == 0 {
+			hook.sending = false
+			hook.queueMu.Unlock()
+			return
+		}
+		queued := hook.queue[0]
+		hook.queue = hook.queue[1:]
+		hook.queueMu.Unlock()
+		n.deliver(hook, queued)
+	}
+}
+
+// replacedBy returns the queued delivery that replaces queued, if there is
+// one.
+func (h *webhook) replacedBy(queued queuedDelivery) *WebhookDelivery {
+	if queued.replaces == "" {
+		return nil
+	}
+	h.queueMu.Lock()
+	defer h.queueMu.Unlock()
+	for _, q := range h.queue {
+		if q.replaces == queued.replaces {
+			return q.delivery
+		}
+	}
+	return nil
+}
+
+// supersede records that delivery won't be sent, because newer replaces
+// it.
+func (n *webhookNotifier) supersede(delivery, newer *WebhookDelivery) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delivery.Error = fmt.Sprintf("superseded by delivery %d", newer.ID)
+	delivery.Updated = time.Now()
+}
+
+func (h *webhook) render(event WebhookEvent) ([]byte, error) {
+	if h.template == nil {
+		return json.Marshal(event)
+	}
+	var buf bytes.Buffer
+	err := h.template.Execute(&buf, event)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (n *webhookNotifier) newDelivery(url, event string) *WebhookDelivery {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nextID++
+	now := time.Now()
+	delivery := &WebhookDelivery{
+		ID:      n.nextID,
+		URL:     url,
+		Event:   event,
+		Created: now,
+		Updated: now,
+	}
+	n.deliveries = append(n.deliveries, delivery)
+	if len(n.deliveries) > maxDeliveries {
+		n.deliveries = n.deliveries[len(n.deliveries)-maxDeliveries:]
+	}
+	return delivery
+}
+
+func (n *webhookNotifier) deliver(hook *webhook, queued queuedDelivery) {
+	delivery := queued.delivery
+	backoff := n.backoff
+	for attempt := 1; attempt <= hook.config.MaxAttempts; attempt++ {
+		code, err := n.post(hook, delivery.ID, queued.body)
+		n.mu.Lock()
+		delivery.Attempts = attempt
+		delivery.StatusCode = code
+		delivery.Updated = time.Now()
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = err.Error()
+		} else {
+			delivery.Delivered = true
+		}
+		n.mu.Unlock()
+		if err == nil {
+			return
+		}
+		// the webhook didn't like the request, and sending it again
+		// won't change its mind
+		rejected := code >= 400 && code <= 499
+		if attempt == hook.config.MaxAttempts || rejected {
+			yall.FromContext(n.ctx).WithField("url", hook.config.URL).WithField("delivery", delivery.ID).WithField("attempts", attempt).WithError(err).Error("giving up on webhook delivery")
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-n.ctx.Done():
+			return
+		}
+		backoff *= 2
+		if newer := hook.replacedBy(queued); newer != nil {
+			n.supersede(delivery, newer)
+			return
+		}
+	}
+}
+
+func (n *webhookNotifier) post(hook *webhook, id int, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(n.ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Camera-Sign-Delivery", strconv.Itoa(id))
+	for k, v := range hook.config.Headers {
+		req.Header.Set(k, v)
+	}
+	if hook.config.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook([]byte(hook.config.Secret), body))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func signWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliveriesHandler lists recent deliveries, newest first.
+func (n *webhookNotifier) deliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	deliveries := make([]WebhookDelivery, 0, len(n.deliveries))
+	for i := len(n.deliveries) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, *n.deliveries[i])
+	}
+	n.mu.Unlock()
+	writeJSON(w, r, http.StatusOK, deliveries)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver is a webhook that records the events it's sent. respond
+// picks the status code for each request, numbered from 1.
+type webhookReceiver struct {
+	respond func(request int) int
+
+	mu     sync.Mutex
+	events []string
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var event WebhookEvent
+	json.NewDecoder(r.Body).Decode(&event)
+	name := event.Event
+	switch {
+	case event.State != nil:
+		name += " " + event.State.String()
+	case event.Device != "":
+		name += " " + event.Device
+	}
+	wr.mu.Lock()
+	wr.events = append(wr.events, name)
+	request := len(wr.events)
+	wr.mu.Unlock()
+	code := http.StatusOK
+	if wr.respond != nil {
+		code = wr.respond(request)
+	}
+	w.WriteHeader(code)
+}
+
+func (wr *webhookReceiver) Events() []string {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	return append([]string(nil), wr.events...)
+}
+
+func newTestWebhookNotifier(t *testing.T, receiver *webhookReceiver) (*webhookNotifier, func()) {
+	server := httptest.NewServer(receiver)
+	ctx, cancel := context.WithCancel(context.Background())
+	n, err := newWebhookNotifier(ctx, []WebhookConfig{{URL: server.URL}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	n.backoff = 10 * time.Millisecond
+	return n, func() {
+		cancel()
+		server.Close()
+	}
+}
+
+// waitForDelivery waits for the webhook to have been sent every event it's
+// going to be.
+func waitForDelivery(t *testing.T, n *webhookNotifier) {
+	t.Helper()
+	waitFor(t, "the webhook to be sent every event", func() bool {
+		hook := n.webhooks[0]
+		hook.queueMu.Lock()
+		defer hook.queueMu.Unlock()
+		return !hook.sending
+	})
+}
+
+func TestWebhookDeliversInOrder(t *testing.T) {
+	receiver := &webhookReceiver{respond: func(request int) int {
+		if request == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	}}
+	n, stop := newTestWebhookNotifier(t, receiver)
+	defer stop()
+	ctx := context.Background()
+
+	n.DeviceChanged(ctx, "laptop", Status{CameraOn: true})
+	n.DeviceChanged(ctx, "phone", Status{MicOn: true})
+	n.DeviceChanged(ctx, "desktop", Status{CameraOn: true})
+	waitForDelivery(t, n)
+
+	// the first is retried before the others are sent
+	expected := []string{"device laptop", "device laptop", "device phone", "device desktop"}
+	if events := receiver.Events(); !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected %+v, got %+v", expected, events)
+	}
+}
+
+func TestWebhookDoesNotRetryRejections(t *testing.T) {
+	receiver := &webhookReceiver{respond: func(request int) int {
+		return http.StatusBadRequest
+	}}
+	n, stop := newTestWebhookNotifier(t, receiver)
+	defer stop()
+
+	n.SignChanged(context.Background(), SignBusyVideo)
+	waitForDelivery(t, n)
+
+	expected := []string{"sign busy-video"}
+	if events := receiver.Events(); !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected %+v, got %+v", expected, events)
+	}
+	n.mu.Lock()
+	delivery := *n.deliveries[0]
+	n.mu.Unlock()
+	if delivery.Attempts != 1 || delivery.Delivered || delivery.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected one undelivered attempt, got %+v", delivery)
+	}
+}
+
+func TestWebhookDropsReplacedSignEvents(t *testing.T) {
+	release := make(chan struct{})
+	receiver := &webhookReceiver{respond: func(request int) int {
+		if request == 1 {
+			<-release
+		}
+		return http.StatusOK
+	}}
+	n, stop := newTestWebhookNotifier(t, receiver)
+	defer stop()
+	ctx := context.Background()
+
+	n.SignChanged(ctx, SignBusyVideo)
+	waitFor(t, "the first event to be sent", func() bool {
+		return len(receiver.Events()) == 1
+	})
+	// these queue up behind the first, and the newer sign state
+	// replaces the older one
+	n.SignChanged(ctx, SignFree)
+	n.DeviceChanged(ctx, "laptop", Status{})
+	n.SignChanged(ctx, SignDoNotDisturb)
+	close(release)
+	waitForDelivery(t, n)
+
+	expected := []string{"sign busy-video", "device laptop", "sign dnd"}
+	if events := receiver.Events(); !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected %+v, got %+v", expected, events)
+	}
+	n.mu.Lock()
+	replaced := *n.deliveries[1]
+	n.mu.Unlock()
+	if replaced.Error != "superseded by delivery 4" {
+		t.Errorf("expected %q, got %q", "superseded by delivery 4", replaced.Error)
+	}
+}
+
+func TestWebhookStopsRetryingReplacedSignEvents(t *testing.T) {
+	receiver := &webhookReceiver{respond: func(request int) int {
+		if request == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	}}
+	n, stop := newTestWebhookNotifier(t, receiver)
+	defer stop()
+	n.backoff = 100 * time.Millisecond
+	ctx := context.Background()
+
+	n.SignChanged(ctx, SignBusyVideo)
+	waitFor(t, "the first attempt", func() bool {
+		return len(receiver.Events()) == 1
+	})
+	n.SignChanged(ctx, SignFree)
+	waitForDelivery(t, n)
+
+	expected := []string{"sign busy-video", "sign free"}
+	if events := receiver.Events(); !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected %+v, got %+v", expected, events)
+	}
+}
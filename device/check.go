@@ -0,0 +1,84 @@
+package device
+
+import (
+	"context"
+	"sync"
+)
+
+// Usage describes whether a device is in use, and by what.
+type Usage struct {
+	// Device is the device that was checked. It's empty for detectors
+	// that check every camera at once, like is-camera-on on darwin.
+	Device Device
+	// Microphone is set if the device is a microphone, rather than a
+	// camera, for detectors like consentstore-mic.
+	Microphone bool
+	InUse      bool
+	// Holders are the processes using the device, on platforms that can
+	// tell.
+	Holders []Holder
+	// Err is set if the device couldn't be checked.
+	Err error
+}
+
+// Holder is a process using a device.
+type Holder struct {
+	PID     int
+	Command string
+	// User is the user running the process, when it's known.
+	User string
+	// Exe is the path to the process's executable, when it's known.
+	Exe string
+	// Cgroup is the process's cgroup, on linux.
+	Cgroup string
+}
+
+// checkEach checks devices in parallel, using check to find the processes
+// using each one.
+func checkEach(ctx context.Context, devices []Device, check func(context.Context, Device) ([]Holder, error)) []Usage {
+	usages := make([]Usage, len(devices))
+	var wg sync.WaitGroup
+	for pos, dev := range devices {
+		wg.Add(1)
+		go func(pos int, dev Device) {
+			defer wg.Done()
+			holders, err := check(ctx, dev)
+			// each goroutine only writes its own element
+			usages[pos] = Usage{Device: dev, InUse: len(holders) > 0, Holders: holders, Err: err}
+		}(pos, dev)
+	}
+	wg.Wait()
+	return usages
+}
+
+// nodeHolders finds the processes using any of dev's nodes, using check,
+// listing each process once.
+func nodeHolders(ctx context.Context, dev Device, check func(context.Context, string) ([]Holder, error)) ([]Holder, error) {
+	var holders []Holder
+	for _, node := range dev.nodes() {
+		found, err := check(ctx, node)
+		if err != nil {
+			return nil, err
+		}
+		holders = appendHolders(holders, found...)
+	}
+	return holders, nil
+}
+
+// appendHolders appends the holders that aren't already in holders, by
+// PID. Holders without a PID are always appended.
+func appendHolders(holders []Holder, add ...Holder) []Holder {
+	for _, h := range add {
+		dup := false
+		for _, existing := range holders {
+			if h.PID != 0 && existing.PID == h.PID {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			holders = append(holders, h)
+		}
+	}
+	return holders
+}
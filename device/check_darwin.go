@@ -7,10 +7,42 @@ import (
 	"strings"
 )
 
-func InUse(ctx context.Context, devicePath string, processes ...string) (bool, error) {
+func init() {
+	Register("macos", func(config DetectorConfig) Detector {
+		return macosDetector{}
+	})
+}
+
+// defaultDetectors are tried in order, when no detectors are configured.
+var defaultDetectors = []string{"macos-log", "macos"}
+
+// macosDetector checks whether any camera is in use with is-camera-on, which
+// has to be installed separately.
+// is-camera-on doesn't say which camera, or which process is using it, so
+// it reports a single Usage with no Device or Holders, and ignores its
+// config.
+type macosDetector struct{}
+
+func (d macosDetector) Name() string {
+	return "macos"
+}
+
+func (d macosDetector) Available(ctx context.Context) error {
+	_, err := exec.LookPath("is-camera-on")
+	return err
+}
+
+func (d macosDetector) Detect(ctx context.Context) ([]Usage, error) {
 	out, err := exec.CommandContext(ctx, "is-camera-on").Output()
 	if err != nil {
-		return false, fmt.Errorf("error checking %s: %w", devicePath, err)
+		return nil, fmt.Errorf("error running is-camera-on: %w", err)
+	}
+	switch result := strings.TrimSpace(string(out)); result {
+	case "true":
+		return []Usage{{InUse: true}}, nil
+	case "false":
+		return []Usage{{InUse: false}}, nil
+	default:
+		return nil, fmt.Errorf("unexpected output from is-camera-on: %q", result)
 	}
-	return strings.TrimSpace(string(out)) == "true", nil
 }
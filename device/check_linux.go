@@ -1,22 +1,105 @@
 package device
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"os/exec"
+	"os/user"
+	"strconv"
+
+	"yall.in"
 )
 
-func InUse(ctx context.Context, devicePath string, processes ...string) (bool, error) {
-	_, err := exec.CommandContext(ctx, "lsof", "-w", "-F", "pcuftn", devicePath).Output()
+func init() {
+	Register("lsof", func(config DetectorConfig) Detector {
+		return lsofDetector{config: config}
+	})
+}
+
+// defaultDetectors are tried in order, when no detectors are configured.
+var defaultDetectors = []string{"procfs", "lsof"}
+
+// lsofDetector finds the processes using cameras with lsof, for systems
+// where /proc can't be read.
+type lsofDetector struct {
+	config DetectorConfig
+}
+
+func (d lsofDetector) Name() string {
+	return "lsof"
+}
+
+func (d lsofDetector) Available(ctx context.Context) error {
+	_, err := exec.LookPath("lsof")
+	return err
+}
+
+// Detect runs lsof for each camera in parallel. If the PipeWire daemon has
+// a camera open, it's replaced by the applications PipeWire says are using
+// the camera.
+func (d lsofDetector) Detect(ctx context.Context) ([]Usage, error) {
+	devices, err := d.config.devices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing devices: %w", err)
+	}
+	return checkEach(ctx, devices, func(ctx context.Context, dev Device) ([]Holder, error) {
+		holders, err := nodeHolders(ctx, dev, lsofHolders)
+		if err != nil {
+			return nil, fmt.Errorf("error checking %s: %w", dev.Path, err)
+		}
+		return d.config.Processes.Apply(resolvePipeWire(ctx, dev, holders)), nil
+	}), nil
+}
+
+func lsofHolders(ctx context.Context, devicePath string) ([]Holder, error) {
+	out, err := exec.CommandContext(ctx, "lsof", "-w", "-F", "pcuftn", devicePath).Output()
 	if err != nil {
 		if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 1 {
 			if len(e.Stderr) > 0 {
-				fmt.Println("lsof stderr: " + string(e.Stderr))
+				yall.FromContext(ctx).WithField("device", devicePath).WithField("stderr", string(e.Stderr)).Debug("lsof wrote to stderr")
 			}
 			// an exit code of 1 means device isn't in use
-			return false, nil
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseLsof(out), nil
+}
+
+// parseLsof reads the processes out of lsof's -F output, where each line is
+// a field identified by its first character. A process set starts with its
+// PID, in a "p" line, followed by its command, "c", and user ID, "u", and
+// then a set of lines for each file it has open.
+func parseLsof(out []byte) []Holder {
+	var holders []Holder
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < 2 {
+			continue
+		}
+		value := line[1:]
+		switch line[0] {
+		case 'p':
+			pid, err := strconv.Atoi(value)
+			if err != nil {
+				continue
+			}
+			holders = append(holders, Holder{PID: pid})
+		case 'c':
+			if len(holders) > 0 {
+				holders[len(holders)-1].Command = value
+			}
+		case 'u':
+			if len(holders) > 0 {
+				holders[len(holders)-1].User = value
+				if u, err := user.LookupId(value); err == nil {
+					holders[len(holders)-1].User = u.Username
+				}
+			}
 		}
-		return false, fmt.Errorf("error checking %s: %w", devicePath, err)
 	}
-	return true, nil
+	return holders
 }
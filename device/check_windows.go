@@ -1,18 +1,75 @@
 package device
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"os/exec"
+	"regexp"
+	"strconv"
 	"strings"
+
+	"yall.in"
 )
 
-func InUse(ctx context.Context, devicePath string, processes ...string) (bool, error) {
-	if len(processes) == 0 {
-		processes = append(processes, "")
+func init() {
+	Register("windows", func(config DetectorConfig) Detector {
+		return windowsDetector{config: config}
+	})
+}
+
+// defaultDetectors are tried in order, when no detectors are configured.
+var defaultDetectors = []string{"consentstore", "windows"}
+
+// windowsDetector finds the processes using cameras with handle64.exe.
+type windowsDetector struct {
+	config DetectorConfig
+}
+
+func (d windowsDetector) Name() string {
+	return "windows"
+}
+
+func (d windowsDetector) Available(ctx context.Context) error {
+	_, err := exec.LookPath("handle64.exe")
+	return err
+}
+
+// Detect runs handle64 for each camera in parallel.
+func (d windowsDetector) Detect(ctx context.Context) ([]Usage, error) {
+	devices, err := d.config.devices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing devices: %w", err)
+	}
+	return checkEach(ctx, devices, func(ctx context.Context, dev Device) ([]Holder, error) {
+		return handleHolders(ctx, dev.Path, d.config.Processes)
+	}), nil
+}
+
+// handleHolders finds the processes using devicePath with handle64.exe,
+// and applies the filter to them. handle64 has no mapping of processes by
+// handle, so it has to check every process's handles, which takes many
+// seconds; when every Include rule can be turned into a substring for it
+// to search for, only the processes matching them are checked, which
+// speeds it up considerably.
+func handleHolders(ctx context.Context, devicePath string, processes ProcessFilter) ([]Holder, error) {
+	var include []string
+	for _, rule := range processes.Include {
+		substring, ok := handleSubstring(rule)
+		if !ok {
+			// check every process, and leave the rules to the filter
+			include = nil
+			break
+		}
+		include = append(include, substring)
+	}
+	if len(include) == 0 {
+		include = append(include, "")
 	}
-	for _, process := range processes {
-		args := []string{"-a"}
+	var holders []Holder
+	for _, process := range include {
+		args := []string{"-a", "-u"}
 		if process != "" {
 			args = append(args, "-p", process)
 		}
@@ -21,16 +78,55 @@ func InUse(ctx context.Context, devicePath string, processes ...string) (bool, e
 		if err != nil {
 			if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 1 {
 				if len(e.Stderr) > 0 {
-					fmt.Println("handle64 stderr: " + string(e.Stderr))
+					yall.FromContext(ctx).WithField("device", devicePath).WithField("process", process).WithField("stderr", string(e.Stderr)).Debug("handle64 wrote to stderr")
 				}
 				// an exit code of 1 means device isn't in use
 				continue
 			}
-			return false, fmt.Errorf("error checking %s: %w", devicePath, err)
+			return nil, fmt.Errorf("error checking %s: %w", devicePath, err)
 		}
 		if !strings.Contains(string(out), "No matching handles found.") {
-			return true, nil
+			found := parseHandle(out)
+			if len(found) == 0 {
+				if process == "" {
+					return nil, fmt.Errorf("error checking %s: can't tell what's using it from handle64's output", devicePath)
+				}
+				// the device is in use by a process matching the
+				// substring, even if we couldn't tell which, so
+				// the rules still get a say
+				holders = appendHolders(holders, processes.Apply([]Holder{{Command: process}})...)
+				continue
+			}
+			// substrings find more than the rules match, like
+			// chrome finding chromedriver.exe for a chrome.exe rule
+			holders = appendHolders(holders, processes.Apply(found)...)
+		}
+	}
+	return holders, nil
+}
+
+// handleLine matches a handle in handle64's output, like:
+//
+//	chrome.exe  pid: 1234  type: File  DOMAIN\user  2A4: \Device\000000a1
+var handleLine = regexp.MustCompile(`^(\S.*?)\s+pid:\s*(\d+)\s+type:\s*\S+\s+(?:(\S.*?)\s+)?[0-9A-Fa-f]+:`)
+
+// parseHandle reads the processes out of handle64's output, listing each
+// process once no matter how many handles it has open.
+func parseHandle(out []byte) []Holder {
+	var holders []Holder
+	seen := map[int]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		match := handleLine.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if match == nil {
+			continue
+		}
+		pid, err := strconv.Atoi(match[2])
+		if err != nil || seen[pid] {
+			continue
 		}
+		seen[pid] = true
+		holders = append(holders, Holder{PID: pid, Command: match[1], User: match[3]})
 	}
-	return false, nil
+	return holders
 }
@@ -0,0 +1,94 @@
+package device
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// consentApp is an app in the CapabilityAccessManager ConsentStore, which
+// is where Windows 10 and later record which apps have used the camera and
+// microphone, and when.
+type consentApp struct {
+	// App is the package family name of a packaged app, like
+	// Microsoft.WindowsCamera_8wekyb3d8bbwe, or the path to a
+	// non-packaged app's executable.
+	App      string
+	Packaged bool
+	// InUse is whether the app is using the capability right now:
+	// it's started using it, and hasn't stopped.
+	InUse bool
+}
+
+// holder describes the app as a Holder. The ConsentStore doesn't record
+// process IDs, so PID is always 0.
+func (a consentApp) holder() Holder {
+	if a.Packaged {
+		return Holder{Command: a.App}
+	}
+	return Holder{Command: a.App[strings.LastIndex(a.App, `\`)+1:], Exe: a.App}
+}
+
+// regValue matches a value in reg query's output, like:
+//
+//	LastUsedTimeStop    REG_QWORD    0x0
+var regValue = regexp.MustCompile(`^\s+(\S.*?)\s{4}(REG_\w+)(?:\s{4}(.*))?$`)
+
+// parseConsentStore lists the apps in the output of
+// `reg query ...\ConsentStore\<capability> /s`. Apps that have never
+// used the capability are left out.
+func parseConsentStore(out []byte, capability string) []consentApp {
+	marker := `\ConsentStore\` + strings.ToLower(capability) + `\`
+	var apps []consentApp
+	var current *consentApp
+	var start, stop uint64
+	finish := func() {
+		if current != nil && start != 0 {
+			current.InUse = stop == 0
+			apps = append(apps, *current)
+		}
+		current, start, stop = nil, 0, 0
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r ")
+		if strings.HasPrefix(line, "HKEY_") {
+			finish()
+			pos := strings.Index(strings.ToLower(line), strings.ToLower(marker))
+			if pos < 0 {
+				continue
+			}
+			app := line[pos+len(marker):]
+			packaged := true
+			if strings.HasPrefix(app, `NonPackaged\`) {
+				// non-packaged apps are keyed by their path, with #
+				// instead of \
+				app = strings.Replace(strings.TrimPrefix(app, `NonPackaged\`), "#", `\`, -1)
+				packaged = false
+			}
+			if app == "" || app == "NonPackaged" {
+				continue
+			}
+			current = &consentApp{App: app, Packaged: packaged}
+			continue
+		}
+		match := regValue.FindStringSubmatch(line)
+		if match == nil || current == nil || match[2] != "REG_QWORD" {
+			continue
+		}
+		value, err := strconv.ParseUint(strings.TrimPrefix(match[3], "0x"), 16, 64)
+		if err != nil {
+			continue
+		}
+		switch match[1] {
+		case "LastUsedTimeStart":
+			start = value
+		case "LastUsedTimeStop":
+			stop = value
+		}
+	}
+	finish()
+	return apps
+}
@@ -0,0 +1,60 @@
+package device
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseConsentStore(t *testing.T) {
+	out, err := ioutil.ReadFile(filepath.Join("testdata", "reg_webcam.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps := parseConsentStore(out, "webcam")
+	// People has never used the camera, so it's left out
+	want := []consentApp{
+		{App: "Microsoft.WindowsCamera_8wekyb3d8bbwe", Packaged: true},
+		{App: "MicrosoftTeams_8wekyb3d8bbwe", Packaged: true, InUse: true},
+		{App: `C:\Program Files\Mozilla Firefox\firefox.exe`, InUse: true},
+		{App: `C:\Users\alice\AppData\Local\Zoom\bin\Zoom.exe`},
+	}
+	if !reflect.DeepEqual(apps, want) {
+		t.Errorf("expected %+v, got %+v", want, apps)
+	}
+}
+
+func TestParseConsentStoreOtherCapability(t *testing.T) {
+	out, err := ioutil.ReadFile(filepath.Join("testdata", "reg_webcam.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps := parseConsentStore(out, "microphone")
+	if len(apps) != 0 {
+		t.Errorf("expected no apps, got %+v", apps)
+	}
+}
+
+func TestConsentAppHolder(t *testing.T) {
+	tests := map[string]struct {
+		app  consentApp
+		want Holder
+	}{
+		"packaged": {
+			app:  consentApp{App: "MicrosoftTeams_8wekyb3d8bbwe", Packaged: true},
+			want: Holder{Command: "MicrosoftTeams_8wekyb3d8bbwe"},
+		},
+		"non-packaged": {
+			app:  consentApp{App: `C:\Program Files\Mozilla Firefox\firefox.exe`},
+			want: Holder{Command: "firefox.exe", Exe: `C:\Program Files\Mozilla Firefox\firefox.exe`},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := test.app.holder(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
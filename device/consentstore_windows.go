@@ -0,0 +1,67 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// consentStoreKey is where the ConsentStore lives, under both
+// HKEY_CURRENT_USER and HKEY_LOCAL_MACHINE.
+const consentStoreKey = `Software\Microsoft\Windows\CurrentVersion\CapabilityAccessManager\ConsentStore\`
+
+func init() {
+	Register("consentstore", func(config DetectorConfig) Detector {
+		return consentStoreDetector{name: "consentstore", capability: "webcam", config: config}
+	})
+	Register("consentstore-mic", func(config DetectorConfig) Detector {
+		return consentStoreDetector{name: "consentstore-mic", capability: "microphone", config: config}
+	})
+}
+
+// consentStoreDetector checks which apps Windows says are using the camera,
+// or the microphone, right now, from the ConsentStore. Unlike handle64, it
+// doesn't need to be downloaded or run as an administrator, and it's fast,
+// but it doesn't say which camera is in use, so it reports a single Usage,
+// and ignores the config's devices.
+type consentStoreDetector struct {
+	name       string
+	capability string
+	config     DetectorConfig
+}
+
+func (d consentStoreDetector) Name() string {
+	return d.name
+}
+
+// Available checks the ConsentStore exists, which it does from Windows 10
+// version 1903.
+func (d consentStoreDetector) Available(ctx context.Context) error {
+	_, err := exec.CommandContext(ctx, "reg", "query", `HKCU\`+consentStoreKey+d.capability).Output()
+	if err != nil {
+		return fmt.Errorf("can't read the ConsentStore: %w", err)
+	}
+	return nil
+}
+
+func (d consentStoreDetector) Detect(ctx context.Context) ([]Usage, error) {
+	var holders []Holder
+	for _, hive := range []string{"HKCU", "HKLM"} {
+		out, err := exec.CommandContext(ctx, "reg", "query", hive+`\`+consentStoreKey+d.capability, "/s").Output()
+		if err != nil {
+			if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 1 {
+				// an exit code of 1 means the key doesn't exist
+				continue
+			}
+			return nil, fmt.Errorf("error querying the ConsentStore: %w", err)
+		}
+		for _, app := range parseConsentStore(out, d.capability) {
+			if app.InUse {
+				holders = append(holders, app.holder())
+			}
+		}
+	}
+	holders = d.config.Processes.Apply(holders)
+	usage := Usage{Microphone: d.capability == "microphone", InUse: len(holders) > 0, Holders: holders}
+	return []Usage{usage}, nil
+}
@@ -0,0 +1,261 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrUnknownDetector is returned when a detector that isn't registered is
+// asked for.
+var ErrUnknownDetector = errors.New("unknown detector")
+
+// Detector is one way of finding out which cameras are in use, like
+// scanning /proc, or asking PipeWire.
+type Detector interface {
+	// Name is the name the detector is registered under.
+	Name() string
+	// Available returns an error explaining why the detector can't run
+	// on this machine, or nil if it can.
+	Available(ctx context.Context) error
+	// Detect checks the cameras, returning a Usage for each one. Cameras
+	// that can't be checked have their Usage's Err set; an error is only
+	// returned if none of them could be.
+	Detect(ctx context.Context) ([]Usage, error)
+}
+
+// DetectorConfig configures a Detector.
+type DetectorConfig struct {
+	// Devices, if set, are the paths of the devices to check, instead of
+	// every camera that's listed.
+	Devices []string
+	// DeviceFilter picks which of the listed cameras are checked. It's
+	// ignored if Devices is set.
+	DeviceFilter DeviceFilter
+	// Processes picks which processes count as using a camera.
+	Processes ProcessFilter
+	// Watch, if set, is done when the checks stop repeating. Detectors
+	// can keep things running between checks until then, like macos-log's
+	// log stream. Without it, every check stands alone.
+	Watch context.Context
+}
+
+// devices returns the devices to check. Named devices are named after the
+// camera they are, if it's listed.
+func (c DetectorConfig) devices(ctx context.Context) ([]Device, error) {
+	webcams, err := ListWebcams(ctx)
+	if len(c.Devices) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		return c.DeviceFilter.Apply(webcams), nil
+	}
+	devices := make([]Device, 0, len(c.Devices))
+	for _, path := range c.Devices {
+		dev := Device{Path: path}
+		for _, webcam := range webcams {
+			for _, node := range webcam.nodes() {
+				if node == path {
+					dev.Name, dev.USBID, dev.Driver = webcam.Name, webcam.USBID, webcam.Driver
+				}
+			}
+		}
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
+
+// DetectorFactory builds a Detector from its config.
+type DetectorFactory func(DetectorConfig) Detector
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]DetectorFactory{}
+)
+
+// Register makes a detector available by name. It panics if a detector is
+// already registered with that name.
+func Register(name string, factory DetectorFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("device: Register called twice for detector " + name)
+	}
+	registry[name] = factory
+}
+
+// Detectors returns the names of the registered detectors, sorted.
+func Detectors() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewDetector builds the detector registered as name.
+func NewDetector(name string, config DetectorConfig) (Detector, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownDetector, name)
+	}
+	return factory(config), nil
+}
+
+// DefaultDetector returns the name of the detector to use when none are
+// configured: the first of this platform's defaults that's available.
+func DefaultDetector(ctx context.Context) (string, error) {
+	var errs []string
+	for _, name := range defaultDetectors {
+		detector, err := NewDetector(name, DetectorConfig{})
+		if err != nil {
+			return "", err
+		}
+		err = detector.Available(ctx)
+		if err == nil {
+			return name, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", name, err))
+	}
+	return "", fmt.Errorf("no detectors are available: %v", errs)
+}
+
+// Report is the result of running a detector.
+type Report struct {
+	Detector string
+	Usages   []Usage
+	Err      error
+}
+
+// InUse reports whether the detector found any camera in use.
+func (r Report) InUse() bool {
+	return r.inUse(false)
+}
+
+// MicInUse reports whether the detector found any microphone in use.
+func (r Report) MicInUse() bool {
+	return r.inUse(true)
+}
+
+func (r Report) inUse(microphone bool) bool {
+	for _, usage := range r.Usages {
+		if usage.Microphone == microphone && usage.InUse {
+			return true
+		}
+	}
+	return false
+}
+
+// checks reports whether the detector checked microphones, or cameras if
+// microphone is false. A detector that failed might have checked either,
+// and a detector that found nothing to check is a camera detector, since
+// microphone detectors always report a Usage.
+func (r Report) checks(microphone bool) bool {
+	if r.Err != nil {
+		return true
+	}
+	if len(r.Usages) == 0 {
+		return !microphone
+	}
+	for _, usage := range r.Usages {
+		if usage.Microphone == microphone {
+			return true
+		}
+	}
+	return false
+}
+
+// Detect runs detectors in parallel, giving each one up to timeout, or as
+// long as it takes if timeout is 0. Reports are in the same order as
+// detectors.
+func Detect(ctx context.Context, detectors []Detector, timeout time.Duration) []Report {
+	reports := make([]Report, len(detectors))
+	var wg sync.WaitGroup
+	for pos, detector := range detectors {
+		wg.Add(1)
+		go func(pos int, detector Detector) {
+			defer wg.Done()
+			detectCtx := ctx
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				detectCtx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			usages, err := detector.Detect(detectCtx)
+			if detectCtx.Err() == context.DeadlineExceeded {
+				timedOut := fmt.Errorf("timed out after %s", timeout)
+				if err != nil {
+					err = timedOut
+				}
+				for i := range usages {
+					if usages[i].Err != nil {
+						usages[i].Err = timedOut
+					}
+				}
+			}
+			// each goroutine only writes its own element
+			reports[pos] = Report{Detector: detector.Name(), Usages: usages, Err: err}
+		}(pos, detector)
+	}
+	wg.Wait()
+	return reports
+}
+
+// Mode is how detectors' reports are combined.
+type Mode string
+
+const (
+	// ModeAny says a camera is on if any detector says so.
+	ModeAny Mode = "any"
+	// ModeAll says a camera is on only if every detector says so.
+	ModeAll Mode = "all"
+)
+
+// ParseMode parses a mode, treating an empty one as ModeAny.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "", ModeAny:
+		return ModeAny, nil
+	case ModeAll:
+		return ModeAll, nil
+	}
+	return "", fmt.Errorf("unknown mode %q; must be %s or %s", s, ModeAny, ModeAll)
+}
+
+// CameraOn combines the reports of the detectors that check cameras. A
+// detector that failed counts as saying the camera is off.
+func (m Mode) CameraOn(reports []Report) bool {
+	return m.on(reports, false)
+}
+
+// MicOn combines the reports of the detectors that check microphones, like
+// CameraOn does for cameras.
+func (m Mode) MicOn(reports []Report) bool {
+	return m.on(reports, true)
+}
+
+func (m Mode) on(reports []Report, microphone bool) bool {
+	checked := false
+	for _, report := range reports {
+		if !report.checks(microphone) {
+			continue
+		}
+		checked = true
+		inUse := report.Err == nil && report.inUse(microphone)
+		if m == ModeAll && !inUse {
+			return false
+		}
+		if m != ModeAll && inUse {
+			return true
+		}
+	}
+	return checked && m == ModeAll
+}
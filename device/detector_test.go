@@ -0,0 +1,78 @@
+package device
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestModeCombinesCamerasAndMicrophones(t *testing.T) {
+	camera := func(inUse bool) Report {
+		return Report{Detector: "procfs", Usages: []Usage{{Device: Device{Path: "/dev/video0"}, InUse: inUse}}}
+	}
+	mic := func(inUse bool) Report {
+		return Report{Detector: "consentstore-mic", Usages: []Usage{{Microphone: true, InUse: inUse}}}
+	}
+	failed := Report{Detector: "pipewire", Err: errors.New("no pipewire")}
+	noCameras := Report{Detector: "procfs"}
+
+	cases := map[string]struct {
+		mode     Mode
+		reports  []Report
+		cameraOn bool
+		micOn    bool
+	}{
+		"no-reports": {
+			mode: ModeAny,
+		},
+		"mic-isnt-camera": {
+			mode:    ModeAny,
+			reports: []Report{camera(false), mic(true)},
+			micOn:   true,
+		},
+		"camera-isnt-mic": {
+			mode:     ModeAny,
+			reports:  []Report{camera(true), mic(false)},
+			cameraOn: true,
+		},
+		"all-ignores-other-kind": {
+			mode:     ModeAll,
+			reports:  []Report{camera(true), camera(true), mic(false)},
+			cameraOn: true,
+		},
+		"all-needs-every-mic": {
+			mode:     ModeAll,
+			reports:  []Report{camera(true), mic(true), mic(false)},
+			cameraOn: true,
+		},
+		"all-without-mic-detectors": {
+			mode:     ModeAll,
+			reports:  []Report{camera(true)},
+			cameraOn: true,
+		},
+		"failed-counts-as-off": {
+			mode:    ModeAll,
+			reports: []Report{camera(true), mic(true), failed},
+		},
+		"failed-doesnt-stop-any": {
+			mode:     ModeAny,
+			reports:  []Report{failed, camera(true), mic(true)},
+			cameraOn: true,
+			micOn:    true,
+		},
+		"no-cameras-found": {
+			mode:    ModeAll,
+			reports: []Report{camera(true), noCameras},
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			if got := c.mode.CameraOn(c.reports); got != c.cameraOn {
+				t.Errorf("expected cameraOn %+v, got %+v", c.cameraOn, got)
+			}
+			if got := c.mode.MicOn(c.reports); got != c.micOn {
+				t.Errorf("expected micOn %+v, got %+v", c.micOn, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package device
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// DeviceFilter picks which cameras are checked. Each rule is a pattern,
+// where * matches anything, that's compared with a camera's name, its USB
+// ID, like "046d:085e", and its driver, like "uvcvideo". Rules can be
+// limited to one of those by prefixing them with "name:", "usb:", or
+// "driver:".
+type DeviceFilter struct {
+	// Include, if set, limits cameras to ones matching at least one rule.
+	Include []string `json:"include,omitempty"`
+	// Exclude skips cameras matching any rule, like v4l2loopback
+	// virtual cameras.
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Match reports whether dev should be checked.
+func (f DeviceFilter) Match(dev Device) bool {
+	fields := map[string][]string{
+		"name":   {dev.Name},
+		"usb":    {dev.USBID},
+		"driver": {dev.Driver},
+	}
+	return matchRules(f.Include, f.Exclude, fields)
+}
+
+// Apply returns the devices that should be checked.
+func (f DeviceFilter) Apply(devices []Device) []Device {
+	filtered := make([]Device, 0, len(devices))
+	for _, dev := range devices {
+		if f.Match(dev) {
+			filtered = append(filtered, dev)
+		}
+	}
+	return filtered
+}
+
+// ProcessFilter picks which processes count as using a camera, so things
+// like PipeWire or a camera daemon, which keep cameras open all the time,
+// don't keep the sign on forever. Each rule is a pattern, where * matches
+// anything, that's compared with a process's command name, its executable's
+// path and base name, and its cgroup. Rules can be limited to one of those
+// by prefixing them with "comm:", "exe:", or "cgroup:".
+//
+// On Windows, Include rules are passed to handle64.exe as process name
+// substrings, which is much faster than checking every process, when they
+// can be; see handleSubstring.
+type ProcessFilter struct {
+	// Include, if set, only counts processes matching at least one rule.
+	Include []string `json:"include,omitempty"`
+	// Exclude doesn't count processes matching any rule.
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Match reports whether h counts as using a camera.
+func (f ProcessFilter) Match(h Holder) bool {
+	exe := []string{h.Exe}
+	if h.Exe != "" {
+		exe = append(exe, path.Base(strings.Replace(h.Exe, `\`, "/", -1)))
+	}
+	fields := map[string][]string{
+		"comm":   {h.Command},
+		"exe":    exe,
+		"cgroup": {h.Cgroup},
+	}
+	return matchRules(f.Include, f.Exclude, fields)
+}
+
+// Apply returns the holders that count as using a camera.
+func (f ProcessFilter) Apply(holders []Holder) []Holder {
+	var filtered []Holder
+	for _, h := range holders {
+		if f.Match(h) {
+			filtered = append(filtered, h)
+		}
+	}
+	return filtered
+}
+
+func matchRules(include, exclude []string, fields map[string][]string) bool {
+	if len(include) > 0 && !matchAny(include, fields) {
+		return false
+	}
+	return !matchAny(exclude, fields)
+}
+
+func matchAny(rules []string, fields map[string][]string) bool {
+	for _, rule := range rules {
+		if matchRule(rule, fields) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchRule(rule string, fields map[string][]string) bool {
+	for field, values := range fields {
+		if strings.HasPrefix(rule, field+":") {
+			return matchValues(strings.TrimPrefix(rule, field+":"), values)
+		}
+	}
+	for _, values := range fields {
+		if matchValues(rule, values) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchValues(pattern string, values []string) bool {
+	for _, value := range values {
+		if value != "" && matchGlob(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSubstring turns an Include rule into a process name substring for
+// handle64.exe to search for, which finds at least every process the rule
+// matches. Rules with a * anywhere but their ends, or that only match
+// cgroups, which Windows doesn't have, can't be turned into one.
+func handleSubstring(rule string) (string, bool) {
+	for _, field := range []string{"comm:", "exe:"} {
+		rule = strings.TrimPrefix(rule, field)
+	}
+	if strings.HasPrefix(rule, "cgroup:") {
+		return "", false
+	}
+	rule = strings.Trim(rule, "*")
+	if rule == "" || strings.Contains(rule, "*") {
+		return "", false
+	}
+	return rule, true
+}
+
+// matchGlob reports whether s matches pattern, where * matches any run of
+// characters, including slashes, and the comparison ignores case.
+func matchGlob(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	for pos, part := range parts {
+		parts[pos] = regexp.QuoteMeta(part)
+	}
+	re, err := regexp.Compile("(?i)^" + strings.Join(parts, ".*") + "$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(s)
+}
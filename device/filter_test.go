@@ -0,0 +1,133 @@
+package device
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		expected   bool
+	}{
+		{pattern: "chrome", s: "chrome", expected: true},
+		{pattern: "chrome", s: "Chrome", expected: true},
+		{pattern: "chrome", s: "chrome-bin"},
+		{pattern: "chrome*", s: "chrome-bin", expected: true},
+		{pattern: "*chrome*", s: "google-chrome-stable", expected: true},
+		{pattern: "/usr/*/zoom", s: "/usr/lib/x86_64/zoom", expected: true},
+		{pattern: "zoom.us", s: "zoomXus"},
+		{pattern: "*", s: "anything", expected: true},
+	}
+	for _, c := range cases {
+		if got := matchGlob(c.pattern, c.s); got != c.expected {
+			t.Errorf("%q against %q: expected %+v, got %+v", c.pattern, c.s, c.expected, got)
+		}
+	}
+}
+
+func TestDeviceFilter(t *testing.T) {
+	brio := Device{Name: "Logitech BRIO", Path: "/dev/video0", USBID: "046d:085e", Driver: "uvcvideo"}
+	loopback := Device{Name: "OBS Virtual Camera", Path: "/dev/video10", Driver: "v4l2 loopback"}
+	// sysfs names the driver without the space, when the node can't be
+	// opened to ask it
+	unopened := Device{Name: "Dummy video device", Path: "/dev/video11", Driver: "v4l2loopback"}
+	integrated := Device{Name: "Integrated Camera: Integrated C", Path: "/dev/video2", USBID: "04f2:b6dd", Driver: "uvcvideo"}
+	devices := []Device{brio, loopback, unopened, integrated}
+
+	cases := map[string]struct {
+		filter   DeviceFilter
+		expected []Device
+	}{
+		"empty": {
+			expected: devices,
+		},
+		"exclude-driver": {
+			filter:   DeviceFilter{Exclude: []string{"driver:v4l2*loopback"}},
+			expected: []Device{brio, integrated},
+		},
+		"include-usb": {
+			filter:   DeviceFilter{Include: []string{"usb:046d:*"}},
+			expected: []Device{brio},
+		},
+		"include-any-field": {
+			filter:   DeviceFilter{Include: []string{"uvcvideo"}},
+			expected: []Device{brio, integrated},
+		},
+		"include-and-exclude": {
+			filter:   DeviceFilter{Include: []string{"uvcvideo"}, Exclude: []string{"name:integrated*"}},
+			expected: []Device{brio},
+		},
+		"prefix-limits-field": {
+			filter:   DeviceFilter{Include: []string{"name:uvcvideo"}},
+			expected: []Device{},
+		},
+	}
+	for name, c := range cases {
+		if got := c.filter.Apply(devices); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: expected %+v, got %+v", name, c.expected, got)
+		}
+	}
+}
+
+func TestProcessFilter(t *testing.T) {
+	chrome := Holder{PID: 1, Command: "chrome", Exe: "/opt/google/chrome/chrome", Cgroup: "/user.slice/app-chrome.scope"}
+	pipewire := Holder{PID: 2, Command: "pipewire", Exe: "/usr/bin/pipewire", Cgroup: "/user.slice/user-1000.slice/user@1000.service/session.slice/pipewire.service"}
+	teams := Holder{PID: 3, Command: "Teams.exe", Exe: `C:\Program Files\Teams\Teams.exe`}
+	holders := []Holder{chrome, pipewire, teams}
+
+	cases := map[string]struct {
+		filter   ProcessFilter
+		expected []Holder
+	}{
+		"empty": {
+			expected: holders,
+		},
+		"exclude-cgroup": {
+			filter:   ProcessFilter{Exclude: []string{"cgroup:*/pipewire.service"}},
+			expected: []Holder{chrome, teams},
+		},
+		"include-whole-name": {
+			filter:   ProcessFilter{Include: []string{"chrom"}},
+			expected: nil,
+		},
+		"include-substring": {
+			filter:   ProcessFilter{Include: []string{"*chrom*"}},
+			expected: []Holder{chrome},
+		},
+		"exe-base-name": {
+			filter:   ProcessFilter{Include: []string{"exe:teams.exe"}},
+			expected: []Holder{teams},
+		},
+		"exe-path": {
+			filter:   ProcessFilter{Include: []string{"exe:/usr/bin/*"}},
+			expected: []Holder{pipewire},
+		},
+	}
+	for name, c := range cases {
+		if got := c.filter.Apply(holders); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: expected %+v, got %+v", name, c.expected, got)
+		}
+	}
+}
+
+func TestHandleSubstring(t *testing.T) {
+	cases := map[string]struct {
+		substring string
+		ok        bool
+	}{
+		"chrome":        {substring: "chrome", ok: true},
+		"*chrome*":      {substring: "chrome", ok: true},
+		"comm:zoom*":    {substring: "zoom", ok: true},
+		"exe:Teams.exe": {substring: "Teams.exe", ok: true},
+		"cgroup:*":      {},
+		"ms*teams":      {},
+		"*":             {},
+	}
+	for rule, c := range cases {
+		substring, ok := handleSubstring(rule)
+		if substring != c.substring || ok != c.ok {
+			t.Errorf("%s: expected %q, %+v, got %q, %+v", rule, c.substring, c.ok, substring, ok)
+		}
+	}
+}
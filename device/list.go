@@ -7,4 +7,22 @@ var ErrDeviceListNotSupported = errors.New("device listing is not supported")
 type Device struct {
 	Name string
 	Path string
+
+	// Nodes are every device node that captures video from the camera,
+	// including Path. It's only set on linux, where a camera can have
+	// more than one.
+	Nodes []string
+	// USBID is the camera's "vendor:product" USB ID, for USB cameras on
+	// linux.
+	USBID string
+	// Driver is the kernel driver for the camera, on linux.
+	Driver string
+}
+
+// nodes returns the device nodes to check for the device.
+func (d Device) nodes() []string {
+	if len(d.Nodes) > 0 {
+		return d.Nodes
+	}
+	return []string{d.Path}
 }
@@ -2,8 +2,14 @@ package device
 
 import (
 	"context"
+	"fmt"
+	"os/exec"
 )
 
 func ListWebcams(ctx context.Context) ([]Device, error) {
-	return nil, ErrDeviceListNotSupported
+	out, err := exec.CommandContext(ctx, "system_profiler", "-json", "SPCameraDataType").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing devices: %w", err)
+	}
+	return parseSystemProfiler(out)
 }
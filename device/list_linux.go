@@ -3,29 +3,14 @@ package device
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
-	"path"
-	"path/filepath"
-	"strings"
 )
 
+// ListWebcams lists the cameras connected to this machine, grouping their
+// video nodes together, and leaving out nodes that don't capture video.
 func ListWebcams(ctx context.Context) ([]Device, error) {
-	files, err := filepath.Glob("/dev/video*")
+	nodes, err := listV4L2Nodes(ctx, sysfsVideo4Linux, "/dev")
 	if err != nil {
 		return nil, fmt.Errorf("error listing devices: %w", err)
 	}
-	devices := make([]Device, 0, len(files))
-	for _, file := range files {
-		devName := path.Base(file)
-		namePath := filepath.Join("/sys/class/video4linux/", devName, "name")
-		b, err := ioutil.ReadFile(namePath)
-		if err != nil {
-			return nil, fmt.Errorf("error getting name of %s: %w", devName, err)
-		}
-		devices = append(devices, Device{
-			Name: strings.TrimSpace(string(b)),
-			Path: file,
-		})
-	}
-	return devices, nil
+	return groupCameras(nodes), nil
 }
@@ -0,0 +1,83 @@
+package device
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseSystemProfiler(t *testing.T) {
+	out, err := ioutil.ReadFile(filepath.Join("testdata", "system_profiler.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := parseSystemProfiler(out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []Device{
+		{Name: "FaceTime HD Camera", Path: "0x8020000005ac8514"},
+		{Name: "Logitech BRIO", Path: "0x14200000046d085e"},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("expected %+v, got %+v", want, devices)
+	}
+}
+
+func TestParseSystemProfilerNoCameras(t *testing.T) {
+	devices, err := parseSystemProfiler([]byte(`{"SPCameraDataType": []}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(devices) != 0 {
+		t.Errorf("expected no devices, got %+v", devices)
+	}
+}
+
+func TestParsePnP(t *testing.T) {
+	tests := map[string]struct {
+		file string
+		want []Device
+	}{
+		"multiple": {
+			file: "pnp.json",
+			// the virtual camera has no PDO name, so it's skipped
+			want: []Device{
+				{Name: "Integrated Webcam", Path: `\Device\000000a1`},
+				{Name: "Logitech BRIO", Path: `\Device\000000b7`},
+			},
+		},
+		"single": {
+			file: "pnp_single.json",
+			want: []Device{
+				{Name: "Integrated Webcam", Path: `\Device\000000a1`},
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			out, err := ioutil.ReadFile(filepath.Join("testdata", test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			devices, err := parsePnP(out)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(devices, test.want) {
+				t.Errorf("expected %+v, got %+v", test.want, devices)
+			}
+		})
+	}
+}
+
+func TestParsePnPEmpty(t *testing.T) {
+	devices, err := parsePnP([]byte("\r\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(devices) != 0 {
+		t.Errorf("expected no devices, got %+v", devices)
+	}
+}
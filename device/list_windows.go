@@ -2,8 +2,14 @@ package device
 
 import (
 	"context"
+	"fmt"
+	"os/exec"
 )
 
 func ListWebcams(ctx context.Context) ([]Device, error) {
-	return nil, ErrDeviceListNotSupported
+	out, err := exec.CommandContext(ctx, "powershell.exe", "-NoProfile", "-NonInteractive", "-Command", pnpScript).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing devices: %w", err)
+	}
+	return parsePnP(out)
 }
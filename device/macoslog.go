@@ -0,0 +1,134 @@
+package device
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// sensorIndicatorsPredicate picks the log messages Control Center writes
+// when apps start or stop using the camera or microphone, which is when it
+// shows or hides the indicator in the menu bar.
+const sensorIndicatorsPredicate = `subsystem == "com.apple.controlcenter" AND category == "sensor-indicators"`
+
+// attributionsPrefix starts Control Center's messages listing the apps
+// using sensors, like:
+//
+//	Active activity attributions changed to ["cam:us.zoom.xos", "mic:us.zoom.xos"]
+const attributionsPrefix = "Active activity attributions changed to "
+
+// attribution matches an app using a sensor in an attributions message.
+// The kind is "cam" or "mic", followed by the app's bundle ID.
+var attribution = regexp.MustCompile(`"(cam|mic):([^"]+)"`)
+
+// sensorApps is which apps are using the camera and microphone.
+type sensorApps struct {
+	Camera     []string
+	Microphone []string
+}
+
+// parseSensorIndicators reads `log show --style ndjson` output for
+// sensorIndicatorsPredicate, and returns which apps the latest attributions
+// message says are using the camera and microphone. found is false if
+// there's no attributions message in the output, so it's unknown.
+func parseSensorIndicators(out []byte) (apps sensorApps, found bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if latest, ok := parseAttributions(scanner.Bytes()); ok {
+			apps, found = latest, true
+		}
+	}
+	return apps, found
+}
+
+// parseAttributions parses a line of ndjson log output, returning which
+// apps it says are using the camera and microphone, or false if it isn't an
+// attributions message.
+func parseAttributions(line []byte) (sensorApps, bool) {
+	var event struct {
+		EventMessage string `json:"eventMessage"`
+	}
+	// log starts with a line that isn't JSON, saying what it's
+	// filtering on
+	if err := json.Unmarshal(line, &event); err != nil {
+		return sensorApps{}, false
+	}
+	if !strings.HasPrefix(event.EventMessage, attributionsPrefix) {
+		return sensorApps{}, false
+	}
+	var apps sensorApps
+	for _, match := range attribution.FindAllStringSubmatch(strings.TrimPrefix(event.EventMessage, attributionsPrefix), -1) {
+		switch match[1] {
+		case "cam":
+			apps.Camera = append(apps.Camera, match[2])
+		case "mic":
+			apps.Microphone = append(apps.Microphone, match[2])
+		}
+	}
+	return apps, true
+}
+
+// sensorLog keeps track of which apps are using the camera and microphone,
+// following a `log stream` for as long as it runs, so each check doesn't
+// have to search the log again. When the stream starts, it's seeded from
+// the latest attributions message already in the log.
+type sensorLog struct {
+	// seedMu stops checks that start together from all seeding it.
+	seedMu sync.Mutex
+
+	mu      sync.Mutex
+	apps    sensorApps
+	found   bool
+	seeded  bool
+	running bool
+}
+
+// follow reads `log stream --style ndjson` output from r until it ends,
+// keeping track of the latest attributions message.
+func (l *sensorLog) follow(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		apps, ok := parseAttributions(scanner.Bytes())
+		if !ok {
+			continue
+		}
+		l.mu.Lock()
+		l.apps, l.found = apps, true
+		l.mu.Unlock()
+	}
+	return scanner.Err()
+}
+
+// seed sets which apps are using sensors from `log show` output, unless the
+// stream has already seen a newer message.
+func (l *sensorLog) seed(out []byte) {
+	apps, found := parseSensorIndicators(out)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if found && !l.found {
+		l.apps, l.found = apps, true
+	}
+	l.seeded = true
+}
+
+// stopped forgets everything the stream said, because it's no longer
+// being followed, so it has to be started and seeded again.
+func (l *sensorLog) stopped() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.apps, l.found, l.seeded, l.running = sensorApps{}, false, false, false
+}
+
+// current returns which apps are using sensors. If no attributions message
+// has been seen, nothing's used a sensor recently.
+func (l *sensorLog) current() sensorApps {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.apps
+}
@@ -0,0 +1,136 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// sensorIndicatorsWindow is how far back to look for the latest
+// attributions message when the log stream starts. Control Center logs one
+// whenever the indicator changes, so an app that started using the camera
+// longer ago than this, before the stream started, isn't seen. Once the
+// stream is running, every change is.
+const sensorIndicatorsWindow = "24h"
+
+// sensorIndicators is shared by the camera and microphone detectors, so
+// there's only ever one log stream.
+var sensorIndicators = &sensorLog{}
+
+func init() {
+	Register("macos-log", func(config DetectorConfig) Detector {
+		return macosLogDetector{name: "macos-log", config: config}
+	})
+	Register("macos-log-mic", func(config DetectorConfig) Detector {
+		return macosLogDetector{name: "macos-log-mic", microphone: true, config: config}
+	})
+}
+
+// macosLogDetector checks which apps are using the camera, or the
+// microphone, from the messages Control Center writes to the unified log
+// when it shows or hides the menu bar indicator. When it's watching, it
+// follows them with log stream, so only the first check has to search the
+// log; otherwise every check searches it. It doesn't say
+// which camera is in use, so it reports a single Usage, and ignores the
+// config's devices. Apps are named by their bundle IDs, and have no PIDs.
+type macosLogDetector struct {
+	name       string
+	microphone bool
+	config     DetectorConfig
+}
+
+func (d macosLogDetector) Name() string {
+	return d.name
+}
+
+func (d macosLogDetector) Available(ctx context.Context) error {
+	_, err := exec.LookPath("log")
+	return err
+}
+
+func (d macosLogDetector) Detect(ctx context.Context) ([]Usage, error) {
+	var apps sensorApps
+	if d.config.Watch == nil {
+		out, err := showSensorIndicators(ctx)
+		if err != nil {
+			return nil, err
+		}
+		apps, _ = parseSensorIndicators(out)
+	} else {
+		err := sensorIndicators.start(d.config.Watch)
+		if err != nil {
+			return nil, err
+		}
+		err = sensorIndicators.seedFromLog(ctx)
+		if err != nil {
+			return nil, err
+		}
+		apps = sensorIndicators.current()
+	}
+	bundles := apps.Camera
+	if d.microphone {
+		bundles = apps.Microphone
+	}
+	var holders []Holder
+	for _, bundle := range bundles {
+		holders = appendHolders(holders, Holder{Command: bundle})
+	}
+	holders = d.config.Processes.Apply(holders)
+	return []Usage{{Microphone: d.microphone, InUse: len(holders) > 0, Holders: holders}}, nil
+}
+
+// start starts following the log stream, if it isn't already being
+// followed. The stream outlives any one check, so it runs until watch is
+// done, and is killed then.
+func (l *sensorLog) start(watch context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.running {
+		return nil
+	}
+	cmd := exec.CommandContext(watch, "log", "stream", "--style", "ndjson", "--predicate", sensorIndicatorsPredicate)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("error streaming the unified log: %w", err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("error streaming the unified log: %w", err)
+	}
+	l.running = true
+	go func() {
+		l.follow(out)
+		cmd.Wait()
+		l.stopped()
+	}()
+	return nil
+}
+
+// seedFromLog seeds l from the log, if it hasn't been since the stream
+// started.
+func (l *sensorLog) seedFromLog(ctx context.Context) error {
+	l.seedMu.Lock()
+	defer l.seedMu.Unlock()
+	l.mu.Lock()
+	seeded := l.seeded
+	l.mu.Unlock()
+	if seeded {
+		return nil
+	}
+	out, err := showSensorIndicators(ctx)
+	if err != nil {
+		return err
+	}
+	l.seed(out)
+	return nil
+}
+
+// showSensorIndicators searches the log for the messages Control Center
+// wrote in the last sensorIndicatorsWindow.
+func showSensorIndicators(ctx context.Context) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, "log", "show", "--style", "ndjson", "--last", sensorIndicatorsWindow, "--predicate", sensorIndicatorsPredicate).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error reading the unified log: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,86 @@
+package device
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSensorIndicators(t *testing.T) {
+	out, err := ioutil.ReadFile(filepath.Join("testdata", "log_sensor_indicators.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps, found := parseSensorIndicators(out)
+	if !found {
+		t.Fatal("expected an attributions message to be found")
+	}
+	// only the latest attributions message counts
+	want := sensorApps{
+		Camera:     []string{"us.zoom.xos", "com.google.Chrome"},
+		Microphone: []string{"us.zoom.xos"},
+	}
+	if !reflect.DeepEqual(apps, want) {
+		t.Errorf("expected %+v, got %+v", want, apps)
+	}
+}
+
+func TestParseSensorIndicatorsNothingActive(t *testing.T) {
+	out := []byte(`{"eventMessage":"Active activity attributions changed to [\"cam:us.zoom.xos\"]"}
+{"eventMessage":"Active activity attributions changed to []"}
+`)
+	apps, found := parseSensorIndicators(out)
+	if !found {
+		t.Fatal("expected an attributions message to be found")
+	}
+	if len(apps.Camera) != 0 || len(apps.Microphone) != 0 {
+		t.Errorf("expected no apps, got %+v", apps)
+	}
+}
+
+func TestParseSensorIndicatorsNoMessages(t *testing.T) {
+	_, found := parseSensorIndicators([]byte("Filtering the log data using \"...\"\n"))
+	if found {
+		t.Error("expected no attributions message to be found")
+	}
+}
+
+func TestSensorLogFollowsStream(t *testing.T) {
+	var l sensorLog
+	stream := strings.NewReader(`Filtering the log data using "..."
+{"eventMessage":"Active activity attributions changed to [\"cam:us.zoom.xos\", \"mic:us.zoom.xos\"]"}
+{"eventMessage":"Sensor indicator shown"}
+{"eventMessage":"Active activity attributions changed to [\"mic:us.zoom.xos\"]"}
+`)
+	err := l.follow(stream)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := sensorApps{Microphone: []string{"us.zoom.xos"}}
+	if got := l.current(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	// the stream is newer than anything already in the log
+	out, err := ioutil.ReadFile(filepath.Join("testdata", "log_sensor_indicators.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.seed(out)
+	if got := l.current(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	// once it stops, it has to be seeded again
+	l.stopped()
+	l.seed(out)
+	want = sensorApps{
+		Camera:     []string{"us.zoom.xos", "com.google.Chrome"},
+		Microphone: []string{"us.zoom.xos"},
+	}
+	if got := l.current(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
@@ -0,0 +1,151 @@
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pipewireObject is one of the objects in pw-dump's output. Only the
+// fields needed to find cameras, the streams linked to them, and the
+// clients those streams belong to are decoded.
+type pipewireObject struct {
+	ID   int    `json:"id"`
+	Type string `json:"type"`
+	Info struct {
+		// State is a node's state, like "running" or "idle", or a
+		// link's, like "active".
+		State        string                 `json:"state"`
+		Props        map[string]interface{} `json:"props"`
+		OutputNodeID int                    `json:"output-node-id"`
+		InputNodeID  int                    `json:"input-node-id"`
+	} `json:"info"`
+}
+
+const (
+	pipewireNode   = "PipeWire:Interface:Node"
+	pipewireLink   = "PipeWire:Interface:Link"
+	pipewireClient = "PipeWire:Interface:Client"
+)
+
+// pipewireCamera is a video source PipeWire knows about.
+type pipewireCamera struct {
+	NodeID int
+	Name   string
+	// Path is the camera's V4L2 device node, if it has one.
+	Path    string
+	Running bool
+	// Consumers are the applications with streams linked to the camera.
+	Consumers []Holder
+}
+
+// parsePwDump lists the cameras in pw-dump's output, along with the
+// applications using each of them. An application is using a camera if its
+// stream has an active link to the camera's node.
+func parsePwDump(out []byte) ([]pipewireCamera, error) {
+	var objects []pipewireObject
+	err := json.Unmarshal(out, &objects)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing pw-dump output: %w", err)
+	}
+	nodes := map[int]pipewireObject{}
+	clients := map[int]pipewireObject{}
+	for _, obj := range objects {
+		switch obj.Type {
+		case pipewireNode:
+			nodes[obj.ID] = obj
+		case pipewireClient:
+			clients[obj.ID] = obj
+		}
+	}
+
+	var cameras []pipewireCamera
+	byNode := map[int]int{}
+	for _, obj := range objects {
+		if obj.Type != pipewireNode || pipewireProp(obj.Info.Props, "media.class") != "Video/Source" {
+			continue
+		}
+		path := pipewireProp(obj.Info.Props, "api.v4l2.path")
+		if path == "" {
+			path = strings.TrimPrefix(pipewireProp(obj.Info.Props, "object.path"), "v4l2:")
+			if !strings.HasPrefix(path, "/dev/") {
+				path = ""
+			}
+		}
+		name := pipewireProp(obj.Info.Props, "node.description")
+		if name == "" {
+			name = pipewireProp(obj.Info.Props, "node.name")
+		}
+		byNode[obj.ID] = len(cameras)
+		cameras = append(cameras, pipewireCamera{
+			NodeID:  obj.ID,
+			Name:    name,
+			Path:    path,
+			Running: obj.Info.State == "running",
+		})
+	}
+
+	// a stream has a link for each port, so only count it once
+	seen := map[[2]int]bool{}
+	for _, obj := range objects {
+		if obj.Type != pipewireLink || obj.Info.State != "active" {
+			continue
+		}
+		pos, ok := byNode[obj.Info.OutputNodeID]
+		if !ok || seen[[2]int{obj.Info.OutputNodeID, obj.Info.InputNodeID}] {
+			continue
+		}
+		seen[[2]int{obj.Info.OutputNodeID, obj.Info.InputNodeID}] = true
+		consumer, ok := nodes[obj.Info.InputNodeID]
+		if !ok {
+			continue
+		}
+		client := clients[pipewireInt(consumer.Info.Props, "client.id")]
+		cameras[pos].Consumers = append(cameras[pos].Consumers, pipewireHolder(consumer.Info.Props, client.Info.Props))
+	}
+	return cameras, nil
+}
+
+// pipewireHolder describes the application a stream belongs to, using the
+// stream's properties, or its client's if the stream doesn't say.
+func pipewireHolder(stream, client map[string]interface{}) Holder {
+	prop := func(key string) string {
+		if v := pipewireProp(stream, key); v != "" {
+			return v
+		}
+		return pipewireProp(client, key)
+	}
+	h := Holder{
+		Command: prop("application.process.binary"),
+		User:    prop("application.process.user"),
+	}
+	h.PID, _ = strconv.Atoi(prop("application.process.id"))
+	if h.Command == "" {
+		h.Command = prop("pipewire.access.portal.app_id")
+	}
+	if h.Command == "" {
+		h.Command = prop("application.name")
+	}
+	return h
+}
+
+// pipewireProp returns a property as a string. pw-dump writes properties
+// that look like numbers as JSON numbers.
+func pipewireProp(props map[string]interface{}, key string) string {
+	switch v := props[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+func pipewireInt(props map[string]interface{}, key string) int {
+	i, err := strconv.Atoi(pipewireProp(props, key))
+	if err != nil {
+		return -1
+	}
+	return i
+}
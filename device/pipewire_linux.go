@@ -0,0 +1,106 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+
+	"yall.in"
+)
+
+func init() {
+	Register("pipewire", func(config DetectorConfig) Detector {
+		return pipewireDetector{config: config}
+	})
+}
+
+// pipewireDetector asks PipeWire which applications are using cameras,
+// according to pw-dump. Browsers and the xdg camera portal use cameras
+// through PipeWire, so the only process with the device open is the
+// PipeWire daemon, which often keeps it open even when nothing is using it.
+type pipewireDetector struct {
+	config DetectorConfig
+}
+
+func (d pipewireDetector) Name() string {
+	return "pipewire"
+}
+
+func (d pipewireDetector) Available(ctx context.Context) error {
+	_, err := exec.LookPath("pw-dump")
+	return err
+}
+
+// Detect reports every camera PipeWire knows about, including ones without
+// a V4L2 device node, like libcamera cameras. Cameras without a node can
+// only be picked with DeviceFilter name rules.
+func (d pipewireDetector) Detect(ctx context.Context) ([]Usage, error) {
+	cameras, err := pipewireCameras(ctx)
+	if err != nil {
+		return nil, err
+	}
+	named := map[string]bool{}
+	for _, path := range d.config.Devices {
+		named[path] = true
+	}
+	var usages []Usage
+	for _, camera := range cameras {
+		dev := Device{Name: camera.Name, Path: camera.Path}
+		if len(named) > 0 && !named[camera.Path] {
+			continue
+		}
+		if len(named) == 0 && !d.config.DeviceFilter.Match(dev) {
+			continue
+		}
+		var holders []Holder
+		if camera.Running {
+			holders = d.config.Processes.Apply(camera.Consumers)
+		}
+		usages = append(usages, Usage{Device: dev, InUse: len(holders) > 0, Holders: holders})
+	}
+	return usages, nil
+}
+
+func pipewireCameras(ctx context.Context) ([]pipewireCamera, error) {
+	out, err := exec.CommandContext(ctx, "pw-dump").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running pw-dump: %w", err)
+	}
+	return parsePwDump(out)
+}
+
+// resolvePipeWire replaces the PipeWire daemon, if it's one of the
+// processes using dev, with the applications PipeWire says are using the
+// camera. If PipeWire can't be asked, the daemon is left in, so it can
+// still be excluded with a ProcessFilter.
+func resolvePipeWire(ctx context.Context, dev Device, holders []Holder) []Holder {
+	for pos, h := range holders {
+		if !isPipeWire(h) {
+			continue
+		}
+		cameras, err := pipewireCameras(ctx)
+		if err != nil {
+			yall.FromContext(ctx).WithField("device", dev.Path).WithError(err).Debug("can't ask PipeWire who's using the device")
+			return holders
+		}
+		others := append(holders[:pos:pos], holders[pos+1:]...)
+		for _, camera := range cameras {
+			if !camera.Running {
+				continue
+			}
+			for _, node := range dev.nodes() {
+				if camera.Path == node {
+					others = appendHolders(others, camera.Consumers...)
+				}
+			}
+		}
+		return others
+	}
+	return holders
+}
+
+// isPipeWire reports whether h is the PipeWire daemon.
+func isPipeWire(h Holder) bool {
+	return h.Command == "pipewire" || filepath.Base(h.Exe) == "pipewire"
+}
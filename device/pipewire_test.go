@@ -0,0 +1,60 @@
+package device
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePwDump(t *testing.T) {
+	out, err := ioutil.ReadFile(filepath.Join("testdata", "pw-dump.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cameras, err := parsePwDump(out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []pipewireCamera{
+		{
+			NodeID:  60,
+			Name:    "Integrated Camera (V4L2)",
+			Path:    "/dev/video0",
+			Running: true,
+			// Firefox's stream has two links, but is only listed
+			// once, and the portal app is named by its app ID
+			Consumers: []Holder{
+				{PID: 4242, Command: "firefox", User: "alice"},
+				{Command: "com.example.Meet"},
+			},
+		},
+		{
+			// the path comes from object.path, and a paused link
+			// doesn't count
+			NodeID: 61,
+			Name:   "Logitech BRIO (V4L2)",
+			Path:   "/dev/video2",
+		},
+	}
+	if !reflect.DeepEqual(cameras, want) {
+		t.Errorf("expected %+v, got %+v", want, cameras)
+	}
+}
+
+func TestParsePwDumpEmpty(t *testing.T) {
+	cameras, err := parsePwDump([]byte("[]"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(cameras) != 0 {
+		t.Errorf("expected no cameras, got %+v", cameras)
+	}
+}
+
+func TestParsePwDumpInvalid(t *testing.T) {
+	_, err := parsePwDump([]byte("pw-dump: can't connect"))
+	if err == nil {
+		t.Error("expected an error, got nil")
+	}
+}
@@ -0,0 +1,61 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// pnpScript lists the cameras Windows knows about, with the Physical Device
+// Object name handle64 needs to check them. Get-PnpDevice fails when no
+// devices match, so errors are silenced, and nothing is printed.
+const pnpScript = `Get-PnpDevice -Class Camera,Image -PresentOnly -ErrorAction SilentlyContinue | ForEach-Object {
+	[pscustomobject]@{
+		FriendlyName = $_.FriendlyName
+		InstanceId = $_.InstanceId
+		PDOName = (Get-PnpDeviceProperty -InstanceId $_.InstanceId -KeyName DEVPKEY_Device_PDOName -ErrorAction SilentlyContinue).Data
+	}
+} | ConvertTo-Json`
+
+type pnpDevice struct {
+	FriendlyName string
+	InstanceID   string `json:"InstanceId"`
+	PDOName      string
+}
+
+// parsePnP lists the cameras in pnpScript's output. ConvertTo-Json prints a
+// single object, rather than an array, when there's only one camera.
+func parsePnP(out []byte) ([]Device, error) {
+	out = bytes.TrimSpace(out)
+	// PowerShell may start its output with a byte order mark
+	out = bytes.TrimPrefix(out, []byte("\xef\xbb\xbf"))
+	if len(out) == 0 {
+		return []Device{}, nil
+	}
+	var parsed []pnpDevice
+	if out[0] == '{' {
+		var single pnpDevice
+		err := json.Unmarshal(out, &single)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing PnP device: %w", err)
+		}
+		parsed = append(parsed, single)
+	} else {
+		err := json.Unmarshal(out, &parsed)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing PnP devices: %w", err)
+		}
+	}
+	devices := make([]Device, 0, len(parsed))
+	for _, dev := range parsed {
+		// without a PDO name, there's no way to check the device
+		if dev.PDOName == "" {
+			continue
+		}
+		devices = append(devices, Device{
+			Name: dev.FriendlyName,
+			Path: dev.PDOName,
+		})
+	}
+	return devices, nil
+}
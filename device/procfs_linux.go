@@ -0,0 +1,179 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"yall.in"
+)
+
+// errNoProcfs means /proc isn't mounted, or can't be read.
+var errNoProcfs = errors.New("procfs is not available")
+
+func init() {
+	Register("procfs", func(config DetectorConfig) Detector {
+		return procfsDetector{config: config, procRoot: "/proc"}
+	})
+}
+
+// procfsDetector finds the processes using cameras by reading the file
+// descriptors of every process in /proc. Processes we can't look at, which
+// are other users' processes unless we're root, are skipped, the same as
+// lsof does.
+type procfsDetector struct {
+	config   DetectorConfig
+	procRoot string
+}
+
+func (d procfsDetector) Name() string {
+	return "procfs"
+}
+
+func (d procfsDetector) Available(ctx context.Context) error {
+	if _, err := os.Stat(filepath.Join(d.procRoot, "self", "fd")); err != nil {
+		return errNoProcfs
+	}
+	return nil
+}
+
+// Detect scans /proc once for every camera's nodes. If the PipeWire daemon
+// has a camera open, it's replaced by the applications PipeWire says are
+// using the camera.
+func (d procfsDetector) Detect(ctx context.Context) ([]Usage, error) {
+	devices, err := d.config.devices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing devices: %w", err)
+	}
+	var paths []string
+	for _, dev := range devices {
+		paths = append(paths, dev.nodes()...)
+	}
+	byPath, err := procHolders(ctx, d.procRoot, paths)
+	if err != nil {
+		return nil, err
+	}
+	usages := make([]Usage, 0, len(devices))
+	for _, dev := range devices {
+		var holders []Holder
+		for _, node := range dev.nodes() {
+			holders = appendHolders(holders, byPath[node]...)
+		}
+		holders = d.config.Processes.Apply(resolvePipeWire(ctx, dev, holders))
+		usages = append(usages, Usage{Device: dev, InUse: len(holders) > 0, Holders: holders})
+	}
+	return usages, nil
+}
+
+// procHolders finds the processes that have each of paths open, by reading
+// the file descriptors of every process in procRoot.
+func procHolders(ctx context.Context, procRoot string, paths []string) (map[string][]Holder, error) {
+	// file descriptors link to the device file itself, so compare them
+	// with where any symlinks, like /dev/v4l/by-id, lead
+	targets := map[string]string{}
+	for _, path := range paths {
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			// the node was unplugged since it was listed, or the
+			// path's wrong, so nothing can have it open
+			yall.FromContext(ctx).WithField("device", path).WithError(err).Debug("can't resolve device node, skipping it")
+			continue
+		}
+		targets[target] = path
+	}
+	pids, err := readDirNames(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	holders := map[string][]Holder{}
+	for _, name := range pids {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join(procRoot, name, "fd")
+		fds, err := readDirNames(fdDir)
+		if err != nil {
+			// the process exited, or isn't ours
+			continue
+		}
+		found := map[string]bool{}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd))
+			if err != nil {
+				continue
+			}
+			path, ok := targets[link]
+			if !ok || found[path] {
+				continue
+			}
+			found[path] = true
+			holders[path] = append(holders[path], procHolder(procRoot, pid))
+		}
+	}
+	return holders, nil
+}
+
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
+
+// procHolder describes the process pid, from procRoot. Anything that can't
+// be read is left empty.
+func procHolder(procRoot string, pid int) Holder {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	h := Holder{PID: pid}
+	if comm, err := ioutil.ReadFile(filepath.Join(dir, "comm")); err == nil {
+		h.Command = strings.TrimSpace(string(comm))
+	}
+	h.Exe, _ = os.Readlink(filepath.Join(dir, "exe"))
+	if cgroup, err := ioutil.ReadFile(filepath.Join(dir, "cgroup")); err == nil {
+		h.Cgroup = parseCgroup(string(cgroup))
+	}
+	if info, err := os.Stat(dir); err == nil {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			h.User = strconv.Itoa(int(stat.Uid))
+			if u, err := user.LookupId(h.User); err == nil {
+				h.User = u.Username
+			}
+		}
+	}
+	return h
+}
+
+// parseCgroup returns a process's cgroup from /proc/<pid>/cgroup. That's
+// the unified hierarchy's cgroup on cgroup v2, or systemd's on cgroup v1,
+// which is what identifies services and user sessions.
+func parseCgroup(cgroups string) string {
+	var fallback string
+	for _, line := range strings.Split(cgroups, "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		switch {
+		case parts[0] == "0" && parts[1] == "":
+			return parts[2]
+		case parts[1] == "name=systemd":
+			fallback = parts[2]
+		case fallback == "":
+			fallback = parts[2]
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,119 @@
+package device
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// writeFiles creates files under dir, with their contents. Contents
+// starting with "->" make a symlink to the rest instead.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(contents) > 2 && contents[:2] == "->" {
+			err = os.Symlink(contents[2:], path)
+		} else {
+			err = ioutil.WriteFile(path, []byte(contents), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "camera-sign-device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// file descriptors link to real paths, so the test's paths need to be
+	// real too
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestProcHolders(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	dev, proc := filepath.Join(dir, "dev"), filepath.Join(dir, "proc")
+	writeFiles(t, dir, map[string]string{
+		"dev/video0":         "",
+		"dev/video2":         "",
+		"dev/v4l/by-id/brio": "->" + filepath.Join(dev, "video0"),
+
+		// zoom has the camera open twice, through its real path
+		"proc/100/fd/3":   "->" + filepath.Join(dev, "video0"),
+		"proc/100/fd/4":   "->" + filepath.Join(dev, "video0"),
+		"proc/100/comm":   "zoom\n",
+		"proc/100/exe":    "->/opt/zoom/zoom",
+		"proc/100/cgroup": "0::/user.slice/user-1000.slice/app-zoom.scope\n",
+
+		"proc/200/fd/5":   "->" + filepath.Join(dev, "video2"),
+		"proc/200/fd/6":   "->/dev/null",
+		"proc/200/comm":   "obs\n",
+		"proc/200/cgroup": "12:devices:/user.slice\n1:name=systemd:/user.slice/obs.scope\n",
+
+		// not using any camera
+		"proc/300/fd/0": "->/dev/null",
+		"proc/300/comm": "bash\n",
+
+		// exited, so its fd directory is gone
+		"proc/400/comm": "gone\n",
+
+		// not a process
+		"proc/self/fd/0": "->" + filepath.Join(dev, "video0"),
+	})
+
+	owner := strconv.Itoa(os.Getuid())
+	if u, err := user.LookupId(owner); err == nil {
+		owner = u.Username
+	}
+	brio, video2 := filepath.Join(dev, "v4l", "by-id", "brio"), filepath.Join(dev, "video2")
+	// video9 was unplugged, so it's skipped
+	holders, err := procHolders(context.Background(), proc, []string{brio, video2, filepath.Join(dev, "video9")})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string][]Holder{
+		brio:   {{PID: 100, Command: "zoom", User: owner, Exe: "/opt/zoom/zoom", Cgroup: "/user.slice/user-1000.slice/app-zoom.scope"}},
+		video2: {{PID: 200, Command: "obs", User: owner, Cgroup: "/user.slice/obs.scope"}},
+	}
+	if !reflect.DeepEqual(holders, expected) {
+		t.Errorf("expected %+v, got %+v", expected, holders)
+	}
+}
+
+func TestProcHoldersNoProcfs(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	_, err := procHolders(context.Background(), filepath.Join(dir, "proc"), nil)
+	if err == nil {
+		t.Error("expected an error, got nil")
+	}
+}
+
+func TestParseCgroup(t *testing.T) {
+	cases := map[string]string{
+		"v2":     "0::/user.slice/user-1000.slice/app-zoom.scope\n",
+		"v1":     "12:devices:/user.slice\n1:name=systemd:/user.slice/user-1000.slice/app-zoom.scope\n",
+		"hybrid": "1:name=systemd:/user.slice/old.scope\n0::/user.slice/user-1000.slice/app-zoom.scope\n",
+	}
+	for name, cgroups := range cases {
+		if got := parseCgroup(cgroups); got != "/user.slice/user-1000.slice/app-zoom.scope" {
+			t.Errorf("%s: expected %q, got %q", name, "/user.slice/user-1000.slice/app-zoom.scope", got)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// systemProfilerCameras is the output of
+// `system_profiler -json SPCameraDataType`.
+type systemProfilerCameras struct {
+	Cameras []struct {
+		Name     string `json:"_name"`
+		ModelID  string `json:"spcamera_model-id"`
+		UniqueID string `json:"spcamera_unique-id"`
+	} `json:"SPCameraDataType"`
+}
+
+// parseSystemProfiler lists the cameras in system_profiler's output. Their
+// paths are their unique IDs, because macOS cameras don't have device
+// files.
+func parseSystemProfiler(out []byte) ([]Device, error) {
+	var parsed systemProfilerCameras
+	err := json.Unmarshal(out, &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing system_profiler output: %w", err)
+	}
+	devices := make([]Device, 0, len(parsed.Cameras))
+	for _, camera := range parsed.Cameras {
+		devices = append(devices, Device{
+			Name: camera.Name,
+			Path: camera.UniqueID,
+		})
+	}
+	return devices, nil
+}
@@ -0,0 +1,193 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"yall.in"
+)
+
+const (
+	sysfsVideo4Linux = "/sys/class/video4linux"
+
+	// vidiocQueryCap is VIDIOC_QUERYCAP, _IOR('V', 0, struct v4l2_capability).
+	vidiocQueryCap = 0x80685600
+
+	// capabilities from linux/videodev2.h
+	capVideoCapture       = 0x00000001
+	capVideoCaptureMplane = 0x00001000
+	capVideoM2MMplane     = 0x00004000
+	capVideoM2M           = 0x00008000
+	capDeviceCaps         = 0x80000000
+)
+
+// v4l2Capability is struct v4l2_capability, which VIDIOC_QUERYCAP fills in.
+type v4l2Capability struct {
+	Driver       [16]byte
+	Card         [32]byte
+	BusInfo      [32]byte
+	Version      uint32
+	Capabilities uint32
+	DeviceCaps   uint32
+	Reserved     [3]uint32
+}
+
+// v4l2Node is a /dev/video* device node.
+type v4l2Node struct {
+	Path  string
+	Name  string
+	Index int
+	// Caps are the node's device capabilities, or 0 if they couldn't be
+	// queried.
+	Caps   uint32
+	Driver string
+	// Parent identifies the physical device the node belongs to: the USB
+	// device, for USB cameras, or the node's sysfs device otherwise.
+	Parent string
+	USBID  string
+}
+
+// capture reports whether the node captures video. Modern UVC cameras have
+// a metadata node alongside each capture node, and hardware codecs show up
+// as video nodes too, so those are left out. Loopback devices, like OBS's
+// virtual camera, capture as far as V4L2 is concerned, so they're kept;
+// DeviceFilter rules matching their driver leave them out. If the node's
+// capabilities couldn't be queried, usually because we don't have
+// permission to open it, the first node of each device is assumed to be
+// the capture node, which is how UVC cameras number them.
+func (n v4l2Node) capture() bool {
+	if n.Caps == 0 {
+		return n.Index == 0
+	}
+	if n.Caps&(capVideoM2M|capVideoM2MMplane) != 0 {
+		return false
+	}
+	return n.Caps&(capVideoCapture|capVideoCaptureMplane) != 0
+}
+
+// listV4L2Nodes describes every video node in sysRoot, a copy of
+// /sys/class/video4linux, whose device files are in devRoot.
+func listV4L2Nodes(ctx context.Context, sysRoot, devRoot string) ([]v4l2Node, error) {
+	entries, err := ioutil.ReadDir(sysRoot)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var nodes []v4l2Node
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "video") {
+			continue
+		}
+		dir := filepath.Join(sysRoot, entry.Name())
+		node := v4l2Node{
+			Path:   filepath.Join(devRoot, entry.Name()),
+			Name:   readSysfs(dir, "name"),
+			Parent: dir,
+		}
+		node.Index, _ = strconv.Atoi(readSysfs(dir, "index"))
+		if dev, err := filepath.EvalSymlinks(filepath.Join(dir, "device")); err == nil {
+			node.Parent = dev
+			if driver, err := os.Readlink(filepath.Join(dev, "driver")); err == nil {
+				node.Driver = filepath.Base(driver)
+			}
+			// USB cameras' nodes belong to an interface, like 1-2:1.0,
+			// in the directory of the USB device they're part of
+			usbDev := filepath.Dir(dev)
+			vendor, product := readSysfs(usbDev, "idVendor"), readSysfs(usbDev, "idProduct")
+			if vendor != "" && product != "" {
+				node.Parent = usbDev
+				node.USBID = vendor + ":" + product
+			}
+		}
+		driver, caps, err := queryCaps(node.Path)
+		if err != nil {
+			yall.FromContext(ctx).WithField("device", node.Path).WithError(err).Debug("can't query device capabilities")
+		} else {
+			node.Caps = caps
+			if driver != "" {
+				node.Driver = driver
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func readSysfs(dir, file string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// queryCaps returns the driver name and device capabilities of the video
+// node at path.
+func queryCaps(path string) (string, uint32, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	var c v4l2Capability
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), vidiocQueryCap, uintptr(unsafe.Pointer(&c)))
+	if errno != 0 {
+		return "", 0, errno
+	}
+	caps := c.Capabilities
+	if caps&capDeviceCaps != 0 {
+		caps = c.DeviceCaps
+	}
+	driver := c.Driver[:]
+	if end := bytes.IndexByte(driver, 0); end >= 0 {
+		driver = driver[:end]
+	}
+	return string(driver), caps, nil
+}
+
+// groupCameras groups capture nodes into the physical cameras they belong
+// to, leaving out every other kind of node.
+func groupCameras(nodes []v4l2Node) []Device {
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodeNumber(nodes[i].Path) < nodeNumber(nodes[j].Path)
+	})
+	byParent := map[string]int{}
+	var devices []Device
+	for _, node := range nodes {
+		if !node.capture() {
+			continue
+		}
+		if pos, ok := byParent[node.Parent]; ok {
+			devices[pos].Nodes = append(devices[pos].Nodes, node.Path)
+			continue
+		}
+		byParent[node.Parent] = len(devices)
+		devices = append(devices, Device{
+			Name:   node.Name,
+			Path:   node.Path,
+			Nodes:  []string{node.Path},
+			USBID:  node.USBID,
+			Driver: node.Driver,
+		})
+	}
+	return devices
+}
+
+// nodeNumber is the N in /dev/videoN, so video10 sorts after video9.
+func nodeNumber(path string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(path), "video"))
+	if err != nil {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,93 @@
+package device
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestListV4L2Nodes(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	usb := filepath.Join(dir, "sys/devices/pci0000:00/usb1/1-2")
+	loopback := filepath.Join(dir, "sys/devices/virtual/video4linux/video10")
+	writeFiles(t, dir, map[string]string{
+		// drivers, for devices to link to
+		"sys/bus/usb/drivers/uvcvideo/bind":              "",
+		"sys/bus/platform/drivers/v4l2loopback/bind":     "",
+		"sys/devices/pci0000:00/usb1/1-2/idVendor":       "046d\n",
+		"sys/devices/pci0000:00/usb1/1-2/idProduct":      "085e\n",
+		"sys/devices/pci0000:00/usb1/1-2/1-2:1.0/driver": "->" + filepath.Join(dir, "sys/bus/usb/drivers/uvcvideo"),
+		"sys/devices/virtual/video4linux/video10/driver": "->" + filepath.Join(dir, "sys/bus/platform/drivers/v4l2loopback"),
+
+		// a UVC camera's capture and metadata nodes
+		"sys/class/video4linux/video0/name":   "Logitech BRIO\n",
+		"sys/class/video4linux/video0/index":  "0\n",
+		"sys/class/video4linux/video0/device": "->" + filepath.Join(usb, "1-2:1.0"),
+		"sys/class/video4linux/video1/name":   "Logitech BRIO\n",
+		"sys/class/video4linux/video1/index":  "1\n",
+		"sys/class/video4linux/video1/device": "->" + filepath.Join(usb, "1-2:1.0"),
+		// a virtual camera
+		"sys/class/video4linux/video10/name":   "OBS Virtual Camera\n",
+		"sys/class/video4linux/video10/index":  "0\n",
+		"sys/class/video4linux/video10/device": "->" + loopback,
+		// not a video node
+		"sys/class/video4linux/v4l-subdev0/name": "imx219\n",
+	})
+
+	// the device nodes don't exist, so their capabilities can't be
+	// queried, and the first node of each device is the capture node
+	dev := filepath.Join(dir, "dev")
+	nodes, err := listV4L2Nodes(context.Background(), filepath.Join(dir, "sys/class/video4linux"), dev)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedNodes := []v4l2Node{
+		{Path: filepath.Join(dev, "video0"), Name: "Logitech BRIO", Driver: "uvcvideo", Parent: usb, USBID: "046d:085e"},
+		{Path: filepath.Join(dev, "video1"), Name: "Logitech BRIO", Index: 1, Driver: "uvcvideo", Parent: usb, USBID: "046d:085e"},
+		{Path: filepath.Join(dev, "video10"), Name: "OBS Virtual Camera", Driver: "v4l2loopback", Parent: loopback},
+	}
+	if !reflect.DeepEqual(nodes, expectedNodes) {
+		t.Errorf("expected %+v, got %+v", expectedNodes, nodes)
+	}
+
+	expected := []Device{
+		{Name: "Logitech BRIO", Path: filepath.Join(dev, "video0"), Nodes: []string{filepath.Join(dev, "video0")}, USBID: "046d:085e", Driver: "uvcvideo"},
+		{Name: "OBS Virtual Camera", Path: filepath.Join(dev, "video10"), Nodes: []string{filepath.Join(dev, "video10")}, Driver: "v4l2loopback"},
+	}
+	if devices := groupCameras(nodes); !reflect.DeepEqual(devices, expected) {
+		t.Errorf("expected %+v, got %+v", expected, devices)
+	}
+}
+
+func TestListV4L2NodesNoVideo4Linux(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	nodes, err := listV4L2Nodes(context.Background(), filepath.Join(dir, "video4linux"), filepath.Join(dir, "dev"))
+	if err != nil || len(nodes) != 0 {
+		t.Errorf("expected no nodes, got %+v, %v", nodes, err)
+	}
+}
+
+func TestGroupCameras(t *testing.T) {
+	const metadata = 0x00800000
+	nodes := []v4l2Node{
+		// out of order, so video10 has to sort after video9
+		{Path: "/dev/video10", Name: "Camera B", Caps: capVideoCapture, Parent: "usb-2", USBID: "2222:0002"},
+		{Path: "/dev/video9", Name: "Camera A", Caps: capVideoCapture, Parent: "usb-1", USBID: "1111:0001", Driver: "uvcvideo"},
+		{Path: "/dev/video11", Name: "Camera B", Caps: metadata, Index: 1, Parent: "usb-2"},
+		// a camera with a second capture node, like an IR sensor
+		{Path: "/dev/video12", Name: "Camera A IR", Caps: capVideoCaptureMplane, Index: 2, Parent: "usb-1"},
+		// a hardware codec
+		{Path: "/dev/video0", Name: "bcm2835-codec-decode", Caps: capVideoM2M | capVideoCapture, Parent: "platform"},
+	}
+	expected := []Device{
+		{Name: "Camera A", Path: "/dev/video9", Nodes: []string{"/dev/video9", "/dev/video12"}, USBID: "1111:0001", Driver: "uvcvideo"},
+		{Name: "Camera B", Path: "/dev/video10", Nodes: []string{"/dev/video10"}, USBID: "2222:0002"},
+	}
+	if devices := groupCameras(nodes); !reflect.DeepEqual(devices, expected) {
+		t.Errorf("expected %+v, got %+v", expected, devices)
+	}
+}
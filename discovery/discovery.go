@@ -0,0 +1,366 @@
+// Package discovery lets camctl find camera-signd on the local network,
+// using DNS-SD over multicast DNS.
+//
+// It only implements as much of RFC 6762 and RFC 6763 as that needs: a
+// responder that answers questions about a single service, and a browser
+// that asks for every instance of the service type.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// ServiceType is the DNS-SD service type camera-signd advertises.
+	ServiceType = "_camera-sign._tcp"
+
+	// Domain is the multicast DNS domain.
+	Domain = "local."
+
+	// ttl is how long, in seconds, other hosts can cache our records.
+	ttl = 120
+)
+
+// Group is the IPv4 multicast DNS group and port.
+var Group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// serviceName is the fully qualified name to browse for.
+var serviceName = ServiceType + "." + Domain
+
+// Service is an instance of camera-signd on the network.
+type Service struct {
+	// Instance is the human-readable name of the instance, like
+	// "camera-signd on peter". It can't contain dots.
+	Instance string
+	// Host is the fully qualified hostname, like "peter.local.".
+	Host string
+	Port int
+	// Text holds "key=value" pairs describing the service.
+	Text []string
+	IPs  []net.IP
+}
+
+// TextValue returns the value of key in the service's TXT record, or ""
+// if it's not set.
+func (s Service) TextValue(key string) string {
+	for _, kv := range s.Text {
+		k, v := kv, ""
+		if pos := strings.IndexByte(kv, '='); pos >= 0 {
+			k, v = kv[:pos], kv[pos+1:]
+		}
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+func (s Service) name() string {
+	return s.Instance + "." + serviceName
+}
+
+// LocalService describes a service running on this machine, listening on
+// port. Its instance name and hostname come from os.Hostname, and its
+// addresses from the machine's up, non-loopback interfaces.
+func LocalService(port int, text ...string) (Service, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return Service{}, fmt.Errorf("error getting hostname: %w", err)
+	}
+	hostname = strings.SplitN(hostname, ".", 2)[0]
+	svc := Service{
+		Instance: "camera-signd on " + hostname,
+		Host:     hostname + "." + Domain,
+		Port:     port,
+		Text:     text,
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return Service{}, fmt.Errorf("error listing network interfaces: %w", err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			svc.IPs = append(svc.IPs, ipnet.IP)
+		}
+	}
+	return svc, nil
+}
+
+// ListenMulticast opens a socket that receives multicast DNS queries.
+func ListenMulticast() (*net.UDPConn, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, Group)
+	if err != nil {
+		return nil, fmt.Errorf("error joining multicast DNS group: %w", err)
+	}
+	return conn, nil
+}
+
+// Advertise answers queries for svc that arrive on conn, until ctx is
+// cancelled. When it starts, it announces svc to group; multicast answers
+// go to group too.
+//
+// Queries from a port other than 5353 are answered directly, as RFC 6762
+// asks of one-shot queriers like Browse. That's also what makes it
+// possible to run a responder and a browser on the loopback interface,
+// by passing a conn listening on 127.0.0.1 and browsing its address.
+func Advertise(ctx context.Context, conn *net.UDPConn, group *net.UDPAddr, svc Service) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	// announce twice, a second apart, per section 8.3 of RFC 6762
+	go func() {
+		for i := 0; i < 2; i++ {
+			b, err := message{Flags: flagResponse | flagAuthoritative, Answers: svc.records(typePTR, true)}.pack()
+			if err == nil {
+				conn.WriteToUDP(b, group)
+			}
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("error reading multicast DNS query: %w", err)
+		}
+		query, err := parseMessage(buf[:n])
+		if err != nil || query.Flags&flagResponse != 0 {
+			continue
+		}
+		legacy := from.Port != Group.Port
+		resp := svc.answer(query, legacy)
+		if len(resp.Answers) == 0 {
+			continue
+		}
+		b, err := resp.pack()
+		if err != nil {
+			return fmt.Errorf("error encoding multicast DNS response: %w", err)
+		}
+		to := group
+		if legacy || unicastRequested(query) {
+			to = from
+		}
+		conn.WriteToUDP(b, to)
+	}
+}
+
+func unicastRequested(query message) bool {
+	for _, q := range query.Questions {
+		if q.Class&classTopBit == 0 {
+			return false
+		}
+	}
+	return len(query.Questions) > 0
+}
+
+// answer builds the response to query. Legacy unicast responses echo the
+// query's ID and questions, and leave out the cache-flush bit.
+func (s Service) answer(query message, legacy bool) message {
+	resp := message{Flags: flagResponse | flagAuthoritative}
+	if legacy {
+		resp.ID = query.ID
+		resp.Questions = query.Questions
+	}
+	for _, q := range query.Questions {
+		if q.Class&classMask != classIN && q.Class&classMask != classANY {
+			continue
+		}
+		var rtype uint16
+		switch {
+		case sameName(q.Name, serviceName) && (q.Type == typePTR || q.Type == typeANY):
+			rtype = typePTR
+		case sameName(q.Name, s.name()) && (q.Type == typeSRV || q.Type == typeTXT || q.Type == typeANY):
+			rtype = q.Type
+			if rtype == typeANY {
+				rtype = typeSRV
+			}
+		case sameName(q.Name, s.Host) && (q.Type == typeA || q.Type == typeAAAA || q.Type == typeANY):
+			rtype = q.Type
+		default:
+			continue
+		}
+		records := s.records(rtype, !legacy)
+		if len(records) == 0 {
+			continue
+		}
+		resp.Answers = append(resp.Answers, records[0])
+		resp.Additionals = append(resp.Additionals, records[1:]...)
+		if rtype == typeA || rtype == typeAAAA || rtype == typeANY {
+			// the host question's answers are all the addresses
+			resp.Answers = append(resp.Answers, resp.Additionals...)
+			resp.Additionals = nil
+		}
+	}
+	return resp
+}
+
+// records returns the record answering a question of type rtype, followed
+// by the records that are useful alongside it.
+func (s Service) records(rtype uint16, cacheFlush bool) []record {
+	unique := uint16(classIN)
+	if cacheFlush {
+		unique |= classTopBit
+	}
+	ptr := record{Name: serviceName, Type: typePTR, Class: classIN, TTL: ttl, Target: s.name()}
+	srv := record{Name: s.name(), Type: typeSRV, Class: unique, TTL: ttl, Target: s.Host, Port: uint16(s.Port)}
+	txt := record{Name: s.name(), Type: typeTXT, Class: unique, TTL: ttl, Text: s.Text}
+	var addrs []record
+	for _, ip := range s.IPs {
+		if (rtype == typeA && ip.To4() == nil) || (rtype == typeAAAA && ip.To4() != nil) {
+			continue
+		}
+		addrType := uint16(typeAAAA)
+		if ip.To4() != nil {
+			addrType = typeA
+		}
+		addrs = append(addrs, record{Name: s.Host, Type: addrType, Class: unique, TTL: ttl, IP: ip})
+	}
+	switch rtype {
+	case typePTR:
+		return append([]record{ptr, srv, txt}, addrs...)
+	case typeSRV:
+		return append([]record{srv, txt}, addrs...)
+	case typeTXT:
+		return []record{txt}
+	default:
+		return addrs
+	}
+}
+
+// Browse asks addr, usually Group, for every instance of ServiceType, and
+// returns the ones that answered before ctx is done. If ctx has no
+// deadline, Browse waits two seconds.
+func Browse(ctx context.Context, addr *net.UDPAddr) ([]Service, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+	}
+	network := "udp4"
+	if addr.IP.To4() == nil {
+		network = "udp6"
+	}
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error opening socket: %w", err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	err = conn.SetReadDeadline(deadline)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.SetReadDeadline(time.Now())
+	}()
+
+	query, err := message{Questions: []question{{
+		Name:  serviceName,
+		Type:  typePTR,
+		Class: classIN | classTopBit,
+	}}}.pack()
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.WriteToUDP(query, addr)
+	if err != nil {
+		return nil, fmt.Errorf("error sending multicast DNS query: %w", err)
+	}
+
+	var records []record
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			return nil, fmt.Errorf("error reading multicast DNS response: %w", err)
+		}
+		resp, err := parseMessage(buf[:n])
+		if err != nil || resp.Flags&flagResponse == 0 {
+			continue
+		}
+		records = append(records, resp.records()...)
+	}
+	return collect(records), nil
+}
+
+// collect assembles the services described by records.
+func collect(records []record) []Service {
+	var services []Service
+	for _, ptr := range records {
+		if ptr.Type != typePTR || !sameName(ptr.Name, serviceName) {
+			continue
+		}
+		duplicate := false
+		for _, svc := range services {
+			if sameName(svc.name(), ptr.Target) {
+				duplicate = true
+			}
+		}
+		if duplicate {
+			continue
+		}
+		svc := Service{Instance: strings.TrimSuffix(strings.TrimSuffix(ptr.Target, "."), "."+strings.TrimSuffix(serviceName, "."))}
+		for _, r := range records {
+			if !sameName(r.Name, ptr.Target) {
+				continue
+			}
+			switch r.Type {
+			case typeSRV:
+				svc.Host, svc.Port = r.Target, int(r.Port)
+			case typeTXT:
+				svc.Text = r.Text
+			}
+		}
+		if svc.Port == 0 {
+			continue
+		}
+		for _, r := range records {
+			if (r.Type == typeA || r.Type == typeAAAA) && sameName(r.Name, svc.Host) && !containsIP(svc.IPs, r.IP) {
+				svc.IPs = append(svc.IPs, r.IP)
+			}
+		}
+		services = append(services, svc)
+	}
+	return services
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, candidate := range ips {
+		if candidate.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testService() Service {
+	return Service{
+		Instance: "camera-signd on peter",
+		Host:     "peter.local.",
+		Port:     8080,
+		Text:     []string{"path=/v1", "tls=1"},
+		IPs:      []net.IP{net.IPv4(192, 168, 1, 20).To4(), net.ParseIP("fe80::1")},
+	}
+}
+
+func listenLoopback(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unexpected error listening: %s", err)
+	}
+	return conn
+}
+
+func TestAdvertiseBrowseLoopback(t *testing.T) {
+	responder := listenLoopback(t)
+	// announcements are multicast to the group, which here is just
+	// another socket on loopback
+	group := listenLoopback(t)
+	defer group.Close()
+	svc := testService()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- Advertise(ctx, responder, group.LocalAddr().(*net.UDPAddr), svc)
+	}()
+
+	group.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 9000)
+	n, _, err := group.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("unexpected error reading the announcement: %s", err)
+	}
+	announcement, err := parseMessage(buf[:n])
+	if err != nil {
+		t.Fatalf("unexpected error parsing the announcement: %s", err)
+	}
+	if announcement.Flags&flagResponse == 0 {
+		t.Errorf("expected the announcement to be a response, got flags %x", announcement.Flags)
+	}
+	expected := []Service{svc}
+	if got := collect(announcement.records()); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected announcement of %+v, got %+v", expected, got)
+	}
+
+	browseCtx, browseCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer browseCancel()
+	services, err := Browse(browseCtx, responder.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("unexpected error browsing: %s", err)
+	}
+	if !reflect.DeepEqual(services, expected) {
+		t.Errorf("expected %+v, got %+v", expected, services)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error advertising: %s", err)
+	}
+}
+
+func TestServiceAnswer(t *testing.T) {
+	svc := testService()
+	cases := map[string]struct {
+		question question
+		legacy   bool
+		answers  []uint16
+		extra    []uint16
+	}{
+		"browse": {
+			question: question{Name: "_camera-sign._tcp.local.", Type: typePTR, Class: classIN},
+			answers:  []uint16{typePTR},
+			extra:    []uint16{typeSRV, typeTXT, typeA, typeAAAA},
+		},
+		"instance": {
+			question: question{Name: "Camera-Signd on Peter._camera-sign._tcp.local.", Type: typeANY, Class: classIN},
+			answers:  []uint16{typeSRV},
+			extra:    []uint16{typeTXT, typeA, typeAAAA},
+		},
+		"text": {
+			question: question{Name: "camera-signd on peter._camera-sign._tcp.local", Type: typeTXT, Class: classIN},
+			answers:  []uint16{typeTXT},
+		},
+		"ipv4": {
+			question: question{Name: "peter.local.", Type: typeA, Class: classIN | classTopBit},
+			answers:  []uint16{typeA},
+		},
+		"ipv6": {
+			question: question{Name: "peter.local.", Type: typeAAAA, Class: classIN},
+			answers:  []uint16{typeAAAA},
+		},
+		"legacy": {
+			question: question{Name: "_camera-sign._tcp.local.", Type: typePTR, Class: classIN},
+			legacy:   true,
+			answers:  []uint16{typePTR},
+			extra:    []uint16{typeSRV, typeTXT, typeA, typeAAAA},
+		},
+		"other-service": {
+			question: question{Name: "_http._tcp.local.", Type: typePTR, Class: classIN},
+		},
+		"other-class": {
+			question: question{Name: "_camera-sign._tcp.local.", Type: typePTR, Class: 3},
+		},
+	}
+	types := func(records []record) []uint16 {
+		var types []uint16
+		for _, r := range records {
+			types = append(types, r.Type)
+		}
+		return types
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			query := message{ID: 7, Questions: []question{c.question}}
+			resp := svc.answer(query, c.legacy)
+			if got := types(resp.Answers); !reflect.DeepEqual(got, c.answers) {
+				t.Errorf("expected answers %+v, got %+v", c.answers, got)
+			}
+			if got := types(resp.Additionals); !reflect.DeepEqual(got, c.extra) {
+				t.Errorf("expected additionals %+v, got %+v", c.extra, got)
+			}
+			if c.legacy {
+				if resp.ID != query.ID || !reflect.DeepEqual(resp.Questions, query.Questions) {
+					t.Errorf("expected the query's ID and questions to be echoed, got %+v", resp)
+				}
+			}
+			for _, r := range resp.records() {
+				if r.Type != typePTR && (r.Class&classTopBit != 0) == c.legacy {
+					t.Errorf("expected the cache-flush bit to be %t on %+v", !c.legacy, r)
+				}
+			}
+		})
+	}
+}
+
+func TestUnicastRequested(t *testing.T) {
+	unicast := question{Name: "peter.local.", Type: typeA, Class: classIN | classTopBit}
+	multicast := question{Name: "peter.local.", Type: typeA, Class: classIN}
+	cases := map[string]struct {
+		questions []question
+		expected  bool
+	}{
+		"none":      {},
+		"unicast":   {questions: []question{unicast}, expected: true},
+		"multicast": {questions: []question{multicast}},
+		"mixed":     {questions: []question{unicast, multicast}},
+	}
+	for name, c := range cases {
+		if got := unicastRequested(message{Questions: c.questions}); got != c.expected {
+			t.Errorf("%s: expected %+v, got %+v", name, c.expected, got)
+		}
+	}
+}
+
+func TestTextValue(t *testing.T) {
+	svc := Service{Text: []string{"path=/v1", "TLS=1", "flag", "empty="}}
+	cases := map[string]string{
+		"path":    "/v1",
+		"tls":     "1",
+		"flag":    "",
+		"empty":   "",
+		"missing": "",
+	}
+	for key, expected := range cases {
+		if got := svc.TextValue(key); got != expected {
+			t.Errorf("%s: expected %q, got %q", key, expected, got)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DNS record types used by DNS-SD.
+const (
+	typeA    = 1
+	typePTR  = 12
+	typeTXT  = 16
+	typeAAAA = 28
+	typeSRV  = 33
+	typeANY  = 255
+)
+
+const (
+	classIN  = 1
+	classANY = 255
+
+	// classMask strips the mDNS cache-flush or unicast-response bit from
+	// a class.
+	classMask = 0x7fff
+
+	// classTopBit is the cache-flush bit in records, and the
+	// unicast-response bit in questions.
+	classTopBit = 0x8000
+
+	flagResponse      = 0x8000
+	flagAuthoritative = 0x0400
+)
+
+var errMalformed = errors.New("malformed DNS message")
+
+type question struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+type record struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+
+	// Target is set for PTR and SRV records.
+	Target string
+	// Port is set for SRV records.
+	Port uint16
+	// Text is set for TXT records.
+	Text []string
+	// IP is set for A and AAAA records.
+	IP net.IP
+}
+
+type message struct {
+	ID          uint16
+	Flags       uint16
+	Questions   []question
+	Answers     []record
+	Additionals []record
+}
+
+// records returns every record in the message, answers first.
+func (m message) records() []record {
+	return append(append([]record{}, m.Answers...), m.Additionals...)
+}
+
+func (m message) pack() ([]byte, error) {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+	binary.BigEndian.PutUint16(b[2:], m.Flags)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Answers)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.Additionals)))
+	var err error
+	for _, q := range m.Questions {
+		b, err = appendName(b, q.Name)
+		if err != nil {
+			return nil, err
+		}
+		b = appendUint16(b, q.Type)
+		b = appendUint16(b, q.Class)
+	}
+	for _, r := range append(append([]record{}, m.Answers...), m.Additionals...) {
+		b, err = appendRecord(b, r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func appendRecord(b []byte, r record) ([]byte, error) {
+	b, err := appendName(b, r.Name)
+	if err != nil {
+		return nil, err
+	}
+	b = appendUint16(b, r.Type)
+	b = appendUint16(b, r.Class)
+	b = append(b, byte(r.TTL>>24), byte(r.TTL>>16), byte(r.TTL>>8), byte(r.TTL))
+	var data []byte
+	switch r.Type {
+	case typePTR:
+		data, err = appendName(nil, r.Target)
+	case typeSRV:
+		// priority and weight are always 0
+		data = []byte{0, 0, 0, 0, byte(r.Port >> 8), byte(r.Port)}
+		data, err = appendName(data, r.Target)
+	case typeTXT:
+		for _, t := range r.Text {
+			if len(t) > 255 {
+				return nil, fmt.Errorf("TXT string %q is too long", t)
+			}
+			data = append(data, byte(len(t)))
+			data = append(data, t...)
+		}
+		if len(data) == 0 {
+			data = []byte{0}
+		}
+	case typeA:
+		data = r.IP.To4()
+	case typeAAAA:
+		data = r.IP.To16()
+	default:
+		return nil, fmt.Errorf("can't encode record type %d", r.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b = appendUint16(b, uint16(len(data)))
+	return append(b, data...), nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// appendName encodes a dot-separated name, without compression.
+func appendName(b []byte, name string) ([]byte, error) {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		if len(label) > 63 {
+			return nil, fmt.Errorf("DNS label %q is too long", label)
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+func parseMessage(b []byte) (message, error) {
+	var m message
+	if len(b) < 12 {
+		return m, errMalformed
+	}
+	m.ID = binary.BigEndian.Uint16(b[0:])
+	m.Flags = binary.BigEndian.Uint16(b[2:])
+	qdcount := int(binary.BigEndian.Uint16(b[4:]))
+	ancount := int(binary.BigEndian.Uint16(b[6:]))
+	nscount := int(binary.BigEndian.Uint16(b[8:]))
+	arcount := int(binary.BigEndian.Uint16(b[10:]))
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		name, next, err := readName(b, off)
+		if err != nil {
+			return m, err
+		}
+		if next+4 > len(b) {
+			return m, errMalformed
+		}
+		m.Questions = append(m.Questions, question{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(b[next:]),
+			Class: binary.BigEndian.Uint16(b[next+2:]),
+		})
+		off = next + 4
+	}
+	for i := 0; i < ancount+nscount+arcount; i++ {
+		r, next, err := readRecord(b, off)
+		if err != nil {
+			return m, err
+		}
+		off = next
+		switch {
+		case i < ancount:
+			m.Answers = append(m.Answers, r)
+		case i >= ancount+nscount:
+			m.Additionals = append(m.Additionals, r)
+		}
+	}
+	return m, nil
+}
+
+func readRecord(b []byte, off int) (record, int, error) {
+	var r record
+	name, off, err := readName(b, off)
+	if err != nil {
+		return r, 0, err
+	}
+	if off+10 > len(b) {
+		return r, 0, errMalformed
+	}
+	r.Name = name
+	r.Type = binary.BigEndian.Uint16(b[off:])
+	r.Class = binary.BigEndian.Uint16(b[off+2:])
+	r.TTL = binary.BigEndian.Uint32(b[off+4:])
+	length := int(binary.BigEndian.Uint16(b[off+8:]))
+	off += 10
+	end := off + length
+	if end > len(b) {
+		return r, 0, errMalformed
+	}
+	data := b[off:end]
+	switch r.Type {
+	case typePTR:
+		r.Target, _, err = readName(b, off)
+	case typeSRV:
+		if length < 7 {
+			return r, 0, errMalformed
+		}
+		r.Port = binary.BigEndian.Uint16(data[4:])
+		r.Target, _, err = readName(b, off+6)
+	case typeTXT:
+		for i := 0; i < len(data); {
+			n := int(data[i])
+			if i+1+n > len(data) {
+				return r, 0, errMalformed
+			}
+			if n > 0 {
+				r.Text = append(r.Text, string(data[i+1:i+1+n]))
+			}
+			i += 1 + n
+		}
+	case typeA:
+		if length != net.IPv4len {
+			return r, 0, errMalformed
+		}
+		r.IP = net.IP(append([]byte{}, data...))
+	case typeAAAA:
+		if length != net.IPv6len {
+			return r, 0, errMalformed
+		}
+		r.IP = net.IP(append([]byte{}, data...))
+	}
+	if err != nil {
+		return r, 0, err
+	}
+	return r, end, nil
+}
+
+// readName decodes the name at off, following compression pointers. It
+// returns the name, with a trailing dot, and the offset just past it.
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, errMalformed
+		}
+		n := int(b[off])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(b) {
+				return "", 0, errMalformed
+			}
+			jumps++
+			if jumps > 16 {
+				return "", 0, errMalformed
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+		case n&0xc0 != 0:
+			return "", 0, errMalformed
+		default:
+			if off+1+n > len(b) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(b[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// sameName compares DNS names, which are case-insensitive, ignoring any
+// trailing dot.
+func sameName(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
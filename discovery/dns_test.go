@@ -0,0 +1,110 @@
+package discovery
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := message{
+		ID:    0x1234,
+		Flags: flagResponse | flagAuthoritative,
+		Questions: []question{
+			{Name: "_camera-sign._tcp.local.", Type: typePTR, Class: classIN | classTopBit},
+		},
+		Answers: []record{
+			{Name: "_camera-sign._tcp.local.", Type: typePTR, Class: classIN, TTL: ttl, Target: "camera-signd on peter._camera-sign._tcp.local."},
+		},
+		Additionals: []record{
+			{Name: "camera-signd on peter._camera-sign._tcp.local.", Type: typeSRV, Class: classIN | classTopBit, TTL: ttl, Target: "peter.local.", Port: 8080},
+			{Name: "camera-signd on peter._camera-sign._tcp.local.", Type: typeTXT, Class: classIN | classTopBit, TTL: ttl, Text: []string{"path=/v1", "tls=1"}},
+			{Name: "peter.local.", Type: typeA, Class: classIN | classTopBit, TTL: ttl, IP: net.IPv4(192, 168, 1, 20).To4()},
+			{Name: "peter.local.", Type: typeAAAA, Class: classIN | classTopBit, TTL: ttl, IP: net.ParseIP("fe80::1")},
+		},
+	}
+	b, err := m.pack()
+	if err != nil {
+		t.Fatalf("unexpected error packing: %s", err)
+	}
+	got, err := parseMessage(b)
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %s", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("expected %+v, got %+v", m, got)
+	}
+}
+
+func TestPackRejects(t *testing.T) {
+	long := make([]byte, 64)
+	for i := range long {
+		long[i] = 'a'
+	}
+	cases := map[string]record{
+		"long-label":   {Name: string(long) + ".local.", Type: typePTR, Target: "peter.local."},
+		"long-text":    {Name: "peter.local.", Type: typeTXT, Text: []string{string(long) + string(long) + string(long) + string(long)}},
+		"unknown-type": {Name: "peter.local.", Type: 99},
+	}
+	for name, r := range cases {
+		_, err := message{Answers: []record{r}}.pack()
+		if err == nil {
+			t.Errorf("%s: expected an error, got nil", name)
+		}
+	}
+}
+
+func TestReadName(t *testing.T) {
+	// "local." at 12, then "peter" pointing back to it at 19
+	b := append(make([]byte, 12), 5, 'l', 'o', 'c', 'a', 'l', 0, 5, 'p', 'e', 't', 'e', 'r', 0xc0, 12)
+	cases := map[string]struct {
+		off  int
+		name string
+		next int
+		err  bool
+	}{
+		"plain":      {off: 12, name: "local.", next: 19},
+		"compressed": {off: 19, name: "peter.local.", next: 27},
+		"truncated":  {off: 26, err: true},
+		"past-end":   {off: 27, err: true},
+	}
+	for name, c := range cases {
+		got, next, err := readName(b, c.off)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %q", name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+			continue
+		}
+		if got != c.name || next != c.next {
+			t.Errorf("%s: expected %q ending at %d, got %q ending at %d", name, c.name, c.next, got, next)
+		}
+	}
+}
+
+func TestReadNamePointerLoop(t *testing.T) {
+	b := append(make([]byte, 12), 0xc0, 12)
+	_, _, err := readName(b, 12)
+	if err != errMalformed {
+		t.Errorf("expected %+v, got %+v", errMalformed, err)
+	}
+}
+
+func TestParseMessageTruncated(t *testing.T) {
+	svc := Service{Instance: "camera-signd on peter", Host: "peter.local.", Port: 8080, Text: []string{"tls=1"}, IPs: []net.IP{net.IPv4(192, 168, 1, 20)}}
+	b, err := message{Flags: flagResponse, Answers: svc.records(typePTR, true)}.pack()
+	if err != nil {
+		t.Fatalf("unexpected error packing: %s", err)
+	}
+	// every prefix of a message is missing something it says it has
+	for n := 0; n < len(b); n++ {
+		_, err := parseMessage(b[:n])
+		if err == nil {
+			t.Errorf("expected an error parsing the first %d bytes, got nil", n)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Package logging builds the yall loggers shared by camctl and
+// camera-signd, so both can be configured the same way.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"yall.in"
+	"yall.in/colour"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var severityRanks = map[yall.Severity]int{
+	yall.Default: 0,
+	yall.Debug:   1,
+	yall.Info:    2,
+	yall.Warning: 3,
+	yall.Error:   4,
+}
+
+// ParseLevel validates a log level, defaulting to INFO when level is empty.
+func ParseLevel(level string) (yall.Severity, error) {
+	if level == "" {
+		return yall.Info, nil
+	}
+	severity := yall.Severity(strings.ToUpper(level))
+	if _, ok := severityRanks[severity]; !ok {
+		return "", fmt.Errorf("unknown log level %q; must be %q, %q, %q, or %q",
+			level, yall.Debug, yall.Info, yall.Warning, yall.Error)
+	}
+	return severity, nil
+}
+
+// New returns a logger writing entries of at least level to w, as
+// colourised text or as one JSON object per line, depending on format.
+func New(w io.Writer, level, format string) (*yall.Logger, error) {
+	severity, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case "", FormatText:
+		return yall.New(colour.New(w, severity)), nil
+	case FormatJSON:
+		return yall.New(&jsonSink{w: w, level: severity}), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q; must be %q or %q", format, FormatText, FormatJSON)
+	}
+}
+
+// jsonSink writes entries as JSON, one per line, so they can be shipped to
+// a log aggregator.
+type jsonSink struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level yall.Severity
+}
+
+func (s *jsonSink) AddEntry(e yall.Entry) {
+	if severityRanks[e.Severity] < severityRanks[s.level] {
+		return
+	}
+	out := make(map[string]interface{}, len(e.Payload)+2)
+	for k, v := range e.Payload {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		out[k] = v
+	}
+	out["time"] = e.LoggedAt.Format(time.RFC3339Nano)
+	out["severity"] = e.Severity
+	b, err := json.Marshal(out)
+	if err != nil {
+		b, _ = json.Marshal(map[string]interface{}{
+			"time":     e.LoggedAt.Format(time.RFC3339Nano),
+			"severity": yall.Error,
+			"msg":      "error encoding log entry: " + err.Error(),
+		})
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Write(append(b, '\n'))
+}
+
+func (s *jsonSink) Flush() error {
+	return nil
+}